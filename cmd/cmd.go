@@ -16,6 +16,13 @@ func NewReverseProxyCommand() *cobra.Command {
 			if err := viper.BindPFlags(cmd.Flags()); err != nil {
 				return err
 			}
+			// Flags given on the command line take precedence over the config file
+			if configFile := viper.GetString("config"); configFile != "" {
+				viper.SetConfigFile(configFile)
+				if err := viper.ReadInConfig(); err != nil {
+					return err
+				}
+			}
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -38,5 +45,7 @@ func NewReverseProxyCommand() *cobra.Command {
 		},
 	}
 	opts.AddFlags(cmd)
+	cmd.AddCommand(NewSuggestPatternsCommand())
+	cmd.AddCommand(NewReplayCommand())
 	return cmd
 }
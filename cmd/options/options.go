@@ -2,14 +2,32 @@ package options
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/tae2089/reverse-proxy/internal/accesslog"
+	"github.com/tae2089/reverse-proxy/internal/capture"
+	"github.com/tae2089/reverse-proxy/internal/har"
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/observe"
+	"github.com/tae2089/reverse-proxy/internal/openapi"
+	"github.com/tae2089/reverse-proxy/internal/redact"
 	"github.com/tae2089/reverse-proxy/internal/server"
+	"github.com/tae2089/reverse-proxy/internal/server/controller"
+	"github.com/tae2089/reverse-proxy/internal/server/middleware"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"github.com/tae2089/reverse-proxy/internal/server/vhost"
+	"github.com/tae2089/reverse-proxy/internal/shadow"
+	"github.com/tae2089/reverse-proxy/internal/utils"
 )
 
 type Options struct {
+	ConfigFile      string
 	Port            int
 	MetricsPort     int
 	ShutdownTimeOut int
@@ -18,6 +36,103 @@ type Options struct {
 	Mode            string
 	UrlPatternStr   string
 	ApplicationName string
+	// Propagators used to extract incoming trace context
+	Propagators []string
+	// InjectPropagators used toward the upstream, defaults to Propagators
+	InjectPropagators []string
+	IncomingTrace     string
+	// Request ID header, generated when missing or invalid
+	RequestIDHeader         string
+	RequestIDAcceptIncoming bool
+	LogTraceFields          string
+	// Logger level, encoding, destinations and sampling
+	LogLevel              string
+	LogLevels             map[string]string
+	LogEncoding           string
+	LogOutputPaths        []string
+	LogErrorOutputPaths   []string
+	LogSamplingInitial    int
+	LogSamplingThereafter int
+	LogCaller             bool
+	// Access log format, destination and sampling
+	AccessLogFormat         string
+	AccessLogTemplate       string
+	AccessLogFields         []string
+	AccessLogOutput         string
+	AccessLogMaxSize        int
+	AccessLogMaxBackups     int
+	AccessLogMaxAge         int
+	AccessLogCompress       bool
+	AccessLogRotateInterval time.Duration
+	AccessLogSampleRatio    float64
+	// Debug capture of request and response bodies
+	CaptureSampleRatio  float64
+	CaptureRoutes       []string
+	CaptureStatuses     []string
+	CaptureDebugHeader  string
+	CaptureDebugSecret  string
+	CaptureMaxBodySize  int
+	CaptureContentTypes []string
+	CaptureOutput       string
+	CaptureMaxSize      int
+	CaptureMaxBackups   int
+	CaptureMaxAge       int
+	CaptureCompress     bool
+	// Shadow upstream of the default virtual host
+	ShadowTarget         string
+	ShadowPercent        float64
+	ShadowTimeout        time.Duration
+	ShadowMaxConcurrency int
+	ShadowMaxBodySize    int64
+	ShadowCompare        bool
+	ShadowCompareHeaders []string
+	ShadowCompareIgnore  []string
+	ShadowCompareKeep    int
+	// HAR recording of proxied traffic
+	HARDir            string
+	HARRoutes         []string
+	HARStatuses       []string
+	HARMaxBodySize    int
+	HARMaxSize        int
+	HARRotateInterval time.Duration
+	HARMaxFiles       int
+	// Redaction of logs and span attributes
+	RedactHeaders      []string
+	RedactAllowHeaders []string
+	RedactQueryParams  []string
+	RedactPatterns     []string
+	// Metrics naming and labels
+	MetricsNamespace       string
+	MetricsSubsystem       string
+	MetricsConstLabels     map[string]string
+	MetricsBuckets         []string
+	MetricsNativeHistogram float64
+	MetricsExtraLabels     []string
+	MetricsMaxPathValues   int
+	MetricsCollapsePaths   bool
+	// PatternDiscovery observes unmatched paths to suggest url patterns
+	PatternDiscovery          bool
+	PatternDiscoveryThreshold int
+	// OpenAPI document used to fill url patterns
+	OpenAPIFile         string
+	OpenAPILabel        string
+	OpenAPIUndocumented string
+	// OpenAPI request and response validation
+	OpenAPIValidation          string
+	OpenAPIValidationRoutes    map[string]string
+	OpenAPIResponseSampleRatio float64
+	OpenAPIMaxBodySize         int64
+	// UpstreamHeaders are go templates of headers sent to the upstream
+	UpstreamHeaders map[string]string
+	// Header scrubbing of requests and responses
+	ScrubHeaders      []string
+	ScrubServerHeader bool
+	// Routes are only read from the config file
+	Routes []route.Config
+	// VirtualHosts are only read from the config file
+	VirtualHosts []vhost.Config
+	TLSCertFile  string
+	TLSKeyFile   string
 }
 
 func New() *Options {
@@ -25,8 +140,141 @@ func New() *Options {
 }
 
 func (o *Options) Validate() error {
-	if o.TargetHost == "" {
-		return errors.New("target-host is required, please provide a target host. example: --target-host=http://localhost:8080")
+	if o.TargetHost == "" && len(o.VirtualHosts) == 0 {
+		return errors.New("target-host is required unless virtual-hosts are configured, please provide a target host. example: --target-host=http://localhost:8080")
+	}
+	if err := vhost.Validate(o.VirtualHosts); err != nil {
+		return err
+	}
+	if err := o.shadowConfig().Validate(); err != nil {
+		return err
+	}
+	if o.ShadowCompareKeep < 0 {
+		return errors.New("shadow-compare-keep must not be negative")
+	}
+	for _, vhostConfig := range o.VirtualHosts {
+		if vhostConfig.Default && o.TargetHost != "" {
+			return fmt.Errorf("virtual host %q can not be the default, target-host already serves unknown hosts", vhostConfig.DisplayName())
+		}
+		if err := vhostConfig.Shadow.Inherit(o.shadowConfig()).Validate(); err != nil {
+			return errors.Join(fmt.Errorf("invalid shadow of virtual host %q", vhostConfig.DisplayName()), err)
+		}
+	}
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return errors.New("tls-cert-file and tls-key-file must be given together")
+	}
+	if err := o.validateTLS(); err != nil {
+		return err
+	}
+	for _, pattern := range strings.Split(o.UrlPatternStr, ",") {
+		if err := utils.ValidatePattern(pattern); err != nil {
+			return err
+		}
+	}
+	if _, err := observe.NewPropagator(o.propagationConfig()); err != nil {
+		return err
+	}
+	if err := observe.ValidateIncomingTrace(o.IncomingTrace); err != nil {
+		return err
+	}
+	if err := o.logConfig().Validate(); err != nil {
+		return err
+	}
+	if err := log.ValidateTraceFields(o.LogTraceFields); err != nil {
+		return err
+	}
+	if err := o.accessLogConfig().Validate(); err != nil {
+		return err
+	}
+	if _, err := redact.New(o.redactionConfig()); err != nil {
+		return err
+	}
+	if err := o.captureConfig().Validate(); err != nil {
+		return err
+	}
+	if o.HARDir != "" {
+		if err := o.harConfig().Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := parseBuckets(o.MetricsBuckets); err != nil {
+		return err
+	}
+	if err := middleware.ValidateExtraLabels(o.MetricsExtraLabels); err != nil {
+		return err
+	}
+	if err := middleware.ValidateConstLabels(o.MetricsConstLabels); err != nil {
+		return err
+	}
+	if o.PatternDiscovery && o.PatternDiscoveryThreshold < 1 {
+		return errors.New("pattern-discovery-threshold must be greater than 0")
+	}
+	if o.PatternDiscovery && o.DisableMetrics {
+		return errors.New("pattern-discovery serves its suggestions on the metrics listener, it can not be used with disable-metrics")
+	}
+	if o.DisableMetrics && o.hasSplits() {
+		return errors.New("the canary and blue/green admin endpoints are served on the metrics listener, upstream group splits can not be used with disable-metrics")
+	}
+	if err := openapi.ValidateLabel(o.OpenAPILabel); err != nil {
+		return err
+	}
+	if err := openapi.ValidateUndocumented(o.OpenAPIUndocumented); err != nil {
+		return err
+	}
+	if err := o.openAPIValidationConfig().Validate(); err != nil {
+		return err
+	}
+	if _, err := controller.ParseHeaderTemplates(o.UpstreamHeaders); err != nil {
+		return err
+	}
+	if _, err := route.NewTable(o.Routes); err != nil {
+		return err
+	}
+	return nil
+}
+
+// hasSplits reports whether a route, of the target host or of a virtual host,
+// splits its traffic between upstream groups
+func (o *Options) hasSplits() bool {
+	tables := [][]route.Config{o.Routes}
+	for _, vhostConfig := range o.VirtualHosts {
+		tables = append(tables, vhostConfig.Routes)
+	}
+	for _, routes := range tables {
+		for _, routeConfig := range routes {
+			if len(routeConfig.Split.Groups) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateTLS checks that every virtual host has a certificate once one of
+// them has, the listener serves TLS to every host
+func (o *Options) validateTLS() error {
+	defaultCertificate := o.TargetHost != "" && o.TLSCertFile != ""
+	certificates := o.TLSCertFile != ""
+	for _, vhostConfig := range o.VirtualHosts {
+		if vhostConfig.TLSCertFile == "" {
+			continue
+		}
+		certificates = true
+		if vhostConfig.Default {
+			defaultCertificate = true
+		}
+	}
+	// the certificate of the default virtual host is served to the hosts without one
+	if !certificates || defaultCertificate {
+		return nil
+	}
+	if o.TargetHost != "" {
+		return errors.New("target-host requires tls-cert-file and tls-key-file when a virtual host has a certificate, the listener serves TLS to every host")
+	}
+	for _, vhostConfig := range o.VirtualHosts {
+		if vhostConfig.TLSCertFile == "" {
+			return fmt.Errorf("virtual host %q requires a certificate, or the default virtual host one, when another virtual host has a certificate, the listener serves TLS to every host", vhostConfig.DisplayName())
+		}
 	}
 	return nil
 }
@@ -40,27 +288,362 @@ func (o *Options) Complete(args []string, cmd *cobra.Command) error {
 	o.DisableMetrics = viper.GetBool("disable-metrics")
 	o.UrlPatternStr = viper.GetString("url-patterns")
 	o.ApplicationName = viper.GetString("application-name")
+	o.Propagators = viper.GetStringSlice("propagators")
+	o.InjectPropagators = viper.GetStringSlice("inject-propagators")
+	o.IncomingTrace = viper.GetString("incoming-trace")
+	o.RequestIDHeader = viper.GetString("request-id-header")
+	o.RequestIDAcceptIncoming = viper.GetBool("request-id-accept-incoming")
+	o.LogTraceFields = viper.GetString("log-trace-fields")
+	o.LogLevel = viper.GetString("log-level")
+	o.LogLevels = viper.GetStringMapString("log-levels")
+	o.LogEncoding = viper.GetString("log-encoding")
+	o.LogOutputPaths = viper.GetStringSlice("log-output-paths")
+	o.LogErrorOutputPaths = viper.GetStringSlice("log-error-output-paths")
+	o.LogSamplingInitial = viper.GetInt("log-sampling-initial")
+	o.LogSamplingThereafter = viper.GetInt("log-sampling-thereafter")
+	o.LogCaller = viper.GetBool("log-caller")
+	o.AccessLogFormat = viper.GetString("access-log-format")
+	o.AccessLogTemplate = viper.GetString("access-log-template")
+	o.AccessLogFields = viper.GetStringSlice("access-log-fields")
+	o.AccessLogOutput = viper.GetString("access-log-output")
+	o.AccessLogMaxSize = viper.GetInt("access-log-max-size")
+	o.AccessLogMaxBackups = viper.GetInt("access-log-max-backups")
+	o.AccessLogMaxAge = viper.GetInt("access-log-max-age")
+	o.AccessLogCompress = viper.GetBool("access-log-compress")
+	o.AccessLogRotateInterval = viper.GetDuration("access-log-rotate-interval")
+	o.AccessLogSampleRatio = viper.GetFloat64("access-log-sample-ratio")
+	o.CaptureSampleRatio = viper.GetFloat64("capture-sample-ratio")
+	o.CaptureRoutes = viper.GetStringSlice("capture-routes")
+	o.CaptureStatuses = viper.GetStringSlice("capture-statuses")
+	o.CaptureDebugHeader = viper.GetString("capture-debug-header")
+	o.CaptureDebugSecret = viper.GetString("capture-debug-secret")
+	o.CaptureMaxBodySize = viper.GetInt("capture-max-body-size")
+	o.CaptureContentTypes = viper.GetStringSlice("capture-content-types")
+	o.CaptureOutput = viper.GetString("capture-output")
+	o.CaptureMaxSize = viper.GetInt("capture-max-size")
+	o.CaptureMaxBackups = viper.GetInt("capture-max-backups")
+	o.CaptureMaxAge = viper.GetInt("capture-max-age")
+	o.CaptureCompress = viper.GetBool("capture-compress")
+	o.ShadowTarget = viper.GetString("shadow-target")
+	o.ShadowPercent = viper.GetFloat64("shadow-percent")
+	o.ShadowTimeout = viper.GetDuration("shadow-timeout")
+	o.ShadowMaxConcurrency = viper.GetInt("shadow-max-concurrency")
+	o.ShadowMaxBodySize = viper.GetInt64("shadow-max-body-size")
+	o.ShadowCompare = viper.GetBool("shadow-compare")
+	o.ShadowCompareHeaders = viper.GetStringSlice("shadow-compare-headers")
+	o.ShadowCompareIgnore = viper.GetStringSlice("shadow-compare-ignore")
+	o.ShadowCompareKeep = viper.GetInt("shadow-compare-keep")
+	o.HARDir = viper.GetString("har-dir")
+	o.HARRoutes = viper.GetStringSlice("har-routes")
+	o.HARStatuses = viper.GetStringSlice("har-statuses")
+	o.HARMaxBodySize = viper.GetInt("har-max-body-size")
+	o.HARMaxSize = viper.GetInt("har-max-size")
+	o.HARRotateInterval = viper.GetDuration("har-rotate-interval")
+	o.HARMaxFiles = viper.GetInt("har-max-files")
+	o.RedactHeaders = viper.GetStringSlice("redact-headers")
+	o.RedactAllowHeaders = viper.GetStringSlice("redact-headers-allow")
+	o.RedactQueryParams = viper.GetStringSlice("redact-query-params")
+	o.RedactPatterns = viper.GetStringSlice("redact-patterns")
+	o.MetricsNamespace = viper.GetString("metrics-namespace")
+	o.MetricsSubsystem = viper.GetString("metrics-subsystem")
+	o.MetricsConstLabels = viper.GetStringMapString("metrics-const-labels")
+	o.MetricsBuckets = viper.GetStringSlice("metrics-buckets")
+	o.MetricsNativeHistogram = viper.GetFloat64("metrics-native-histogram-factor")
+	o.MetricsExtraLabels = viper.GetStringSlice("metrics-extra-labels")
+	o.MetricsMaxPathValues = viper.GetInt("metrics-max-path-values")
+	o.MetricsCollapsePaths = viper.GetBool("metrics-collapse-path-segments")
+	o.PatternDiscovery = viper.GetBool("pattern-discovery")
+	o.PatternDiscoveryThreshold = viper.GetInt("pattern-discovery-threshold")
+	o.OpenAPIFile = viper.GetString("openapi-file")
+	o.OpenAPILabel = viper.GetString("openapi-metrics-label")
+	o.OpenAPIUndocumented = viper.GetString("openapi-undocumented")
+	o.OpenAPIValidation = viper.GetString("openapi-validation")
+	o.OpenAPIValidationRoutes = viper.GetStringMapString("openapi-validation-routes")
+	o.OpenAPIResponseSampleRatio = viper.GetFloat64("openapi-response-sample-ratio")
+	o.OpenAPIMaxBodySize = viper.GetInt64("openapi-max-body-size")
+	o.UpstreamHeaders = viper.GetStringMapString("upstream-headers")
+	o.ScrubHeaders = viper.GetStringSlice("scrub-headers")
+	o.ScrubServerHeader = viper.GetBool("scrub-server-header")
+	if err := viper.UnmarshalKey("routes", &o.Routes); err != nil {
+		return errors.Join(errors.New("invalid routes in config file"), err)
+	}
+	o.TLSCertFile = viper.GetString("tls-cert-file")
+	o.TLSKeyFile = viper.GetString("tls-key-file")
+	if err := viper.UnmarshalKey("virtual-hosts", &o.VirtualHosts); err != nil {
+		return errors.Join(errors.New("invalid virtual-hosts in config file"), err)
+	}
 	return nil
 }
 
 func (o *Options) GetServerConfig() *server.Config {
 	return &server.Config{
-		Port:            o.Port,
-		EnableMetrics:   !o.DisableMetrics,
-		MetricsPort:     o.MetricsPort,
-		TargetHost:      o.TargetHost,
-		ShutdownTimeOut: time.Duration(o.ShutdownTimeOut) * time.Second,
-		UrlPatternStr:   o.UrlPatternStr,
+		Port:             o.Port,
+		EnableMetrics:    !o.DisableMetrics,
+		MetricsPort:      o.MetricsPort,
+		TargetHost:       o.TargetHost,
+		ShutdownTimeOut:  time.Duration(o.ShutdownTimeOut) * time.Second,
+		UrlPatternStr:    o.UrlPatternStr,
+		ApplicationName:  o.ApplicationName,
+		Propagation:      o.propagationConfig(),
+		Log:              o.logConfig(),
+		LogTraceFields:   o.LogTraceFields,
+		AccessLog:        o.accessLogConfig(),
+		Redaction:        o.redactionConfig(),
+		Capture:          o.captureConfig(),
+		HAR:              o.harConfig(),
+		Shadow:           o.shadowConfig(),
+		ShadowMismatches: o.ShadowCompareKeep,
+		RequestID: middleware.RequestIDConfig{
+			Header:         o.RequestIDHeader,
+			AcceptIncoming: o.RequestIDAcceptIncoming,
+		},
+		Metrics:                   o.metricsConfig(),
+		PatternDiscoveryThreshold: o.patternDiscoveryThreshold(),
+		OpenAPIFile:               o.OpenAPIFile,
+		OpenAPILabel:              o.OpenAPILabel,
+		OpenAPIUndocumented:       o.OpenAPIUndocumented,
+		OpenAPIValidation:         o.openAPIValidationConfig(),
+		UpstreamHeaders:           o.UpstreamHeaders,
+		HeaderScrubbing: controller.HeaderScrubbing{
+			HopByHop: o.ScrubHeaders,
+			Server:   o.ScrubServerHeader,
+		},
+		Routes:       o.Routes,
+		VirtualHosts: o.VirtualHosts,
+		TLSCertFile:  o.TLSCertFile,
+		TLSKeyFile:   o.TLSKeyFile,
+	}
+}
+
+func (o *Options) openAPIValidationConfig() openapi.ValidationConfig {
+	return openapi.ValidationConfig{
+		Mode:                o.OpenAPIValidation,
+		RouteModes:          o.OpenAPIValidationRoutes,
+		ResponseSampleRatio: o.OpenAPIResponseSampleRatio,
+		MaxBodyBytes:        o.OpenAPIMaxBodySize,
+	}
+}
+
+func (o *Options) logConfig() log.Config {
+	return log.Config{
+		Level:              o.LogLevel,
+		Levels:             o.LogLevels,
+		Encoding:           o.LogEncoding,
+		OutputPaths:        o.LogOutputPaths,
+		ErrorOutputPaths:   o.LogErrorOutputPaths,
+		SamplingInitial:    o.LogSamplingInitial,
+		SamplingThereafter: o.LogSamplingThereafter,
+		Caller:             o.LogCaller,
+	}
+}
+
+func (o *Options) accessLogConfig() accesslog.Config {
+	return accesslog.Config{
+		Format:         o.AccessLogFormat,
+		Template:       o.AccessLogTemplate,
+		Fields:         o.AccessLogFields,
+		Output:         o.AccessLogOutput,
+		MaxSizeMB:      o.AccessLogMaxSize,
+		MaxBackups:     o.AccessLogMaxBackups,
+		MaxAgeDays:     o.AccessLogMaxAge,
+		Compress:       o.AccessLogCompress,
+		RotateInterval: o.AccessLogRotateInterval,
+		SampleRatio:    o.AccessLogSampleRatio,
+	}
+}
+
+func (o *Options) captureConfig() capture.Config {
+	return capture.Config{
+		SampleRatio:  o.CaptureSampleRatio,
+		Routes:       o.CaptureRoutes,
+		Statuses:     o.CaptureStatuses,
+		DebugHeader:  o.CaptureDebugHeader,
+		DebugSecret:  o.CaptureDebugSecret,
+		MaxBodyBytes: o.CaptureMaxBodySize,
+		ContentTypes: o.CaptureContentTypes,
+		Output: capture.OutputConfig{
+			Path:       o.CaptureOutput,
+			MaxSizeMB:  o.CaptureMaxSize,
+			MaxBackups: o.CaptureMaxBackups,
+			MaxAgeDays: o.CaptureMaxAge,
+			Compress:   o.CaptureCompress,
+		},
+	}
+}
+
+func (o *Options) shadowConfig() shadow.Config {
+	return shadow.Config{
+		Target:         o.ShadowTarget,
+		Percent:        o.ShadowPercent,
+		Timeout:        o.ShadowTimeout,
+		MaxConcurrency: o.ShadowMaxConcurrency,
+		MaxBodyBytes:   o.ShadowMaxBodySize,
+		Compare: shadow.CompareConfig{
+			Enabled:      o.ShadowCompare,
+			Headers:      o.ShadowCompareHeaders,
+			IgnoreFields: o.ShadowCompareIgnore,
+		},
+	}
+}
+
+func (o *Options) harConfig() har.Config {
+	return har.Config{
+		Dir:            o.HARDir,
+		Routes:         o.HARRoutes,
+		Statuses:       o.HARStatuses,
+		MaxBodyBytes:   o.HARMaxBodySize,
+		MaxSizeMB:      o.HARMaxSize,
+		RotateInterval: o.HARRotateInterval,
+		MaxFiles:       o.HARMaxFiles,
+	}
+}
+
+func (o *Options) redactionConfig() redact.Config {
+	return redact.Config{
+		Headers:      o.RedactHeaders,
+		AllowHeaders: o.RedactAllowHeaders,
+		QueryParams:  o.RedactQueryParams,
+		Patterns:     o.RedactPatterns,
+	}
+}
+
+func (o *Options) patternDiscoveryThreshold() int {
+	if !o.PatternDiscovery {
+		return 0
+	}
+	return o.PatternDiscoveryThreshold
+}
+
+func (o *Options) metricsConfig() middleware.MetricsConfig {
+	// buckets are checked in Validate
+	buckets, _ := parseBuckets(o.MetricsBuckets)
+	return middleware.MetricsConfig{
+		Namespace:                   o.MetricsNamespace,
+		Subsystem:                   o.MetricsSubsystem,
+		ConstLabels:                 o.MetricsConstLabels,
+		Buckets:                     buckets,
+		NativeHistogramBucketFactor: o.MetricsNativeHistogram,
+		ExtraLabels:                 o.MetricsExtraLabels,
+		MaxPathValues:               o.MetricsMaxPathValues,
+		CollapsePathSegments:        o.MetricsCollapsePaths,
+	}
+}
+
+// parseBuckets parses the upper bounds of the latency histogram, they must be
+// finite and strictly increasing as the histogram panics on its first
+// observation otherwise
+func parseBuckets(values []string) ([]float64, error) {
+	buckets := make([]float64, 0, len(values))
+	for i, value := range values {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics bucket %q: %w", value, err)
+		}
+		if math.IsNaN(bucket) || math.IsInf(bucket, 0) {
+			return nil, fmt.Errorf("invalid metrics bucket %q, expected a finite number, the +Inf bucket is always added", value)
+		}
+		if i > 0 && bucket <= buckets[i-1] {
+			return nil, fmt.Errorf("metrics buckets must be strictly increasing, got %v after %v. example: --metrics-buckets=0.01,0.1,1", bucket, buckets[i-1])
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func (o *Options) propagationConfig() observe.PropagationConfig {
+	return observe.PropagationConfig{
+		Extract:       o.Propagators,
+		Inject:        o.InjectPropagators,
+		IncomingTrace: o.IncomingTrace,
 	}
 }
 
 func (o *Options) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.ConfigFile, "config", "", "Config file (yaml, json or toml) whose keys are the flag names plus the routes and virtual-hosts sections, flags given on the command line take precedence. example: --config=/etc/reverse-proxy/config.yaml")
 	cmd.Flags().IntVar(&o.Port, "port", 8080, "Port number to listen on, default is 8080 if not provided. example: --port=8080")
 	cmd.Flags().IntVar(&o.MetricsPort, "metrics-port", 10250, "Port number to expose metrics, default is 10250 if not provided. example: --metrics-port=10250")
 	cmd.Flags().IntVar(&o.ShutdownTimeOut, "shutdown-timeout", 30, "ShutDownTimeOut in seconds, default is 30 if not provided. example: --shutdown-timeout=10")
 	cmd.Flags().StringVar(&o.TargetHost, "target-host", "", "Target host to proxy requests to, example: --target-host=http://localhost:8080")
 	cmd.Flags().StringVar(&o.Mode, "mode", "otel", "Mode to run the server in, default is otel. example: --mode=otel")
 	cmd.Flags().BoolVar(&o.DisableMetrics, "disable-metrics", false, "Disable metrics, default is false. example: --disable-metrics")
-	cmd.Flags().StringVar(&o.UrlPatternStr, "url-patterns", "", "URL patterns to match. you can use pattern list separated by comma, e.g. --url-patterns=/api,/api/{id},/api/v1/{id}")
+	cmd.Flags().StringVar(&o.UrlPatternStr, "url-patterns", "", "URL patterns to match. you can use pattern list separated by comma, variables can be typed ({id:int}, {id:uuid}), constrained by a regex ({slug:[a-z-]+}) or catch-all as last segment ({rest...}), e.g. --url-patterns=/api,/api/{id:int},/static/{rest...}")
 	cmd.Flags().StringVar(&o.ApplicationName, "application-name", "demo", "Application name is target server name, default is demo. example: --application-name=demo")
+	cmd.Flags().StringVar(&o.TLSCertFile, "tls-cert-file", "", "Certificate served by the proxy to clients whose SNI matches no virtual host, enables TLS. example: --tls-cert-file=/etc/reverse-proxy/tls.crt")
+	cmd.Flags().StringVar(&o.TLSKeyFile, "tls-key-file", "", "Private key of --tls-cert-file. example: --tls-key-file=/etc/reverse-proxy/tls.key")
+	cmd.Flags().StringSliceVar(&o.Propagators, "propagators", observe.DefaultPropagators, "Propagators used to extract incoming trace context, any of tracecontext, baggage, b3, b3multi, jaeger. example: --propagators=tracecontext,baggage,b3,jaeger")
+	cmd.Flags().StringSliceVar(&o.InjectPropagators, "inject-propagators", nil, "Propagators used to inject trace context toward the upstream, default is the value of --propagators. example: --inject-propagators=tracecontext,b3multi")
+	cmd.Flags().StringVar(&o.IncomingTrace, "incoming-trace", observe.INCOMING_TRACE_ACCEPT, "How to treat trace context sent by clients: accept, ignore or strip, default is accept. example: --incoming-trace=strip")
+	cmd.Flags().StringVar(&o.RequestIDHeader, "request-id-header", middleware.DEFAULT_REQUEST_ID_HEADER, "Header carrying the request ID, forwarded to the upstream, returned to the client and added to the logs, default is X-Request-ID. example: --request-id-header=X-Correlation-ID")
+	cmd.Flags().BoolVar(&o.RequestIDAcceptIncoming, "request-id-accept-incoming", true, "Keep the request ID sent by the client when it is made of at most 128 letters, digits and ._:- characters, a new one is generated otherwise, default is true. example: --request-id-accept-incoming=false")
+	cmd.Flags().StringVar(&o.LogLevel, "log-level", "info", "Level of the logs: debug, info, warn or error, it can be changed at runtime on the admin endpoint /admin/log/level, default is info. example: --log-level=debug")
+	cmd.Flags().StringToStringVar(&o.LogLevels, "log-levels", nil, "Levels per logger name overriding --log-level, the loggers are server, middleware, controller and route. example: --log-levels=middleware=debug,route=warn")
+	cmd.Flags().StringVar(&o.LogEncoding, "log-encoding", log.ENCODING_JSON, "Encoding of the logs: json or console, default is json. example: --log-encoding=console")
+	cmd.Flags().StringSliceVar(&o.LogOutputPaths, "log-output-paths", []string{"stdout"}, "Destinations of the logs: stdout, stderr or file paths, default is stdout. example: --log-output-paths=stdout,/var/log/reverse-proxy/proxy.log")
+	cmd.Flags().StringSliceVar(&o.LogErrorOutputPaths, "log-error-output-paths", []string{"stderr"}, "Destinations of the internal errors of the logger, default is stderr. example: --log-error-output-paths=/var/log/reverse-proxy/logger.log")
+	cmd.Flags().IntVar(&o.LogSamplingInitial, "log-sampling-initial", 0, "Entries of the same level and message logged each second before sampling starts, sampling is disabled when 0. example: --log-sampling-initial=100")
+	cmd.Flags().IntVar(&o.LogSamplingThereafter, "log-sampling-thereafter", 100, "Once sampling starts, only every n-th entry of the same level and message is logged within the second, default is 100. example: --log-sampling-thereafter=50")
+	cmd.Flags().BoolVar(&o.LogCaller, "log-caller", false, "Add the file and line of the log call to every entry, default is false. example: --log-caller")
+	cmd.Flags().StringVar(&o.LogTraceFields, "log-trace-fields", log.TRACE_FIELDS_OTEL, "Naming of the trace fields added to request logs: otel (trace_id, span_id, sampled) or datadog (dd.trace_id, dd.span_id, dd.sampled), default is otel. example: --log-trace-fields=datadog")
+	cmd.Flags().StringVar(&o.AccessLogFormat, "access-log-format", accesslog.FORMAT_JSON, "Access log format: json, logfmt, combined (Apache) or template, default is json. example: --access-log-format=logfmt")
+	cmd.Flags().StringVar(&o.AccessLogTemplate, "access-log-template", "", "Go template of the template access log format, fields are those of accesslog.Entry. example: --access-log-template='{{.Method}} {{.Path}} {{.Status}} {{.Duration}}'")
+	cmd.Flags().StringSliceVar(&o.AccessLogFields, "access-log-fields", []string{accesslog.FIELD_ROUTE}, "Optional fields of the json and logfmt access logs, any of bytes_in, bytes_out, user_agent, referer, upstream, upstream_latency, tls, route, default is route. example: --access-log-fields=route,bytes_out,upstream_latency")
+	cmd.Flags().StringVar(&o.AccessLogOutput, "access-log-output", accesslog.OUTPUT_STDOUT, "Access log destination: stdout, stderr or a file path, default is stdout. example: --access-log-output=/var/log/reverse-proxy/access.log")
+	cmd.Flags().IntVar(&o.AccessLogMaxSize, "access-log-max-size", 100, "Size in megabytes after which the access log file is rotated, default is 100. example: --access-log-max-size=50")
+	cmd.Flags().IntVar(&o.AccessLogMaxBackups, "access-log-max-backups", 0, "Number of rotated access log files kept, all are kept when 0. example: --access-log-max-backups=7")
+	cmd.Flags().IntVar(&o.AccessLogMaxAge, "access-log-max-age", 0, "Days rotated access log files are kept, kept forever when 0. example: --access-log-max-age=30")
+	cmd.Flags().BoolVar(&o.AccessLogCompress, "access-log-compress", false, "Gzip rotated access log files, default is false. example: --access-log-compress")
+	cmd.Flags().DurationVar(&o.AccessLogRotateInterval, "access-log-rotate-interval", 0, "Rotate the access log file periodically on top of the size limit, disabled when 0. example: --access-log-rotate-interval=24h")
+	cmd.Flags().Float64Var(&o.AccessLogSampleRatio, "access-log-sample-ratio", 1, "Ratio of requests written to the access log, server errors are always written, routes can override it, default is 1. example: --access-log-sample-ratio=0.1")
+	cmd.Flags().Float64Var(&o.CaptureSampleRatio, "capture-sample-ratio", 0, "Ratio of requests whose request and response bodies are recorded to the capture output, between 0 and 1, default is 0. example: --capture-sample-ratio=0.001")
+	cmd.Flags().StringSliceVar(&o.CaptureRoutes, "capture-routes", nil, "Route names the capture sampling and statuses apply to, every route when empty. example: --capture-routes=createOrder,/users/{id}")
+	cmd.Flags().StringSliceVar(&o.CaptureStatuses, "capture-statuses", nil, "Status codes or classes whose request and response bodies are always recorded. example: --capture-statuses=5xx,429")
+	cmd.Flags().StringVar(&o.CaptureDebugHeader, "capture-debug-header", capture.DEFAULT_DEBUG_HEADER, "Header forcing the capture of a request when it carries --capture-debug-secret, it is never sent to the upstream, default is X-Debug-Capture. example: --capture-debug-header=X-Debug")
+	cmd.Flags().StringVar(&o.CaptureDebugSecret, "capture-debug-secret", "", "Shared secret of the capture debug header, the header is ignored when empty. example: --capture-debug-secret=s3cr3t")
+	cmd.Flags().IntVar(&o.CaptureMaxBodySize, "capture-max-body-size", 64*1024, "Bytes of each request and response body recorded, longer bodies are truncated, default is 65536. example: --capture-max-body-size=16384")
+	cmd.Flags().StringSliceVar(&o.CaptureContentTypes, "capture-content-types", capture.DefaultContentTypes, "Content type prefixes whose bodies are recorded, other bodies are omitted, default is json, xml, form and text types. example: --capture-content-types=application/json,text/plain")
+	cmd.Flags().StringVar(&o.CaptureOutput, "capture-output", capture.OUTPUT_STDERR, "Capture records destination: stdout, stderr or a file path, default is stderr. example: --capture-output=/var/log/reverse-proxy/capture.log")
+	cmd.Flags().IntVar(&o.CaptureMaxSize, "capture-max-size", 100, "Size in megabytes after which the capture file is rotated, default is 100. example: --capture-max-size=50")
+	cmd.Flags().IntVar(&o.CaptureMaxBackups, "capture-max-backups", 0, "Number of rotated capture files kept, all are kept when 0. example: --capture-max-backups=3")
+	cmd.Flags().IntVar(&o.CaptureMaxAge, "capture-max-age", 0, "Days rotated capture files are kept, kept forever when 0. example: --capture-max-age=7")
+	cmd.Flags().BoolVar(&o.CaptureCompress, "capture-compress", false, "Gzip rotated capture files, default is false. example: --capture-compress")
+	cmd.Flags().StringVar(&o.ShadowTarget, "shadow-target", "", "Shadow upstream receiving a copy of the requests, its responses are measured under the shadow_ metrics and never reach the client, disabled when empty. example: --shadow-target=http://localhost:8081")
+	cmd.Flags().Float64Var(&o.ShadowPercent, "shadow-percent", 100, "Percent of the requests mirrored to the shadow upstream, routes can override it, default is 100. example: --shadow-percent=10")
+	cmd.Flags().DurationVar(&o.ShadowTimeout, "shadow-timeout", 5*time.Second, "Timeout of the mirrored requests, default is 5s. example: --shadow-timeout=2s")
+	cmd.Flags().IntVar(&o.ShadowMaxConcurrency, "shadow-max-concurrency", 100, "Mirrored requests in flight, requests past it are not mirrored, default is 100. example: --shadow-max-concurrency=20")
+	cmd.Flags().Int64Var(&o.ShadowMaxBodySize, "shadow-max-body-size", 1024*1024, "Largest request body buffered for the shadow upstream, requests with a larger body are not mirrored, default is 1048576. example: --shadow-max-body-size=65536")
+	cmd.Flags().BoolVar(&o.ShadowCompare, "shadow-compare", false, "Compare the status, headers and body of the primary and shadow responses, mismatches are logged, counted per route and listed on /admin/shadow/diffs. example: --shadow-compare")
+	cmd.Flags().StringSliceVar(&o.ShadowCompareHeaders, "shadow-compare-headers", []string{"Content-Type"}, "Response headers compared when --shadow-compare is set, default is Content-Type. example: --shadow-compare-headers=Content-Type,Cache-Control")
+	cmd.Flags().StringSliceVar(&o.ShadowCompareIgnore, "shadow-compare-ignore", nil, "JSON fields left out of the comparison, a name matches the field at any depth and a dotted path is anchored at the root of the body, * matching any key or index. example: --shadow-compare-ignore=timestamp,data.*.id")
+	cmd.Flags().IntVar(&o.ShadowCompareKeep, "shadow-compare-keep", 100, "Number of latest mismatches kept for /admin/shadow/diffs, default is 100. example: --shadow-compare-keep=500")
+	cmd.Flags().StringVar(&o.HARDir, "har-dir", "", "Directory of the HAR 1.2 files proxied traffic is recorded to, the file being written ends with .har.part, recording is disabled when empty. example: --har-dir=/var/lib/reverse-proxy/har")
+	cmd.Flags().StringSliceVar(&o.HARRoutes, "har-routes", nil, "Route names recorded to HAR files, every route when empty. example: --har-routes=createOrder,/users/{id}")
+	cmd.Flags().StringSliceVar(&o.HARStatuses, "har-statuses", nil, "Status codes or classes recorded to HAR files, every status when empty. example: --har-statuses=4xx,5xx")
+	cmd.Flags().IntVar(&o.HARMaxBodySize, "har-max-body-size", 1024*1024, "Bytes of each request and response body recorded to HAR files, longer bodies are truncated, default is 1048576. example: --har-max-body-size=65536")
+	cmd.Flags().IntVar(&o.HARMaxSize, "har-max-size", 100, "Size in megabytes after which a new HAR file is started, default is 100. example: --har-max-size=20")
+	cmd.Flags().DurationVar(&o.HARRotateInterval, "har-rotate-interval", time.Hour, "Start a new HAR file periodically, disabled when 0, default is 1h. example: --har-rotate-interval=15m")
+	cmd.Flags().IntVar(&o.HARMaxFiles, "har-max-files", 0, "Number of complete HAR files kept, all are kept when 0. example: --har-max-files=24")
+	cmd.Flags().StringSliceVar(&o.RedactHeaders, "redact-headers", redact.DefaultHeaders, "Headers whose values are redacted in logs and span attributes, default is Authorization, Proxy-Authorization, Cookie, Set-Cookie, X-Api-Key, X-Auth-Token and X-Csrf-Token. example: --redact-headers=Authorization,Cookie,X-Session")
+	cmd.Flags().StringSliceVar(&o.RedactAllowHeaders, "redact-headers-allow", nil, "Headers logged as is, every other header is redacted when set, --redact-headers still applies. example: --redact-headers-allow=Accept,Content-Type,User-Agent,Traceparent")
+	cmd.Flags().StringSliceVar(&o.RedactQueryParams, "redact-query-params", redact.DefaultQueryParams, "Query parameters masked in logged urls, route params of the same name are masked too, default is token, access_token, api_key, password, secret and the like. example: --redact-query-params=token,session")
+	cmd.Flags().StringSliceVar(&o.RedactPatterns, "redact-patterns", redact.DefaultPatterns, "Patterns scrubbed from logged text and captured bodies: bearer, jwt, email, card (Luhn checked) or a regular expression, default is bearer,jwt,email,card. example: --redact-patterns=bearer,jwt,'ssn-\\d+'")
+	cmd.Flags().StringVar(&o.MetricsNamespace, "metrics-namespace", "", "Namespace prepended to every metric name. example: --metrics-namespace=reverse_proxy")
+	cmd.Flags().StringVar(&o.MetricsSubsystem, "metrics-subsystem", "", "Subsystem placed between the namespace and the metric name. example: --metrics-subsystem=http")
+	cmd.Flags().StringToStringVar(&o.MetricsConstLabels, "metrics-const-labels", nil, "Constant labels added to every metric, application is reserved for the application name of each virtual host. example: --metrics-const-labels=env=prod,team=platform")
+	cmd.Flags().StringSliceVar(&o.MetricsBuckets, "metrics-buckets", nil, "Latency histogram buckets in seconds, default is the prometheus default buckets. example: --metrics-buckets=0.01,0.05,0.1,0.5,1")
+	cmd.Flags().Float64Var(&o.MetricsNativeHistogram, "metrics-native-histogram-factor", 0, "Bucket growth factor of native histograms, disabled when 0. example: --metrics-native-histogram-factor=1.1")
+	cmd.Flags().StringSliceVar(&o.MetricsExtraLabels, "metrics-extra-labels", nil, "Optional labels added to request metrics, any of host, upstream, route. example: --metrics-extra-labels=host,route")
+	cmd.Flags().IntVar(&o.MetricsMaxPathValues, "metrics-max-path-values", 1000, "Maximum distinct path label values per metric, extra values go to the __overflow__ bucket, unlimited when 0. example: --metrics-max-path-values=500")
+	cmd.Flags().BoolVar(&o.MetricsCollapsePaths, "metrics-collapse-path-segments", true, "Replace numeric, UUID, hex and hash-like path segments with placeholders in metric labels, default is true. example: --metrics-collapse-path-segments=false")
+	cmd.Flags().BoolVar(&o.PatternDiscovery, "pattern-discovery", false, "Observe paths not matching --url-patterns and suggest patterns on the admin endpoint /admin/patterns of the metrics listener, default is false. example: --pattern-discovery")
+	cmd.Flags().IntVar(&o.PatternDiscoveryThreshold, "pattern-discovery-threshold", 10, "Number of distinct values of a segment after which it becomes a path variable, default is 10. example: --pattern-discovery-threshold=20")
+	cmd.Flags().StringVar(&o.OpenAPIFile, "openapi-file", "", "OpenAPI 3 document (yaml or json) whose paths are added to the url patterns. example: --openapi-file=./openapi.yaml")
+	cmd.Flags().StringVar(&o.OpenAPILabel, "openapi-metrics-label", openapi.LABEL_TEMPLATE, "Path label of documented requests: template or operation (operationId), default is template. example: --openapi-metrics-label=operation")
+	cmd.Flags().StringVar(&o.OpenAPIUndocumented, "openapi-undocumented", openapi.UNDOCUMENTED_ALLOW, "What to do with requests to paths missing from the OpenAPI document: allow, log or reject, default is allow. example: --openapi-undocumented=reject")
+	cmd.Flags().StringVar(&o.OpenAPIValidation, "openapi-validation", openapi.VALIDATION_OFF, "Validation of documented requests against the OpenAPI document: enforce, report or off, default is off. example: --openapi-validation=report")
+	cmd.Flags().StringToStringVar(&o.OpenAPIValidationRoutes, "openapi-validation-routes", nil, "Validation mode per operationId or path template, overriding --openapi-validation. example: --openapi-validation-routes=createUser=enforce,getUser=off")
+	cmd.Flags().Float64Var(&o.OpenAPIResponseSampleRatio, "openapi-response-sample-ratio", 0, "Ratio of upstream responses validated against the OpenAPI document, between 0 and 1, default is 0. example: --openapi-response-sample-ratio=0.01")
+	cmd.Flags().Int64Var(&o.OpenAPIMaxBodySize, "openapi-max-body-size", openapi.DEFAULT_MAX_BODY_BYTES, "Largest request or decoded response body validated against the OpenAPI document, larger bodies are not validated, default is 1048576. example: --openapi-max-body-size=65536")
+	cmd.Flags().StringToStringVar(&o.UpstreamHeaders, "upstream-headers", nil, "Headers sent to the upstream, values are go templates of the request: .Route.Pattern, .Route.Name, .Params, .Method, .Path, .Host, .ClientIP, .RequestID, .TLS.ServerName, .TLS.CommonName and env \"NAME\". example: --upstream-headers=X-Route={{.Route.Name}},X-User-ID={{.Params.id}}")
+	cmd.Flags().StringSliceVar(&o.ScrubHeaders, "scrub-headers", nil, "Headers treated as hop-by-hop, removed from requests and responses on top of Connection, Keep-Alive, Upgrade and the like. example: --scrub-headers=X-Internal-Token,X-Debug")
+	cmd.Flags().BoolVar(&o.ScrubServerHeader, "scrub-server-header", false, "Remove the Server and X-Powered-By headers of upstream responses, default is false. example: --scrub-server-header")
 }
@@ -0,0 +1,103 @@
+package options
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"github.com/tae2089/reverse-proxy/internal/server/vhost"
+)
+
+// newOptions returns the options of the flag defaults and the given flags
+func newOptions(t *testing.T, args ...string) *Options {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	o := New()
+	cmd := &cobra.Command{}
+	o.AddFlags(cmd)
+	if err := cmd.ParseFlags(append([]string{"--target-host=http://localhost:8081"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := viper.BindPFlags(cmd.Flags()); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Complete(nil, cmd); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// err is a part of the expected error, the options are valid when empty
+		err string
+	}{
+		{"defaults", nil, ""},
+		{"increasing buckets", []string{"--metrics-buckets=0.01,0.1,1"}, ""},
+		{"decreasing buckets", []string{"--metrics-buckets=1,0.5"}, "strictly increasing"},
+		{"repeated bucket", []string{"--metrics-buckets=0.5,0.5"}, "strictly increasing"},
+		{"infinite bucket", []string{"--metrics-buckets=0.5,+Inf"}, "finite"},
+		{"NaN bucket", []string{"--metrics-buckets=NaN"}, "finite"},
+		{"invalid bucket", []string{"--metrics-buckets=fast"}, "invalid metrics bucket"},
+		{"constant label", []string{"--metrics-const-labels=team=payments"}, ""},
+		{"application constant label", []string{"--metrics-const-labels=application=web"}, "can not be a constant label"},
+		{"variable label as constant label", []string{"--metrics-const-labels=status_code=200"}, "can not be a constant label"},
+		{"extra label as constant label", []string{"--metrics-const-labels=upstream=a"}, "can not be a constant label"},
+		{"bucket label as constant label", []string{"--metrics-const-labels=le=1"}, "can not be a constant label"},
+		{"invalid constant label name", []string{"--metrics-const-labels=team-name=a"}, "invalid constant label name"},
+		{"reserved constant label name", []string{"--metrics-const-labels=__name__=a"}, "invalid constant label name"},
+		{"unknown extra label", []string{"--metrics-extra-labels=client"}, "unknown metrics label"},
+		{"pattern discovery without metrics", []string{"--pattern-discovery", "--disable-metrics"}, "disable-metrics"},
+		{"certificate without key", []string{"--tls-cert-file=cert.pem"}, "must be given together"},
+		{"catch-all before the last segment", []string{"--url-patterns=/files/{path...}/raw"}, "must be the last segment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newOptions(t, tt.args...).Validate()
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateSplitsWithoutMetrics(t *testing.T) {
+	split := route.Config{
+		Name:    "users",
+		Pattern: "/users",
+		Split: route.SplitConfig{Groups: []route.GroupConfig{
+			{Name: "blue", TargetHosts: []string{"http://blue"}, Weight: 100},
+			{Name: "green", TargetHosts: []string{"http://green"}},
+		}},
+	}
+	o := newOptions(t, "--disable-metrics")
+	if err := o.Validate(); err != nil {
+		t.Fatalf("disable-metrics without splits: %v", err)
+	}
+	o.Routes = []route.Config{split}
+	if err := o.Validate(); err == nil || !strings.Contains(err.Error(), "disable-metrics") {
+		t.Errorf("route split: error = %v", err)
+	}
+	o.Routes = nil
+	o.TargetHost = ""
+	o.VirtualHosts = []vhost.Config{{Hosts: []string{"example.com"}, ApplicationName: "example", TargetHosts: []string{"http://localhost:8081"}, Routes: []route.Config{split}}}
+	if err := o.Validate(); err == nil || !strings.Contains(err.Error(), "disable-metrics") {
+		t.Errorf("virtual host route split: error = %v", err)
+	}
+}
+
+func TestValidateTargetHost(t *testing.T) {
+	o := newOptions(t)
+	o.TargetHost = ""
+	if err := o.Validate(); err == nil || !strings.Contains(err.Error(), "target-host is required") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+)
+
+// NewSuggestPatternsCommand prints the url patterns discovered by a running proxy
+func NewSuggestPatternsCommand() *cobra.Command {
+	var adminAddress string
+	var application string
+	var minHits int
+	cmd := &cobra.Command{
+		Short: "Print url patterns discovered from live traffic",
+		Long:  "Print url patterns discovered from live traffic by a proxy running with --pattern-discovery, as a ready to paste --url-patterns value",
+		Use:   "suggest-patterns [flags]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			suggestions, err := fetchPatternSuggestions(adminAddress, application)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			patterns := append([]string{}, suggestions.Configured...)
+			fmt.Fprintf(out, "%8s  %s\n", "HITS", "PATTERN")
+			for _, suggestion := range suggestions.Suggested {
+				if suggestion.Hits < minHits {
+					continue
+				}
+				fmt.Fprintf(out, "%8d  %s\n", suggestion.Hits, suggestion.Pattern)
+				patterns = append(patterns, suggestion.Pattern)
+			}
+			fmt.Fprintf(out, "\n--url-patterns=%s\n", strings.Join(patterns, ","))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&adminAddress, "admin-address", "http://localhost:10250", "Address of the metrics/admin listener of the proxy. example: --admin-address=http://localhost:10250")
+	cmd.Flags().StringVar(&application, "application", "", "Application name of the virtual host, may be omitted when the proxy serves one application. example: --application=orders")
+	cmd.Flags().IntVar(&minHits, "min-hits", 1, "Skip suggested patterns with fewer hits. example: --min-hits=10")
+	return cmd
+}
+
+func fetchPatternSuggestions(adminAddress, application string) (*domain.PatternSuggestions, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(adminAddress, "/") + "/admin/patterns?application=" + url.QueryEscape(application))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to get pattern suggestions: %s %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	suggestions := &domain.PatternSuggestions{}
+	if err := json.NewDecoder(resp.Body).Decode(suggestions); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
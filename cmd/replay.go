@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tae2089/reverse-proxy/internal/replay"
+)
+
+// NewReplayCommand replays recorded traffic against a target and compares the
+// responses with the recording
+func NewReplayCommand() *cobra.Command {
+	cfg := replay.Config{}
+	thresholds := replay.Thresholds{}
+	var headers map[string]string
+	var output string
+	var limit int
+	var failOnDiff bool
+	cmd := &cobra.Command{
+		Short: "Replay recorded traffic and report the differences",
+		Long:  "Replay the requests of HAR files (--har-dir) or capture records (--capture-output) against a target, and report the status and latency differences with the recording",
+		Use:   "replay [flags] <file or directory>",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "text" && output != "json" {
+				return fmt.Errorf("unknown output %q, expected text or json", output)
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			cfg.Header = make(http.Header, len(headers))
+			for name, value := range headers {
+				cfg.Header.Set(name, value)
+			}
+			requests, err := replay.Load(args[0])
+			if err != nil {
+				return err
+			}
+			if len(requests) == 0 {
+				return fmt.Errorf("no recorded request found in %s", args[0])
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			report := replay.NewReport(replay.Run(ctx, cfg, requests), thresholds)
+			if output == "json" {
+				if err := report.WriteJSON(cmd.OutOrStdout()); err != nil {
+					return err
+				}
+			} else {
+				report.WriteText(cmd.OutOrStdout(), limit)
+			}
+			if failOnDiff && report.HasDiffs() {
+				cmd.SilenceUsage = true
+				return errors.New("the replay differs from the recording")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&cfg.Target, "target", "", "Base url the recorded requests are sent to. example: --target=http://localhost:8080")
+	cmd.Flags().IntVar(&cfg.Concurrency, "concurrency", 1, "Number of requests in flight, default is 1. example: --concurrency=8")
+	cmd.Flags().Float64Var(&cfg.Rate, "rate", 0, "Maximum requests per second, unlimited when 0. example: --rate=50")
+	cmd.Flags().Float64Var(&cfg.TimeScale, "time-scale", 0, "Replay at the recorded pace divided by the scale, 1 keeps the recorded pace and 2 is twice as fast, the pace is ignored when 0. example: --time-scale=10")
+	cmd.Flags().DurationVar(&cfg.Timeout, "timeout", 30*time.Second, "Timeout of each replayed request, default is 30s. example: --timeout=5s")
+	cmd.Flags().BoolVar(&cfg.SkipIncomplete, "skip-incomplete", true, "Skip the requests whose recorded body was truncated or omitted or whose query or body holds a redacted value, default is true. example: --skip-incomplete=false")
+	cmd.Flags().StringToStringVar(&headers, "header", nil, "Headers added to every replayed request. example: --header=X-Replay=true")
+	cmd.Flags().Float64Var(&thresholds.LatencyRatio, "latency-ratio", 1.5, "Report requests slower than the recording by this ratio and by --latency-delta, default is 1.5. example: --latency-ratio=2")
+	cmd.Flags().DurationVar(&thresholds.LatencyDelta, "latency-delta", 10*time.Millisecond, "Report requests slower than the recording by this duration and by --latency-ratio, default is 10ms. example: --latency-delta=50ms")
+	cmd.Flags().StringVar(&output, "output", "text", "Report format: text or json, default is text. example: --output=json")
+	cmd.Flags().IntVar(&limit, "limit", 20, "Differences of each kind listed in the text report, default is 20. example: --limit=100")
+	cmd.Flags().BoolVar(&failOnDiff, "fail-on-diff", false, "Exit with an error when a status differs, a request fails or is slower, default is false. example: --fail-on-diff")
+	cmd.MarkFlagRequired("target")
+	return cmd
+}
@@ -0,0 +1,195 @@
+package accesslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	FORMAT_JSON     = "json"
+	FORMAT_LOGFMT   = "logfmt"
+	FORMAT_COMBINED = "combined"
+	// FORMAT_TEMPLATE renders each entry with a go template, e.g. {{.Method}} {{.Path}} {{.Status}}
+	FORMAT_TEMPLATE = "template"
+)
+
+const (
+	OUTPUT_STDOUT = "stdout"
+	OUTPUT_STDERR = "stderr"
+)
+
+// Config holds the format and the destination of the access log
+type Config struct {
+	Format string
+	// Template is used with FORMAT_TEMPLATE
+	Template string
+	// Fields are the optional fields of the json and logfmt formats
+	Fields []string
+	// Output is stdout, stderr or a file path
+	Output string
+	// MaxSizeMB rotates the file once it grows past the size, 100 when 0
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+	// RotateInterval rotates the file periodically, disabled when 0
+	RotateInterval time.Duration
+	// SampleRatio is the ratio of logged requests, server errors are always logged
+	SampleRatio float64
+}
+
+// Validate checks the format, fields and sample ratio
+func (c Config) Validate() error {
+	switch c.Format {
+	case "", FORMAT_JSON, FORMAT_LOGFMT, FORMAT_COMBINED:
+	case FORMAT_TEMPLATE:
+		if c.Template == "" {
+			return errors.New("access log template is required with the template format")
+		}
+		if _, err := parseTemplate(c.Template); err != nil {
+			return errors.Join(errors.New("invalid access log template"), err)
+		}
+	default:
+		return fmt.Errorf("unknown access log format %q, expected one of json, logfmt, combined, template", c.Format)
+	}
+	if err := ValidateFields(c.Fields); err != nil {
+		return err
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("access log sample ratio must be between 0 and 1, got %v", c.SampleRatio)
+	}
+	if c.RotateInterval < 0 {
+		return errors.New("access log rotate interval must not be negative")
+	}
+	return nil
+}
+
+// Logger writes access log entries, it is safe for concurrent use
+type Logger struct {
+	mu          sync.Mutex
+	buf         bytes.Buffer
+	format      func(buf *bytes.Buffer, e *Entry)
+	writer      io.Writer
+	rotator     *lumberjack.Logger
+	stop        chan struct{}
+	sampleRatio float64
+	redactor    *redact.Redactor
+}
+
+// New creates the logger and opens its destination, the redactor masks the
+// query, referer and route params of every entry
+func New(cfg Config, redactor *redact.Redactor) (*Logger, error) {
+	l := &Logger{sampleRatio: cfg.SampleRatio, stop: make(chan struct{}), redactor: redactor}
+	switch cfg.Format {
+	case FORMAT_LOGFMT:
+		l.format = logfmtFormatter(cfg.Fields)
+	case FORMAT_COMBINED:
+		l.format = formatCombined
+	case FORMAT_TEMPLATE:
+		tmpl, err := parseTemplate(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+		l.format = templateFormatter(tmpl)
+	default:
+		l.format = jsonFormatter(cfg.Fields)
+	}
+	switch cfg.Output {
+	case "", OUTPUT_STDOUT:
+		l.writer = os.Stdout
+	case OUTPUT_STDERR:
+		l.writer = os.Stderr
+	default:
+		l.rotator = &lumberjack.Logger{
+			Filename:   cfg.Output,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+		l.writer = l.rotator
+		if cfg.RotateInterval > 0 {
+			go l.rotateEvery(cfg.RotateInterval)
+		}
+	}
+	return l, nil
+}
+
+// rotateEvery rotates the file on a ticker, lumberjack only rotates by size
+func (l *Logger) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to rotate access log: %v\n", err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// rotate moves the file aside and opens a new one
+func (l *Logger) rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rotator.Rotate()
+}
+
+// Sample reports whether a request is logged. ratio overrides the sample
+// ratio of the logger when not nil, server errors are always logged.
+func (l *Logger) Sample(ratio *float64, status int) bool {
+	if status >= 500 {
+		return true
+	}
+	r := l.sampleRatio
+	if ratio != nil {
+		r = *ratio
+	}
+	return r >= 1 || rand.Float64() < r
+}
+
+// Log writes an entry
+func (l *Logger) Log(e *Entry) {
+	redacted := *e
+	redacted.Path = l.redactor.URL(l.redactor.String(e.Path))
+	redacted.Referer = l.redactor.URL(l.redactor.String(e.Referer))
+	redacted.UserAgent = l.redactor.String(e.UserAgent)
+	redacted.Params = l.redactor.Params(e.Params)
+	e = &redacted
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Reset()
+	l.format(&l.buf, e)
+	l.buf.WriteByte('\n')
+	if _, err := l.writer.Write(l.buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write access log: %v\n", err)
+	}
+}
+
+// Close stops the rotation and closes the file
+func (l *Logger) Close() error {
+	close(l.stop)
+	if l.rotator == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rotator.Close()
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("access-log").Option("missingkey=zero").Parse(text)
+}
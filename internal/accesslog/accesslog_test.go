@@ -0,0 +1,59 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+// logFiles returns the active file and the rotated backups of the directory
+func logFiles(t *testing.T, dir string) (active string, backups []string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == "access.log" {
+			active = entry.Name()
+			continue
+		}
+		backups = append(backups, entry.Name())
+	}
+	return active, backups
+}
+
+func TestFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	l, err := New(Config{Output: filepath.Join(dir, "access.log"), MaxSizeMB: 1, MaxBackups: 5}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	entry := testEntry()
+	entry.Path = "/" + strings.Repeat("a", 1000)
+	for i := 0; i < 1100; i++ {
+		l.Log(entry)
+	}
+	if active, backups := logFiles(t, dir); active == "" || len(backups) != 1 {
+		t.Fatalf("after 1.1MB: active %q, backups %v", active, backups)
+	}
+
+	if err := l.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	l.Log(testEntry())
+	// backups are named after the millisecond of the rotation, so only the new file is checked
+	active, _ := logFiles(t, dir)
+	data, err := os.ReadFile(filepath.Join(dir, active))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("the new file holds %d entries, want 1", lines)
+	}
+}
@@ -0,0 +1,126 @@
+package accesslog
+
+import (
+	"fmt"
+	"time"
+)
+
+// Optional fields of the json and logfmt formats
+const (
+	FIELD_BYTES_IN         = "bytes_in"
+	FIELD_BYTES_OUT        = "bytes_out"
+	FIELD_USER_AGENT       = "user_agent"
+	FIELD_REFERER          = "referer"
+	FIELD_UPSTREAM         = "upstream"
+	FIELD_UPSTREAM_LATENCY = "upstream_latency"
+	FIELD_TLS              = "tls"
+	// FIELD_ROUTE adds the route name and params
+	FIELD_ROUTE = "route"
+)
+
+var optionalFields = []string{
+	FIELD_BYTES_IN, FIELD_BYTES_OUT, FIELD_USER_AGENT, FIELD_REFERER,
+	FIELD_UPSTREAM, FIELD_UPSTREAM_LATENCY, FIELD_TLS, FIELD_ROUTE,
+}
+
+// ValidateFields checks the optional field names
+func ValidateFields(fields []string) error {
+	for _, field := range fields {
+		known := false
+		for _, optional := range optionalFields {
+			known = known || field == optional
+		}
+		if !known {
+			return fmt.Errorf("unknown access log field %q, expected any of %v", field, optionalFields)
+		}
+	}
+	return nil
+}
+
+// Entry describes a handled request, it is also the data of access log templates
+type Entry struct {
+	Time      time.Time
+	RequestID string
+	// Trace holds the trace fields named after the log trace field style
+	Trace      []Field
+	RemoteAddr string
+	Method     string
+	// Path includes the query string
+	Path      string
+	Proto     string
+	Host      string
+	Status    int
+	Duration  time.Duration
+	BytesIn   int64
+	BytesOut  int64
+	UserAgent string
+	Referer   string
+	// Upstream is the address of the upstream, empty when the request was not proxied
+	Upstream string
+	// Variant is the upstream group of a split route, it is written with the upstream
+	Variant         string
+	UpstreamLatency time.Duration
+	// TLSVersion, TLSCipher and TLSServerName are empty for plain http
+	TLSVersion    string
+	TLSCipher     string
+	TLSServerName string
+	Route         string
+	Params        map[string]string
+}
+
+// Field is a key and value of the json and logfmt formats
+type Field struct {
+	Key   string
+	Value any
+}
+
+// fields returns the fields of the entry in output order
+func (e *Entry) fields(optional []string) []Field {
+	fields := []Field{
+		{"timestamp", e.Time.UTC().Format("2006-01-02T15:04:05.000Z0700")},
+		{"msg", "service call"},
+	}
+	if e.RequestID != "" {
+		fields = append(fields, Field{"request_id", e.RequestID})
+	}
+	fields = append(fields, e.Trace...)
+	fields = append(fields,
+		Field{"status_code", e.Status},
+		Field{"remote_host", e.RemoteAddr},
+		Field{"method", e.Method},
+		Field{"path", e.Path},
+		Field{"response_time", e.Duration.Seconds()},
+	)
+	for _, name := range optional {
+		switch name {
+		case FIELD_BYTES_IN:
+			fields = append(fields, Field{name, e.BytesIn})
+		case FIELD_BYTES_OUT:
+			fields = append(fields, Field{name, e.BytesOut})
+		case FIELD_USER_AGENT:
+			fields = append(fields, Field{name, e.UserAgent})
+		case FIELD_REFERER:
+			fields = append(fields, Field{name, e.Referer})
+		case FIELD_UPSTREAM:
+			fields = append(fields, Field{name, e.Upstream})
+			if e.Variant != "" {
+				fields = append(fields, Field{"variant", e.Variant})
+			}
+		case FIELD_UPSTREAM_LATENCY:
+			fields = append(fields, Field{name, e.UpstreamLatency.Seconds()})
+		case FIELD_TLS:
+			if e.TLSVersion != "" {
+				fields = append(fields,
+					Field{"tls_version", e.TLSVersion},
+					Field{"tls_cipher", e.TLSCipher},
+					Field{"tls_server_name", e.TLSServerName},
+				)
+			}
+		case FIELD_ROUTE:
+			if e.Route != "" {
+				fields = append(fields, Field{"route", e.Route}, Field{"params", e.Params})
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,98 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+func jsonFormatter(optional []string) func(buf *bytes.Buffer, e *Entry) {
+	return func(buf *bytes.Buffer, e *Entry) {
+		buf.WriteByte('{')
+		for i, field := range e.fields(optional) {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(field.Key)
+			buf.Write(key)
+			buf.WriteByte(':')
+			value, err := json.Marshal(field.Value)
+			if err != nil {
+				value, _ = json.Marshal(fmt.Sprint(field.Value))
+			}
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+	}
+}
+
+func logfmtFormatter(optional []string) func(buf *bytes.Buffer, e *Entry) {
+	return func(buf *bytes.Buffer, e *Entry) {
+		for i, field := range e.fields(optional) {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(field.Key)
+			buf.WriteByte('=')
+			writeLogfmtValue(buf, field.Value)
+		}
+	}
+}
+
+func writeLogfmtValue(buf *bytes.Buffer, value any) {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]string:
+		encoded, _ := json.Marshal(v)
+		text = string(encoded)
+	default:
+		text = fmt.Sprint(v)
+	}
+	if text == "" || strings.ContainsAny(text, " =\"\t\n\\") {
+		text = strconv.Quote(text)
+	}
+	buf.WriteString(text)
+}
+
+// formatCombined writes the Apache combined log format
+func formatCombined(buf *bytes.Buffer, e *Entry) {
+	host := e.RemoteAddr
+	if i := strings.LastIndexByte(host, ':'); i > 0 {
+		host = host[:i]
+	}
+	size := "-"
+	if e.BytesOut > 0 {
+		size = strconv.FormatInt(e.BytesOut, 10)
+	}
+	fmt.Fprintf(buf, "%s - - [%s] %s %d %s %s %s",
+		host,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.Path+" "+e.Proto),
+		e.Status,
+		size,
+		quoteOrDash(e.Referer),
+		quoteOrDash(e.UserAgent),
+	)
+}
+
+func quoteOrDash(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	return strconv.Quote(value)
+}
+
+func templateFormatter(tmpl *template.Template) func(buf *bytes.Buffer, e *Entry) {
+	return func(buf *bytes.Buffer, e *Entry) {
+		if err := tmpl.Execute(buf, e); err != nil {
+			fmt.Fprintf(buf, "failed to render access log template: %v", err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package accesslog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+func testEntry() *Entry {
+	return &Entry{
+		Time:       time.Date(2024, 10, 10, 13, 55, 36, 0, time.UTC),
+		RequestID:  "abc-123",
+		Trace:      []Field{{"trace_id", "0af7651916cd43dd8448eb211c80319c"}},
+		RemoteAddr: "10.0.0.1:5000",
+		Method:     "GET",
+		Path:       "/users/42?verbose=1",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Duration:   1500 * time.Millisecond,
+		BytesOut:   2326,
+		UserAgent:  "curl/8.0",
+		Route:      "users",
+		Params:     map[string]string{"id": "42"},
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   func(buf *bytes.Buffer, e *Entry)
+		expected string
+	}{
+		{
+			"json",
+			jsonFormatter([]string{FIELD_ROUTE, FIELD_BYTES_OUT}),
+			`{"timestamp":"2024-10-10T13:55:36.000Z","msg":"service call","request_id":"abc-123","trace_id":"0af7651916cd43dd8448eb211c80319c","status_code":200,"remote_host":"10.0.0.1:5000","method":"GET","path":"/users/42?verbose=1","response_time":1.5,"route":"users","params":{"id":"42"},"bytes_out":2326}`,
+		},
+		{
+			"logfmt",
+			logfmtFormatter([]string{FIELD_USER_AGENT, FIELD_REFERER}),
+			`timestamp=2024-10-10T13:55:36.000Z msg="service call" request_id=abc-123 trace_id=0af7651916cd43dd8448eb211c80319c status_code=200 remote_host=10.0.0.1:5000 method=GET path="/users/42?verbose=1" response_time=1.5 user_agent=curl/8.0 referer=""`,
+		},
+		{
+			"combined",
+			formatCombined,
+			`10.0.0.1 - - [10/Oct/2024:13:55:36 +0000] "GET /users/42?verbose=1 HTTP/1.1" 200 2326 "-" "curl/8.0"`,
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tt.format(&buf, testEntry())
+		if got := buf.String(); got != tt.expected {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestTemplateFormat(t *testing.T) {
+	tmpl, err := parseTemplate("{{.Method}} {{.Path}} {{.Status}} {{.Route}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	templateFormatter(tmpl)(&buf, testEntry())
+	if got := buf.String(); got != "GET /users/42?verbose=1 200 users" {
+		t.Errorf("template = %s", got)
+	}
+}
+
+func TestSample(t *testing.T) {
+	l := &Logger{sampleRatio: 0}
+	if l.Sample(nil, 200) {
+		t.Error("request sampled with a ratio of 0")
+	}
+	if !l.Sample(nil, 503) {
+		t.Error("server error not sampled")
+	}
+	always := 1.0
+	if !l.Sample(&always, 200) {
+		t.Error("route ratio ignored")
+	}
+}
+
+func TestLogRedactsQuery(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Logger{format: jsonFormatter([]string{FIELD_REFERER}), writer: &buf, redactor: redact.Default()}
+	e := testEntry()
+	e.Path = "/login?access_token=secret"
+	e.Referer = "https://example.com/?password=secret"
+	l.Log(e)
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("query not redacted: %s", buf.String())
+	}
+	if e.Path != "/login?access_token=secret" {
+		t.Error("the entry was modified")
+	}
+}
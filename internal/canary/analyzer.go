@@ -0,0 +1,369 @@
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"go.uber.org/zap"
+)
+
+// loggerName is the logger of the analyses, its level can be changed on /admin/log/level
+const loggerName = "canary"
+
+// States of an analysis
+const (
+	STATE_RUNNING  = "running"
+	STATE_PAUSED   = "paused"
+	STATE_PROMOTED = "promoted"
+	STATE_ABORTED  = "aborted"
+)
+
+var states = []string{STATE_RUNNING, STATE_PAUSED, STATE_PROMOTED, STATE_ABORTED}
+
+// Decisions of an analysis, the actions of the admin API are decisions too
+const (
+	DECISION_HOLD     = "hold"
+	DECISION_STEP     = "step"
+	DECISION_ROLLBACK = "rollback"
+	ACTION_PAUSE      = "pause"
+	ACTION_RESUME     = "resume"
+	ACTION_PROMOTE    = "promote"
+	ACTION_ABORT      = "abort"
+)
+
+// ErrRunning is returned when a running analysis forbids a change of the weights
+var ErrRunning = errors.New("canary analysis is running, pause or abort it first")
+
+// Weights are the weights of the split groups by name
+type Weights interface {
+	Weights() map[string]int
+	SetWeights(weights map[string]int) error
+}
+
+// Decision is the outcome of an evaluation or an action
+type Decision struct {
+	Time     time.Time `json:"time"`
+	Decision string    `json:"decision"`
+	Reason   string    `json:"reason"`
+	// Weight is the canary weight in percent after the decision
+	Weight   int   `json:"weight"`
+	Baseline Stats `json:"baseline"`
+	Canary   Stats `json:"canary"`
+}
+
+// State describes an analysis on the admin API
+type State struct {
+	Application string    `json:"application"`
+	Route       string    `json:"route"`
+	Baseline    string    `json:"baseline"`
+	Canary      string    `json:"canary"`
+	State       string    `json:"state"`
+	Weight      int       `json:"weight"`
+	Steps       []int     `json:"steps"`
+	StepStart   time.Time `json:"step_start"`
+	Decision    *Decision `json:"last_decision,omitempty"`
+}
+
+// Analyzer compares the canary group of a split route with its baseline
+type Analyzer struct {
+	application string
+	route       string
+	cfg         Config
+	weights     Weights
+	metrics     *Metrics
+	baseline    *window
+	canary      *window
+
+	mu        sync.Mutex
+	state     string
+	stepStart time.Time
+	decision  *Decision
+}
+
+// New creates the analysis of a route, cfg must be validated. It starts from
+// the configured weights of the groups.
+func New(application, route string, cfg Config, weights Weights, metrics *Metrics) *Analyzer {
+	cfg = cfg.WithDefaults()
+	a := &Analyzer{
+		application: application,
+		route:       route,
+		cfg:         cfg,
+		weights:     weights,
+		metrics:     metrics,
+		baseline:    newWindow(cfg.Window),
+		canary:      newWindow(cfg.Window),
+		state:       STATE_RUNNING,
+		stepStart:   time.Now(),
+	}
+	a.publish()
+	return a
+}
+
+// Observe counts a response of the baseline or canary group, server errors
+// count as errors
+func (a *Analyzer) Observe(variant string, statusCode int, latency time.Duration) {
+	switch variant {
+	case a.cfg.Baseline:
+		a.baseline.observe(time.Now(), statusCode >= 500, latency)
+	case a.cfg.Canary:
+		a.canary.observe(time.Now(), statusCode >= 500, latency)
+	}
+}
+
+// Run evaluates the analysis every interval until ctx is done
+func (a *Analyzer) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.evaluate(now)
+		}
+	}
+}
+
+// evaluate rolls the canary back when it breaches a threshold and steps it
+// up when it stayed healthy for the step interval
+func (a *Analyzer) evaluate(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.state != STATE_RUNNING {
+		return
+	}
+	baseline := a.baseline.stats(now, a.cfg.LatencyQuantile)
+	canary := a.canary.stats(now, a.cfg.LatencyQuantile)
+	a.metrics.stats(a.application, a.route, a.cfg.Baseline, baseline)
+	a.metrics.stats(a.application, a.route, a.cfg.Canary, canary)
+	weight := a.weight()
+	enough := baseline.Requests >= *a.cfg.MinRequests && canary.Requests >= *a.cfg.MinRequests
+	switch {
+	case enough && canary.ErrorRate > baseline.ErrorRate+*a.cfg.MaxErrorRateIncrease:
+		a.rollback(now, fmt.Sprintf("error rate %.4f is above the baseline %.4f by more than %.4f", canary.ErrorRate, baseline.ErrorRate, *a.cfg.MaxErrorRateIncrease), baseline, canary)
+	case enough && baseline.Latency > 0 && canary.Latency > baseline.Latency*a.cfg.MaxLatencyRatio:
+		a.rollback(now, fmt.Sprintf("p%g latency %.3fs is above %g times the baseline %.3fs", a.cfg.LatencyQuantile*100, canary.Latency, a.cfg.MaxLatencyRatio, baseline.Latency), baseline, canary)
+	case weight > 0 && !enough:
+		a.decide(now, DECISION_HOLD, fmt.Sprintf("%d baseline and %d canary requests, %d required", baseline.Requests, canary.Requests, *a.cfg.MinRequests), baseline, canary)
+	case weight > 0 && now.Sub(a.stepStart) < a.cfg.StepInterval:
+		a.decide(now, DECISION_HOLD, "healthy, waiting for the step interval", baseline, canary)
+	default:
+		next := a.nextStep(weight)
+		if err := a.setWeight(next); err != nil {
+			a.decide(now, DECISION_HOLD, err.Error(), baseline, canary)
+			return
+		}
+		a.stepStart = now
+		if next == 100 {
+			a.state = STATE_PROMOTED
+		}
+		a.decide(now, DECISION_STEP, fmt.Sprintf("healthy, canary weight stepped from %d to %d", weight, next), baseline, canary)
+	}
+}
+
+func (a *Analyzer) rollback(now time.Time, reason string, baseline, canary Stats) {
+	if err := a.setWeight(0); err != nil {
+		reason += ", " + err.Error()
+	}
+	a.state = STATE_ABORTED
+	a.decide(now, DECISION_ROLLBACK, reason, baseline, canary)
+}
+
+// Control applies an action of the admin API
+func (a *Analyzer) Control(action string) (State, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	var reason string
+	switch action {
+	case ACTION_PAUSE:
+		if a.state != STATE_RUNNING {
+			return a.stateLocked(), fmt.Errorf("analysis is %s, only a running analysis can be paused", a.state)
+		}
+		a.state, reason = STATE_PAUSED, "paused by the admin API"
+	case ACTION_RESUME:
+		if a.state == STATE_RUNNING {
+			return a.stateLocked(), fmt.Errorf("analysis is already running")
+		}
+		if a.state != STATE_PAUSED {
+			// a finished analysis starts over from the first step
+			a.baseline.reset()
+			a.canary.reset()
+			if err := a.setWeight(0); err != nil {
+				return a.stateLocked(), err
+			}
+		}
+		a.state, a.stepStart, reason = STATE_RUNNING, now, "resumed by the admin API"
+	case ACTION_PROMOTE:
+		if err := a.setWeight(100); err != nil {
+			return a.stateLocked(), err
+		}
+		a.state, reason = STATE_PROMOTED, "promoted by the admin API"
+	case ACTION_ABORT:
+		if err := a.setWeight(0); err != nil {
+			return a.stateLocked(), err
+		}
+		a.state, reason = STATE_ABORTED, "aborted by the admin API"
+	default:
+		return a.stateLocked(), fmt.Errorf("unknown action %q, expected one of pause, resume, promote, abort", action)
+	}
+	a.decide(now, action, reason, a.baseline.stats(now, a.cfg.LatencyQuantile), a.canary.stats(now, a.cfg.LatencyQuantile))
+	return a.stateLocked(), nil
+}
+
+// UnlessRunning calls change unless the analysis is running, the analysis can
+// neither step the weights nor be resumed until change returns
+func (a *Analyzer) UnlessRunning(change func() error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.state == STATE_RUNNING {
+		return ErrRunning
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	a.publish()
+	return nil
+}
+
+// State returns the state of the analysis
+func (a *Analyzer) State() State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stateLocked()
+}
+
+func (a *Analyzer) stateLocked() State {
+	return State{
+		Application: a.application,
+		Route:       a.route,
+		Baseline:    a.cfg.Baseline,
+		Canary:      a.cfg.Canary,
+		State:       a.state,
+		Weight:      a.weight(),
+		Steps:       a.cfg.Steps,
+		StepStart:   a.stepStart,
+		Decision:    a.decision,
+	}
+}
+
+// decide records the decision, holds are only logged at debug level
+func (a *Analyzer) decide(now time.Time, decision, reason string, baseline, canary Stats) {
+	a.decision = &Decision{Time: now, Decision: decision, Reason: reason, Weight: a.weight(), Baseline: baseline, Canary: canary}
+	a.metrics.decide(a.application, a.route, decision)
+	a.publish()
+	fields := []zap.Field{
+		zap.String("application", a.application),
+		zap.String("route", a.route),
+		zap.String("decision", decision),
+		zap.String("reason", reason),
+		zap.String("state", a.state),
+		zap.Int("weight", a.decision.Weight),
+		zap.Any("baseline", baseline),
+		zap.Any("canary", canary),
+	}
+	logger := log.Named(loggerName)
+	switch decision {
+	case DECISION_HOLD:
+		logger.Debug("canary analysis", fields...)
+	case DECISION_ROLLBACK:
+		logger.Warn("canary analysis", fields...)
+	default:
+		logger.Info("canary analysis", fields...)
+	}
+}
+
+// publish updates the weight and state gauges
+func (a *Analyzer) publish() {
+	weights := a.weights.Weights()
+	a.metrics.weight(a.application, a.route, a.cfg.Baseline, weights[a.cfg.Baseline])
+	a.metrics.weight(a.application, a.route, a.cfg.Canary, weights[a.cfg.Canary])
+	a.metrics.state(a.application, a.route, a.state)
+}
+
+// weight returns the canary weight in percent of the baseline and canary weights
+func (a *Analyzer) weight() int {
+	weights := a.weights.Weights()
+	total := weights[a.cfg.Baseline] + weights[a.cfg.Canary]
+	if total == 0 {
+		return 0
+	}
+	return weights[a.cfg.Canary] * 100 / total
+}
+
+func (a *Analyzer) setWeight(percent int) error {
+	return a.weights.SetWeights(map[string]int{a.cfg.Baseline: 100 - percent, a.cfg.Canary: percent})
+}
+
+// nextStep returns the first step above the weight, 100 past the last step
+func (a *Analyzer) nextStep(weight int) int {
+	for _, step := range a.cfg.Steps {
+		if step > weight {
+			return step
+		}
+	}
+	return 100
+}
+
+// Analyzers holds the analyses of every virtual host
+type Analyzers struct {
+	analyzers []*Analyzer
+}
+
+// Add registers an analysis
+func (s *Analyzers) Add(a *Analyzer) {
+	s.analyzers = append(s.analyzers, a)
+}
+
+// Len returns the number of analyses
+func (s *Analyzers) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.analyzers)
+}
+
+// Get returns the analysis of a route, application may be empty when the
+// route name is unique
+func (s *Analyzers) Get(application, route string) (*Analyzer, error) {
+	var found *Analyzer
+	for _, a := range s.analyzers {
+		if a.route != route || (application != "" && a.application != application) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("route %q is analysed by several applications, give the application", route)
+		}
+		found = a
+	}
+	if found == nil {
+		return nil, fmt.Errorf("route %q has no canary analysis", route)
+	}
+	return found, nil
+}
+
+// States returns the state of every analysis
+func (s *Analyzers) States() []State {
+	states := make([]State, 0, len(s.analyzers))
+	for _, a := range s.analyzers {
+		states = append(states, a.State())
+	}
+	return states
+}
+
+// Run runs every analysis until ctx is done
+func (s *Analyzers) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, a := range s.analyzers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,130 @@
+package canary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type weights map[string]int
+
+func (w weights) Weights() map[string]int { return w }
+
+func (w weights) SetWeights(updated map[string]int) error {
+	for name, weight := range updated {
+		w[name] = weight
+	}
+	return nil
+}
+
+func TestAnalyzer(t *testing.T) {
+	split := weights{"stable": 90, "canary": 10}
+	cfg := Config{Baseline: "stable", Canary: "canary", Steps: []int{10, 50, 100}, Window: 10 * time.Minute, MinRequests: ptr[int64](10)}
+	if err := cfg.Validate([]string{"stable", "canary"}); err != nil {
+		t.Fatal(err)
+	}
+	a := New("app", "users", cfg, split, NewMetrics(prometheus.NewRegistry(), "", "", nil))
+	observe := func(variant string, n, statusCode int, latency time.Duration) {
+		for i := 0; i < n; i++ {
+			a.Observe(variant, statusCode, latency)
+		}
+	}
+	later := time.Now().Add(DEFAULT_STEP_INTERVAL + time.Second)
+
+	observe("stable", 20, 200, 10*time.Millisecond)
+	observe("canary", 20, 200, 12*time.Millisecond)
+	a.evaluate(later)
+	if state := a.State(); state.Weight != 50 || state.Decision.Decision != DECISION_STEP {
+		t.Fatalf("healthy canary: %+v", state)
+	}
+	a.evaluate(later.Add(time.Second))
+	if state := a.State(); state.Weight != 50 || state.Decision.Decision != DECISION_HOLD {
+		t.Fatalf("within the step interval: %+v", state)
+	}
+
+	observe("canary", 20, 503, 12*time.Millisecond)
+	a.evaluate(later.Add(2 * time.Second))
+	if state := a.State(); state.Weight != 0 || state.State != STATE_ABORTED || state.Decision.Decision != DECISION_ROLLBACK {
+		t.Fatalf("failing canary: %+v", state)
+	}
+	if split["stable"] != 100 || split["canary"] != 0 {
+		t.Errorf("weights after rollback = %v", split)
+	}
+
+	if _, err := a.Control(ACTION_RESUME); err != nil {
+		t.Fatal(err)
+	}
+	a.evaluate(time.Now())
+	if state := a.State(); state.Weight != 10 || state.State != STATE_RUNNING {
+		t.Fatalf("restarted analysis: %+v", state)
+	}
+	if state, err := a.Control(ACTION_PROMOTE); err != nil || state.Weight != 100 || state.State != STATE_PROMOTED {
+		t.Fatalf("promoted analysis: %+v, %v", state, err)
+	}
+	if _, err := a.Control(ACTION_PAUSE); err == nil {
+		t.Error("a promoted analysis was paused")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := Config{}.WithDefaults()
+	if *cfg.MinRequests != DEFAULT_MIN_REQUESTS || *cfg.MaxErrorRateIncrease != DEFAULT_MAX_ERROR_RATE_INCREASE {
+		t.Errorf("unset thresholds = %d, %v", *cfg.MinRequests, *cfg.MaxErrorRateIncrease)
+	}
+	// 0 is a threshold of its own, not an unset one
+	cfg = Config{MinRequests: ptr[int64](0), MaxErrorRateIncrease: ptr(0.0)}.WithDefaults()
+	if *cfg.MinRequests != 0 || *cfg.MaxErrorRateIncrease != 0 {
+		t.Errorf("zero thresholds = %d, %v", *cfg.MinRequests, *cfg.MaxErrorRateIncrease)
+	}
+	cfg = Config{Baseline: "stable", Canary: "canary", Steps: []int{50}, MaxErrorRateIncrease: ptr(-0.1)}
+	if err := cfg.Validate([]string{"stable", "canary"}); err == nil {
+		t.Error("negative max error rate increase accepted")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch func(*Config)
+		valid bool
+	}{
+		{"defaults", func(*Config) {}, true},
+		{"shortest window", func(c *Config) { c.Window = MIN_WINDOW }, true},
+		{"window shorter than its slots", func(c *Config) { c.Window = WINDOW_SLOTS - 1 }, false},
+		{"window shorter than the minimum", func(c *Config) { c.Window = MIN_WINDOW - 1 }, false},
+		{"negative window", func(c *Config) { c.Window = -time.Minute }, false},
+		{"negative interval", func(c *Config) { c.Interval = -time.Second }, false},
+		{"negative step interval", func(c *Config) { c.StepInterval = -time.Second }, false},
+		{"negative min requests", func(c *Config) { c.MinRequests = ptr[int64](-1) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Baseline: "stable", Canary: "canary", Steps: []int{50}}
+			tt.patch(&cfg)
+			if err := cfg.Validate([]string{"stable", "canary"}); (err == nil) != tt.valid {
+				t.Errorf("error = %v, valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestWindowLatency(t *testing.T) {
+	w := newWindow(time.Minute)
+	now := time.Now()
+	for i := 0; i < 99; i++ {
+		w.observe(now, false, 10*time.Millisecond)
+	}
+	w.observe(now, true, 2*time.Second)
+	stats := w.stats(now, 0.99)
+	if stats.Requests != 100 || stats.Errors != 1 || stats.Latency < 0.01 || stats.Latency > 0.02 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats := w.stats(now.Add(2*time.Minute), 0.99); stats.Requests != 0 {
+		t.Errorf("requests older than the window: %+v", stats)
+	}
+}
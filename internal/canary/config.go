@@ -0,0 +1,122 @@
+package canary
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Defaults of the analysis
+const (
+	DEFAULT_INTERVAL                = 30 * time.Second
+	DEFAULT_WINDOW                  = 5 * time.Minute
+	DEFAULT_STEP_INTERVAL           = 5 * time.Minute
+	DEFAULT_MIN_REQUESTS            = 50
+	DEFAULT_MAX_ERROR_RATE_INCREASE = 0.01
+	DEFAULT_LATENCY_QUANTILE        = 0.99
+	DEFAULT_MAX_LATENCY_RATIO       = 1.5
+)
+
+// MIN_WINDOW is the shortest window, each of its slots lasts at least a millisecond
+const MIN_WINDOW = WINDOW_SLOTS * time.Millisecond
+
+// Config is the analysis of a split route, the canary group is stepped up
+// while it is as healthy as the baseline group and set to 0 otherwise
+type Config struct {
+	// Baseline and Canary are group names of the split
+	Baseline string `mapstructure:"baseline"`
+	Canary   string `mapstructure:"canary"`
+	// Steps are the canary weights in percent, e.g. [5, 25, 50, 100], the
+	// baseline takes the rest
+	Steps []int `mapstructure:"steps"`
+	// StepInterval is the time a step must stay healthy before the next one
+	StepInterval time.Duration `mapstructure:"step-interval"`
+	// Interval is the time between two evaluations
+	Interval time.Duration `mapstructure:"interval"`
+	// Window is the sliding duration the variants are compared over
+	Window time.Duration `mapstructure:"window"`
+	// MinRequests of each variant in the window before a decision is made,
+	// DEFAULT_MIN_REQUESTS when unset, 0 decides on any traffic
+	MinRequests *int64 `mapstructure:"min-requests"`
+	// MaxErrorRateIncrease is the largest canary error rate above the baseline,
+	// e.g. 0.01, DEFAULT_MAX_ERROR_RATE_INCREASE when unset, 0 rolls back on any increase
+	MaxErrorRateIncrease *float64 `mapstructure:"max-error-rate-increase"`
+	// LatencyQuantile is the compared latency, e.g. 0.99
+	LatencyQuantile float64 `mapstructure:"latency-quantile"`
+	// MaxLatencyRatio is the largest canary latency relative to the baseline, e.g. 1.5
+	MaxLatencyRatio float64 `mapstructure:"max-latency-ratio"`
+}
+
+// Enabled reports whether the split is analysed
+func (c Config) Enabled() bool {
+	return c.Canary != ""
+}
+
+// WithDefaults fills the unset durations and thresholds, the thresholds that
+// may be 0 are pointers to tell them from unset ones
+func (c Config) WithDefaults() Config {
+	if c.Interval == 0 {
+		c.Interval = DEFAULT_INTERVAL
+	}
+	if c.Window == 0 {
+		c.Window = DEFAULT_WINDOW
+	}
+	if c.StepInterval == 0 {
+		c.StepInterval = DEFAULT_STEP_INTERVAL
+	}
+	if c.MinRequests == nil {
+		minRequests := int64(DEFAULT_MIN_REQUESTS)
+		c.MinRequests = &minRequests
+	}
+	if c.MaxErrorRateIncrease == nil {
+		maxErrorRateIncrease := DEFAULT_MAX_ERROR_RATE_INCREASE
+		c.MaxErrorRateIncrease = &maxErrorRateIncrease
+	}
+	if c.LatencyQuantile == 0 {
+		c.LatencyQuantile = DEFAULT_LATENCY_QUANTILE
+	}
+	if c.MaxLatencyRatio == 0 {
+		c.MaxLatencyRatio = DEFAULT_MAX_LATENCY_RATIO
+	}
+	return c
+}
+
+// Validate checks the steps and thresholds, groups is the names of the split groups
+func (c Config) Validate(groups []string) error {
+	if !c.Enabled() {
+		return nil
+	}
+	c = c.WithDefaults()
+	known := func(name string) bool {
+		for _, group := range groups {
+			if group == name {
+				return true
+			}
+		}
+		return false
+	}
+	if !known(c.Baseline) || !known(c.Canary) || c.Baseline == c.Canary {
+		return fmt.Errorf("analysis requires distinct baseline and canary groups of the split, got %q and %q", c.Baseline, c.Canary)
+	}
+	if len(c.Steps) == 0 {
+		return errors.New("analysis requires steps, e.g. [5, 25, 50, 100]")
+	}
+	for i, step := range c.Steps {
+		if step <= 0 || step > 100 || (i > 0 && step <= c.Steps[i-1]) {
+			return fmt.Errorf("analysis steps must be increasing percents between 1 and 100, got %v", c.Steps)
+		}
+	}
+	if c.Interval <= 0 || c.StepInterval <= 0 {
+		return fmt.Errorf("analysis interval and step interval must be positive, got %s and %s", c.Interval, c.StepInterval)
+	}
+	if c.Window < MIN_WINDOW {
+		return fmt.Errorf("analysis window must be at least %s, got %s", MIN_WINDOW, c.Window)
+	}
+	if *c.MinRequests < 0 {
+		return errors.New("analysis min requests must not be negative")
+	}
+	if c.LatencyQuantile <= 0 || c.LatencyQuantile > 1 || *c.MaxErrorRateIncrease < 0 || c.MaxLatencyRatio < 1 {
+		return errors.New("analysis latency quantile must be in (0, 1], max error rate increase not negative and max latency ratio at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package canary
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the canary analyses
+type Metrics struct {
+	weights   *prometheus.GaugeVec
+	states    *prometheus.GaugeVec
+	decisions *prometheus.CounterVec
+	errorRate *prometheus.GaugeVec
+	latency   *prometheus.GaugeVec
+}
+
+// NewMetrics registers the analysis metrics, namespace, subsystem and const
+// labels are those of the request metrics
+func NewMetrics(registerer prometheus.Registerer, namespace, subsystem string, constLabels map[string]string) *Metrics {
+	factory := promauto.With(registerer)
+	gauge := func(name, help string, labels ...string) *prometheus.GaugeVec {
+		return factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		}, append([]string{"application", "route"}, labels...))
+	}
+	return &Metrics{
+		weights:   gauge("canary_weight", "Weight of the baseline and canary groups of an analysed route", "variant"),
+		states:    gauge("canary_state", "State of the canary analysis, 1 for the current state: running, paused, promoted or aborted", "state"),
+		errorRate: gauge("canary_error_rate", "Server error rate of the variant over the analysis window", "variant"),
+		latency:   gauge("canary_latency_seconds", "Latency quantile of the variant over the analysis window", "variant"),
+		decisions: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "canary_decisions",
+			Help:        "Total count of canary analysis decisions: hold, step, rollback, pause, resume, promote or abort",
+			ConstLabels: constLabels,
+		}, []string{"application", "route", "decision"}),
+	}
+}
+
+func (m *Metrics) weight(application, route, variant string, weight int) {
+	m.weights.WithLabelValues(application, route, variant).Set(float64(weight))
+}
+
+func (m *Metrics) state(application, route, current string) {
+	for _, state := range states {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		m.states.WithLabelValues(application, route, state).Set(value)
+	}
+}
+
+func (m *Metrics) decide(application, route, decision string) {
+	m.decisions.WithLabelValues(application, route, decision).Inc()
+}
+
+func (m *Metrics) stats(application, route, variant string, stats Stats) {
+	m.errorRate.WithLabelValues(application, route, variant).Set(stats.ErrorRate)
+	m.latency.WithLabelValues(application, route, variant).Set(stats.Latency)
+}
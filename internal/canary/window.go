@@ -0,0 +1,117 @@
+package canary
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WINDOW_SLOTS is the number of slots a window is divided in, requests
+// leave the window one slot at a time
+const WINDOW_SLOTS = 10
+
+// latencyBuckets are the upper bounds of the latency histogram, from 1ms to about 1m
+var latencyBuckets = prometheus.ExponentialBuckets(0.001, 1.5, 28)
+
+// Stats are the requests of a variant over the window
+type Stats struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	// ErrorRate is the ratio of server errors
+	ErrorRate float64 `json:"error_rate"`
+	// Latency is the latency quantile in seconds, estimated from histogram buckets
+	Latency float64 `json:"latency_seconds"`
+}
+
+type slot struct {
+	index    int64
+	requests int64
+	errors   int64
+	buckets  []int64
+}
+
+// window counts requests over a sliding duration
+type window struct {
+	mu    sync.Mutex
+	width time.Duration
+	slots []slot
+}
+
+func newWindow(duration time.Duration) *window {
+	w := &window{width: duration / WINDOW_SLOTS, slots: make([]slot, WINDOW_SLOTS)}
+	for i := range w.slots {
+		w.slots[i].buckets = make([]int64, len(latencyBuckets)+1)
+		w.slots[i].index = -1
+	}
+	return w
+}
+
+func (w *window) observe(now time.Time, isError bool, latency time.Duration) {
+	index := now.UnixNano() / int64(w.width)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &w.slots[index%WINDOW_SLOTS]
+	if s.index != index {
+		s.index, s.requests, s.errors = index, 0, 0
+		clear(s.buckets)
+	}
+	s.requests++
+	if isError {
+		s.errors++
+	}
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if latency.Seconds() <= bound {
+			bucket = i
+			break
+		}
+	}
+	s.buckets[bucket]++
+}
+
+// stats sums the slots still in the window, the latency is the given quantile
+func (w *window) stats(now time.Time, quantile float64) Stats {
+	index := now.UnixNano() / int64(w.width)
+	buckets := make([]int64, len(latencyBuckets)+1)
+	var stats Stats
+	w.mu.Lock()
+	for _, s := range w.slots {
+		if s.index < 0 || index-s.index >= WINDOW_SLOTS {
+			continue
+		}
+		stats.Requests += s.requests
+		stats.Errors += s.errors
+		for i, count := range s.buckets {
+			buckets[i] += count
+		}
+	}
+	w.mu.Unlock()
+	if stats.Requests == 0 {
+		return stats
+	}
+	stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+	rank := int64(quantile * float64(stats.Requests))
+	var seen int64
+	for i, count := range buckets {
+		seen += count
+		if seen >= rank && seen > 0 {
+			if i == len(latencyBuckets) {
+				// slower than the last bucket, the last bound is a lower estimate
+				i--
+			}
+			stats.Latency = latencyBuckets[i]
+			break
+		}
+	}
+	return stats
+}
+
+// reset forgets every request, e.g. after the weights changed
+func (w *window) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range w.slots {
+		w.slots[i].index = -1
+	}
+}
@@ -0,0 +1,135 @@
+package capture
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+)
+
+// DEFAULT_DEBUG_HEADER forces the capture of a request when it carries the shared secret
+const DEFAULT_DEBUG_HEADER = "X-Debug-Capture"
+
+// Reasons a request was captured
+const (
+	REASON_HEADER = "header"
+	REASON_STATUS = "status"
+	REASON_SAMPLE = "sample"
+)
+
+// DefaultContentTypes are the content type prefixes whose bodies are recorded
+var DefaultContentTypes = []string{
+	"application/json", "application/problem+json", "application/xml",
+	"application/x-www-form-urlencoded", "text/",
+}
+
+// Config selects the requests whose bodies are recorded, capture is disabled
+// unless a sample ratio, a status or a debug secret is set
+type Config struct {
+	SampleRatio float64
+	// Routes restricts the sampling and status filter to route names, every route when empty
+	Routes []string
+	// Statuses are status codes or classes, e.g. 500 or 5xx
+	Statuses []string
+	// DebugHeader and DebugSecret force the capture of a request carrying the secret
+	DebugHeader string
+	DebugSecret string
+	// MaxBodyBytes caps each recorded body, bodies are truncated past it
+	MaxBodyBytes int
+	// ContentTypes are the content type prefixes whose bodies are recorded
+	ContentTypes []string
+	Output       OutputConfig
+}
+
+// Enabled reports whether any request can be captured
+func (c Config) Enabled() bool {
+	return c.SampleRatio > 0 || len(c.Statuses) > 0 || c.DebugSecret != ""
+}
+
+// Validate checks the ratio, statuses and body cap
+func (c Config) Validate() error {
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("capture sample ratio must be between 0 and 1, got %v", c.SampleRatio)
+	}
+	if _, err := utils.ParseStatusFilter(c.Statuses); err != nil {
+		return errors.Join(errors.New("invalid capture statuses"), err)
+	}
+	if c.DebugSecret != "" && c.DebugHeader == "" {
+		return errors.New("capture debug header is required with a debug secret")
+	}
+	if c.MaxBodyBytes <= 0 {
+		return fmt.Errorf("capture max body size must be greater than 0, got %d", c.MaxBodyBytes)
+	}
+	return nil
+}
+
+// Capturer decides which requests are captured and writes their records
+type Capturer struct {
+	cfg      Config
+	routes   map[string]bool
+	statuses utils.StatusFilter
+	sink     *sink
+	redactor *redact.Redactor
+}
+
+// New creates the capturer and opens its sink, the redactor masks the
+// headers, urls and bodies of every record
+func New(cfg Config, redactor *redact.Redactor) (*Capturer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	statuses, _ := utils.ParseStatusFilter(cfg.Statuses)
+	c := &Capturer{cfg: cfg, statuses: statuses, sink: newSink(cfg.Output), redactor: redactor}
+	if len(cfg.Routes) > 0 {
+		c.routes = make(map[string]bool, len(cfg.Routes))
+		for _, name := range cfg.Routes {
+			c.routes[name] = true
+		}
+	}
+	if len(c.cfg.ContentTypes) == 0 {
+		c.cfg.ContentTypes = DefaultContentTypes
+	}
+	return c, nil
+}
+
+// Forced reports whether the request carries the debug secret, the debug
+// header is removed so that it never reaches the upstream
+func (c *Capturer) Forced(r *http.Request) bool {
+	if c.cfg.DebugHeader == "" {
+		return false
+	}
+	value := r.Header.Get(c.cfg.DebugHeader)
+	if value == "" {
+		return false
+	}
+	r.Header.Del(c.cfg.DebugHeader)
+	return c.cfg.DebugSecret != "" && subtle.ConstantTimeCompare([]byte(value), []byte(c.cfg.DebugSecret)) == 1
+}
+
+// MatchesRoute reports whether the sampling and status filter apply to the route
+func (c *Capturer) MatchesRoute(name string) bool {
+	return c.routes == nil || c.routes[name]
+}
+
+// Decide returns the reason a handled request is captured, ok is false when it is not
+func (c *Capturer) Decide(forced bool, status int) (reason string, ok bool) {
+	if forced {
+		return REASON_HEADER, true
+	}
+	if c.statuses.Matches(status) {
+		return REASON_STATUS, true
+	}
+	if c.cfg.SampleRatio > 0 && (c.cfg.SampleRatio >= 1 || rand.Float64() < c.cfg.SampleRatio) {
+		return REASON_SAMPLE, true
+	}
+	return "", false
+}
+
+// Close closes the sink
+func (c *Capturer) Close() error {
+	return c.sink.close()
+}
@@ -0,0 +1,81 @@
+package capture
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+func newTestCapturer(t *testing.T, cfg Config) *Capturer {
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 16
+	}
+	c, err := New(cfg, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDecide(t *testing.T) {
+	c := newTestCapturer(t, Config{Statuses: []string{"5xx", "429"}, DebugHeader: DEFAULT_DEBUG_HEADER, DebugSecret: "s3cr3t"})
+	tests := []struct {
+		forced   bool
+		status   int
+		expected string
+	}{
+		{true, 200, REASON_HEADER},
+		{false, 503, REASON_STATUS},
+		{false, 429, REASON_STATUS},
+		{false, 404, ""},
+	}
+	for _, tt := range tests {
+		if reason, _ := c.Decide(tt.forced, tt.status); reason != tt.expected {
+			t.Errorf("Decide(%v, %d) = %q, want %q", tt.forced, tt.status, reason, tt.expected)
+		}
+	}
+}
+
+func TestForced(t *testing.T) {
+	c := newTestCapturer(t, Config{DebugHeader: DEFAULT_DEBUG_HEADER, DebugSecret: "s3cr3t"})
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(DEFAULT_DEBUG_HEADER, "wrong")
+	if c.Forced(r) {
+		t.Error("wrong secret accepted")
+	}
+	r.Header.Set(DEFAULT_DEBUG_HEADER, "s3cr3t")
+	if !c.Forced(r) {
+		t.Error("secret rejected")
+	}
+	if r.Header.Get(DEFAULT_DEBUG_HEADER) != "" {
+		t.Error("debug header forwarded to the upstream")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	c := newTestCapturer(t, Config{SampleRatio: 1})
+	header := http.Header{"Content-Type": {"application/json"}, "Authorization": {"Bearer abc"}}
+
+	body := c.NewBody(io.NopCloser(strings.NewReader(`{"email":"bob@example.com","name":"bob"}`)))
+	io.ReadAll(body)
+	message := c.RequestMessage(header, body)
+	if message.Size != 40 || strings.Contains(message.Body, "bob@") || !message.Truncated || len(message.Body) > 16 {
+		t.Errorf("request message = %+v", message)
+	}
+	if message.Header.Get("Authorization") != redact.REDACTED {
+		t.Errorf("authorization = %s", message.Header.Get("Authorization"))
+	}
+
+	message = c.ResponseMessage(header, []byte(`{"a":"x@ex.io"}`))
+	if message.Body != `{"a":"`+redact.REDACTED+`"}` || message.Truncated {
+		t.Errorf("response message = %+v", message)
+	}
+
+	message = c.ResponseMessage(http.Header{"Content-Type": {"image/png"}}, []byte{0x89, 'P', 'N', 'G'})
+	if message.Body != "" || message.Omitted != "content type image/png" {
+		t.Errorf("image message = %+v", message)
+	}
+}
@@ -0,0 +1,135 @@
+package capture
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+// Record is a captured request and its response
+type Record struct {
+	Time      time.Time      `json:"timestamp"`
+	Reason    string         `json:"reason"`
+	RequestID string         `json:"request_id,omitempty"`
+	Trace     map[string]any `json:"trace,omitempty"`
+	Route     string         `json:"route,omitempty"`
+	Method    string         `json:"method"`
+	URL       string         `json:"url"`
+	Status    int            `json:"status_code"`
+	// ResponseTime is the seconds the proxy took to serve the request, as in the access log
+	ResponseTime float64 `json:"response_time"`
+	Request      Message `json:"request"`
+	Response     Message `json:"response"`
+}
+
+// Message is the headers and body of a request or a response
+type Message struct {
+	Header http.Header `json:"header"`
+	Body   string      `json:"body,omitempty"`
+	// Size is the size of the whole body
+	Size int64 `json:"size"`
+	// Truncated is true when the body is cut at the max body size
+	Truncated bool `json:"truncated,omitempty"`
+	// Omitted tells why the body is not recorded, e.g. its content type
+	Omitted string `json:"omitted,omitempty"`
+}
+
+// Body keeps the first bytes of a request body while the proxy reads it,
+// it is safe to read the kept bytes while the body is read
+type Body struct {
+	io.ReadCloser
+	mu   sync.Mutex
+	buf  []byte
+	max  int
+	size int64
+}
+
+// TeeBody wraps a body and keeps at most max bytes of it
+func TeeBody(body io.ReadCloser, max int) *Body {
+	return &Body{ReadCloser: body, max: max}
+}
+
+// NewBody wraps a request body, the capture keeps at most the max body size
+func (c *Capturer) NewBody(body io.ReadCloser) *Body {
+	return TeeBody(body, redact.KeptBytes(c.cfg.MaxBodyBytes))
+}
+
+func (b *Body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	b.size += int64(n)
+	if keep := min(n, b.max-len(b.buf)); keep > 0 {
+		b.buf = append(b.buf, p[:keep]...)
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+// Bytes returns the kept bytes and the size read so far
+func (b *Body) Bytes() ([]byte, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf, b.size
+}
+
+// RequestMessage builds the message of a request whose body was wrapped by NewBody
+func (c *Capturer) RequestMessage(header http.Header, body *Body) Message {
+	kept, size := body.Bytes()
+	return c.message(header, kept, size)
+}
+
+// ResponseMessage builds the message of a response from its whole body
+func (c *Capturer) ResponseMessage(header http.Header, body []byte) Message {
+	return c.message(header, redact.KeptBody(body, c.cfg.MaxBodyBytes), int64(len(body)))
+}
+
+func (c *Capturer) message(header http.Header, body []byte, size int64) Message {
+	message := Message{Header: c.redactor.Header(header), Size: size}
+	if size == 0 {
+		return message
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		message.Omitted = "content encoding " + encoding
+		return message
+	}
+	if contentType := header.Get("Content-Type"); !c.recordsContentType(contentType) {
+		message.Omitted = "content type " + contentType
+		return message
+	}
+	if !utf8.Valid(body) {
+		body = redact.TrimCutRune(body)
+		if !utf8.Valid(body) {
+			message.Omitted = "binary body"
+			return message
+		}
+	}
+	message.Truncated = size > int64(c.cfg.MaxBodyBytes)
+	message.Body = c.redactor.TruncatedBody(body, size, c.cfg.MaxBodyBytes)
+	return message
+}
+
+func (c *Capturer) recordsContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// bodies without a content type are recorded when they are text
+		return contentType == ""
+	}
+	for _, prefix := range c.cfg.ContentTypes {
+		if strings.HasPrefix(mediaType, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record redacts the url of the record and writes it to the sink
+func (c *Capturer) Record(record *Record) {
+	record.URL = c.redactor.URL(c.redactor.String(record.URL))
+	c.sink.write(record)
+}
@@ -0,0 +1,78 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	OUTPUT_STDOUT = "stdout"
+	OUTPUT_STDERR = "stderr"
+)
+
+// OutputConfig is the destination of the capture records, kept apart from
+// the access log since records hold request and response bodies
+type OutputConfig struct {
+	// Path is stdout, stderr or a file path
+	Path string
+	// MaxSizeMB rotates the file once it grows past the size, 100 when 0
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+}
+
+// sink writes one json record per line, it is safe for concurrent use
+type sink struct {
+	mu      sync.Mutex
+	writer  io.Writer
+	rotator *lumberjack.Logger
+}
+
+func newSink(cfg OutputConfig) *sink {
+	s := &sink{}
+	switch cfg.Path {
+	case OUTPUT_STDOUT:
+		s.writer = os.Stdout
+	case "", OUTPUT_STDERR:
+		s.writer = os.Stderr
+	default:
+		s.rotator = &lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+		s.writer = s.rotator
+	}
+	return s
+}
+
+func (s *sink) write(record *Record) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode capture record: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.writer.Write(line); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write capture record: %v\n", err)
+	}
+}
+
+func (s *sink) close() error {
+	if s.rotator == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotator.Close()
+}
@@ -0,0 +1,154 @@
+package har
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+// Exchange is what the proxy saw of a request and its response
+type Exchange struct {
+	Start, End time.Time
+	Request    *http.Request
+	// RequestHeader is the header sent by the client
+	RequestHeader http.Header
+	// RequestBody holds the first bytes of the body, see TeeSize
+	RequestBody     []byte
+	RequestBodySize int64
+	Status          int
+	ResponseHeader  http.Header
+	ResponseBody    []byte
+	Timing          *Timing
+	Comment         string
+}
+
+// TeeSize is the number of request body bytes to keep for an exchange
+func (r *Recorder) TeeSize() int {
+	return redact.KeptBytes(r.cfg.MaxBodyBytes)
+}
+
+// NewEntry builds the redacted entry of an exchange
+func (r *Recorder) NewEntry(e *Exchange) *Entry {
+	entry := &Entry{
+		StartedDateTime: e.Start,
+		Time:            milliseconds(e.End.Sub(e.Start)),
+		Request:         r.request(e),
+		Response:        r.response(e),
+		Timings:         e.Timing.Timings(e.Start, e.End),
+		ServerIPAddress: e.Timing.ServerAddress(),
+		Comment:         e.Comment,
+	}
+	return entry
+}
+
+func (r *Recorder) request(e *Exchange) Request {
+	req := e.Request
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: req.Host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: r.redactor.Query(req.URL.RawQuery)}
+	request := Request{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     r.cookies("Cookie", (&http.Request{Header: e.RequestHeader}).Cookies()),
+		Headers:     r.headers(e.RequestHeader),
+		QueryString: []NameValue{},
+		HeadersSize: -1,
+		BodySize:    e.RequestBodySize,
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			if r.redactor.IsSensitiveParam(name) {
+				value = redact.REDACTED
+			}
+			request.QueryString = append(request.QueryString, NameValue{Name: name, Value: value})
+		}
+	}
+	sortNameValues(request.QueryString)
+	if e.RequestBodySize > 0 {
+		text, encoding, comment := r.body(e.RequestBody, e.RequestBodySize)
+		request.PostData = &PostData{
+			MimeType: e.RequestHeader.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		}
+	}
+	return request
+}
+
+func (r *Recorder) response(e *Exchange) Response {
+	response := Response{
+		Status:      e.Status,
+		StatusText:  http.StatusText(e.Status),
+		HTTPVersion: e.Request.Proto,
+		Cookies:     r.cookies("Set-Cookie", (&http.Response{Header: e.ResponseHeader}).Cookies()),
+		Headers:     r.headers(e.ResponseHeader),
+		RedirectURL: r.redactor.URL(e.ResponseHeader.Get("Location")),
+		HeadersSize: -1,
+		BodySize:    int64(len(e.ResponseBody)),
+	}
+	size := int64(len(e.ResponseBody))
+	text, encoding, comment := r.body(redact.KeptBody(e.ResponseBody, r.cfg.MaxBodyBytes), size)
+	response.Content = Content{
+		Size:     size,
+		MimeType: e.ResponseHeader.Get("Content-Type"),
+		Text:     text,
+		Encoding: encoding,
+		Comment:  comment,
+	}
+	return response
+}
+
+// body returns the text of a body whose first bytes are given, binary bodies
+// are base64 encoded and only text bodies are redacted since patterns can not
+// match encoded bytes
+func (r *Recorder) body(body []byte, size int64) (text, encoding, comment string) {
+	truncated := size > int64(r.cfg.MaxBodyBytes)
+	if truncated {
+		comment = fmt.Sprintf("truncated to %d of %d bytes", r.cfg.MaxBodyBytes, size)
+		body = redact.TrimCutRune(body)
+	}
+	if !utf8.Valid(body) {
+		return base64.StdEncoding.EncodeToString(body[:min(len(body), r.cfg.MaxBodyBytes)]), "base64", comment
+	}
+	return r.redactor.TruncatedBody(body, size, r.cfg.MaxBodyBytes), "", comment
+}
+
+func (r *Recorder) headers(header http.Header) []NameValue {
+	headers := []NameValue{}
+	for name, values := range r.redactor.Header(header) {
+		for _, value := range values {
+			headers = append(headers, NameValue{Name: name, Value: value})
+		}
+	}
+	sortNameValues(headers)
+	return headers
+}
+
+func (r *Recorder) cookies(headerName string, cookies []*http.Cookie) []NameValue {
+	redacted := r.redactor.IsSensitiveHeader(headerName)
+	nameValues := make([]NameValue, 0, len(cookies))
+	for _, cookie := range cookies {
+		value := cookie.Value
+		if redacted {
+			value = redact.REDACTED
+		}
+		nameValues = append(nameValues, NameValue{Name: cookie.Name, Value: value})
+	}
+	return nameValues
+}
+
+func sortNameValues(nameValues []NameValue) {
+	sort.SliceStable(nameValues, func(i, j int) bool {
+		return nameValues[i].Name < nameValues[j].Name
+	})
+}
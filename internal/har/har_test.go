@@ -0,0 +1,89 @@
+package har
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+func TestTimings(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	timing := &Timing{
+		dnsStart: at(1), dnsDone: at(3),
+		connectStart: at(3), connectDone: at(5),
+		tlsStart: at(5), tlsDone: at(9),
+		gotConn: at(10), wroteRequest: at(11), gotFirstResponseByte: at(31),
+	}
+	got := timing.Timings(start, at(35))
+	expected := Timings{Blocked: 2, DNS: 2, Connect: 6, SSL: 4, Send: 1, Wait: 20, Receive: 4}
+	if got != expected {
+		t.Errorf("timings = %+v, want %+v", got, expected)
+	}
+
+	got = (&Timing{}).Timings(start, at(7))
+	if got.Blocked != 7 || got.DNS != -1 || got.Connect != -1 || got.Wait != 0 {
+		t.Errorf("timings without upstream = %+v", got)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := New(Config{Dir: dir, MaxBodyBytes: 8, MaxSizeMB: 1, Creator: Creator{Name: "reverse-proxy"}}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest(http.MethodPost, "http://example.com/login?token=abc&page=2", nil)
+	r.Host = "example.com"
+	header := http.Header{"Authorization": {"Bearer abc"}, "Content-Type": {"application/json"}}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		recorder.Record(recorder.NewEntry(&Exchange{
+			Start:           start,
+			End:             start.Add(time.Millisecond),
+			Request:         r,
+			RequestHeader:   header,
+			RequestBody:     []byte(`{"user":"bob"}`),
+			RequestBodySize: 14,
+			Status:          http.StatusOK,
+			ResponseHeader:  http.Header{"Content-Type": {"image/png"}},
+			ResponseBody:    []byte{0x89, 'P', 'N', 'G'},
+			Timing:          &Timing{},
+		}))
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+FILE_EXTENSION))
+	if len(files) != 1 {
+		t.Fatalf("files = %v", files)
+	}
+	content, _ := os.ReadFile(files[0])
+	var file File
+	if err := json.Unmarshal(content, &file); err != nil {
+		t.Fatalf("invalid har file: %v\n%s", err, content)
+	}
+	if file.Log.Version != VERSION || len(file.Log.Entries) != 2 {
+		t.Fatalf("log = %+v", file.Log)
+	}
+	entry := file.Log.Entries[0]
+	if entry.Request.URL != "http://example.com/login?token=%5BREDACTED%5D&page=2" {
+		t.Errorf("url = %s", entry.Request.URL)
+	}
+	if strings.Contains(string(content), "Bearer abc") {
+		t.Error("authorization recorded")
+	}
+	if entry.Request.PostData.Text != `{"user":` || entry.Request.PostData.Comment == "" {
+		t.Errorf("post data = %+v", entry.Request.PostData)
+	}
+	if entry.Response.Content.Encoding != "base64" || entry.Response.Content.Size != 4 {
+		t.Errorf("content = %+v", entry.Response.Content)
+	}
+}
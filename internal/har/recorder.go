@@ -0,0 +1,237 @@
+package har
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+)
+
+const (
+	// FILE_EXTENSION is the extension of complete files, the file being
+	// written ends with PARTIAL_EXTENSION until it is rotated
+	FILE_EXTENSION    = ".har"
+	PARTIAL_EXTENSION = ".har.part"
+	filePrefix        = "traffic-"
+)
+
+// Config selects the recorded requests and the rotation of the files,
+// recording is disabled when Dir is empty
+type Config struct {
+	Dir string
+	// Routes restricts the recording to route names, every route when empty
+	Routes []string
+	// Statuses restricts the recording to status codes or classes, e.g. 5xx
+	Statuses []string
+	// MaxBodyBytes caps each recorded body, bodies are truncated past it
+	MaxBodyBytes int
+	// MaxSizeMB rotates the file once it grows past the size
+	MaxSizeMB int
+	// RotateInterval rotates the file periodically, disabled when 0
+	RotateInterval time.Duration
+	// MaxFiles removes the oldest complete files past the count, all are kept when 0
+	MaxFiles int
+	// Creator names the proxy in the files
+	Creator Creator
+}
+
+// Validate checks the statuses and limits
+func (c Config) Validate() error {
+	if _, err := utils.ParseStatusFilter(c.Statuses); err != nil {
+		return errors.Join(errors.New("invalid har statuses"), err)
+	}
+	if c.MaxBodyBytes <= 0 {
+		return fmt.Errorf("har max body size must be greater than 0, got %d", c.MaxBodyBytes)
+	}
+	if c.MaxSizeMB <= 0 {
+		return fmt.Errorf("har max file size must be greater than 0, got %d", c.MaxSizeMB)
+	}
+	if c.RotateInterval < 0 || c.MaxFiles < 0 {
+		return errors.New("har rotate interval and max files must not be negative")
+	}
+	return nil
+}
+
+// Recorder appends entries to HAR files, it is safe for concurrent use.
+// Entries are streamed, the closing brackets are written on rotation.
+type Recorder struct {
+	cfg      Config
+	routes   map[string]bool
+	statuses utils.StatusFilter
+	redactor *redact.Redactor
+
+	mu      sync.Mutex
+	file    *os.File
+	path    string
+	size    int64
+	entries int
+	stop    chan struct{}
+}
+
+// New creates the directory and opens the first file, the redactor masks
+// the headers, cookies, query and bodies of every entry
+func New(cfg Config, redactor *redact.Redactor) (*Recorder, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, errors.Join(errors.New("failed to create the har directory"), err)
+	}
+	statuses, _ := utils.ParseStatusFilter(cfg.Statuses)
+	r := &Recorder{cfg: cfg, statuses: statuses, redactor: redactor, stop: make(chan struct{})}
+	if len(cfg.Routes) > 0 {
+		r.routes = make(map[string]bool, len(cfg.Routes))
+		for _, name := range cfg.Routes {
+			r.routes[name] = true
+		}
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if cfg.RotateInterval > 0 {
+		go r.rotateEvery(cfg.RotateInterval)
+	}
+	return r, nil
+}
+
+// MatchesRoute reports whether requests of the route are recorded
+func (r *Recorder) MatchesRoute(name string) bool {
+	return r.routes == nil || r.routes[name]
+}
+
+// MatchesStatus reports whether responses of the status are recorded
+func (r *Recorder) MatchesStatus(status int) bool {
+	return len(r.statuses) == 0 || r.statuses.Matches(status)
+}
+
+// Record appends an entry to the current file
+func (r *Recorder) Record(entry *Entry) {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode har entry: %v\n", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	if r.entries > 0 && r.size+int64(len(encoded)) > int64(r.cfg.MaxSizeMB)*1024*1024 {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate har file: %v\n", err)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	if r.entries > 0 {
+		buf.WriteByte(',')
+	}
+	buf.WriteString("\n")
+	buf.Write(encoded)
+	n, err := r.file.Write(buf.Bytes())
+	r.size += int64(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write har entry: %v\n", err)
+		return
+	}
+	r.entries++
+}
+
+func (r *Recorder) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			// an empty file is kept until it holds an entry
+			var err error
+			if r.entries > 0 {
+				err = r.rotate()
+			}
+			r.mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to rotate har file: %v\n", err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// open starts a new partial file, it must be called with the lock held
+func (r *Recorder) open() error {
+	name := filePrefix + time.Now().UTC().Format("20060102T150405.000Z") + PARTIAL_EXTENSION
+	path := filepath.Join(r.cfg.Dir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return errors.Join(errors.New("failed to create the har file"), err)
+	}
+	creator, _ := json.Marshal(r.cfg.Creator)
+	header := fmt.Sprintf(`{"log":{"version":%q,"creator":%s,"entries":[`, VERSION, creator)
+	n, err := file.WriteString(header)
+	if err != nil {
+		file.Close()
+		return errors.Join(errors.New("failed to write the har file"), err)
+	}
+	r.file, r.path, r.size, r.entries = file, path, int64(n), 0
+	return nil
+}
+
+// finish closes the current file and renames it to a complete HAR file, it
+// must be called with the lock held
+func (r *Recorder) finish() error {
+	if r.file == nil {
+		return nil
+	}
+	_, err := r.file.WriteString("\n]}}\n")
+	err = errors.Join(err, r.file.Close())
+	r.file = nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(r.path, strings.TrimSuffix(r.path, PARTIAL_EXTENSION)+FILE_EXTENSION)
+}
+
+// rotate must be called with the lock held
+func (r *Recorder) rotate() error {
+	if err := r.finish(); err != nil {
+		return err
+	}
+	r.removeOldFiles()
+	return r.open()
+}
+
+// removeOldFiles keeps the MaxFiles newest complete files, the names sort by time
+func (r *Recorder) removeOldFiles() {
+	if r.cfg.MaxFiles == 0 {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(r.cfg.Dir, filePrefix+"*"+FILE_EXTENSION))
+	if err != nil || len(files) <= r.cfg.MaxFiles {
+		return
+	}
+	sort.Strings(files)
+	for _, file := range files[:len(files)-r.cfg.MaxFiles] {
+		if err := os.Remove(file); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove har file: %v\n", err)
+		}
+	}
+}
+
+// Close completes the current file
+func (r *Recorder) Close() error {
+	close(r.stop)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finish()
+}
@@ -0,0 +1,114 @@
+package har
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing collects the phases of the upstream call through httptrace, the
+// hooks may run on the goroutines of the transport
+type Timing struct {
+	mu                   sync.Mutex
+	dnsStart, dnsDone    time.Time
+	connectStart         time.Time
+	connectDone          time.Time
+	tlsStart, tlsDone    time.Time
+	gotConn              time.Time
+	wroteRequest         time.Time
+	gotFirstResponseByte time.Time
+	serverAddress        string
+}
+
+// ClientTrace returns the hooks filling the timing
+func (t *Timing) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart:      func(string, string) { t.setOnce(&t.connectStart) },
+		ConnectDone:       func(string, string, error) { t.set(&t.connectDone) },
+		TLSHandshakeStart: func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.set(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.gotConn = time.Now()
+			if info.Conn != nil {
+				t.serverAddress = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wroteRequest) },
+		GotFirstResponseByte: func() { t.set(&t.gotFirstResponseByte) },
+	}
+}
+
+func (t *Timing) set(field *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*field = time.Now()
+}
+
+// setOnce keeps the first attempt when several addresses are dialed
+func (t *Timing) setOnce(field *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if field.IsZero() {
+		*field = time.Now()
+	}
+}
+
+// Timings splits the time between start and end into the HAR phases, blocked
+// holds the time spent in the proxy before the connection was acquired
+func (t *Timing) Timings(start, end time.Time) Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timings := Timings{DNS: -1, Connect: -1, SSL: -1}
+	if t.gotConn.IsZero() {
+		// the upstream was never reached
+		timings.Blocked = milliseconds(end.Sub(start))
+		return timings
+	}
+	blocked := t.gotConn.Sub(start)
+	if !t.dnsStart.IsZero() && !t.dnsDone.IsZero() {
+		timings.DNS = milliseconds(t.dnsDone.Sub(t.dnsStart))
+		blocked -= t.dnsDone.Sub(t.dnsStart)
+	}
+	if !t.connectStart.IsZero() && !t.connectDone.IsZero() {
+		// connect includes the TLS handshake as required by HAR
+		connectEnd := t.connectDone
+		if !t.tlsDone.IsZero() {
+			connectEnd = t.tlsDone
+		}
+		timings.Connect = milliseconds(connectEnd.Sub(t.connectStart))
+		blocked -= connectEnd.Sub(t.connectStart)
+	}
+	if !t.tlsStart.IsZero() && !t.tlsDone.IsZero() {
+		timings.SSL = milliseconds(t.tlsDone.Sub(t.tlsStart))
+	}
+	timings.Blocked = milliseconds(max(blocked, 0))
+	wroteRequest := latest(t.wroteRequest, t.gotConn)
+	firstByte := latest(t.gotFirstResponseByte, wroteRequest)
+	timings.Send = milliseconds(wroteRequest.Sub(t.gotConn))
+	timings.Wait = milliseconds(firstByte.Sub(wroteRequest))
+	timings.Receive = milliseconds(max(end.Sub(firstByte), 0))
+	return timings
+}
+
+// ServerAddress is the remote address of the upstream connection
+func (t *Timing) ServerAddress() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.serverAddress
+}
+
+func latest(t, fallback time.Time) time.Time {
+	if t.IsZero() || t.Before(fallback) {
+		return fallback
+	}
+	return t
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
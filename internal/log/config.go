@@ -0,0 +1,114 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	ENCODING_JSON    = "json"
+	ENCODING_CONSOLE = "console"
+)
+
+// Config holds the level, encoding and destinations of the logger
+type Config struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Levels are levels per logger name, e.g. middleware=debug
+	Levels   map[string]string
+	Encoding string
+	// OutputPaths are stdout, stderr or file paths
+	OutputPaths      []string
+	ErrorOutputPaths []string
+	// SamplingInitial entries of a message are logged each second, then every
+	// SamplingThereafter-th one, sampling is disabled when 0
+	SamplingInitial    int
+	SamplingThereafter int
+	// Caller adds the file and line of the log call
+	Caller bool
+}
+
+// DefaultConfig is the configuration of the logger until Configure is called
+func DefaultConfig() Config {
+	return Config{
+		Level:            zapcore.DebugLevel.String(),
+		Encoding:         ENCODING_JSON,
+		OutputPaths:      []string{"stdout"},
+		ErrorOutputPaths: []string{"stderr"},
+	}
+}
+
+// Validate checks the levels, encoding and sampling
+func (c Config) Validate() error {
+	if _, err := ParseLevel(c.Level); err != nil {
+		return err
+	}
+	for name, level := range c.Levels {
+		if _, err := ParseLevel(level); err != nil {
+			return errors.Join(fmt.Errorf("invalid level of logger %q", name), err)
+		}
+	}
+	if c.Encoding != ENCODING_JSON && c.Encoding != ENCODING_CONSOLE {
+		return fmt.Errorf("unknown log encoding %q, expected json or console", c.Encoding)
+	}
+	if len(c.OutputPaths) == 0 {
+		return errors.New("at least one log output path is required")
+	}
+	if c.SamplingInitial < 0 || c.SamplingThereafter < 0 {
+		return errors.New("log sampling must not be negative")
+	}
+	return nil
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(text string) (zapcore.Level, error) {
+	level, err := zapcore.ParseLevel(text)
+	if err != nil || level > zapcore.ErrorLevel {
+		return level, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", text)
+	}
+	return level, nil
+}
+
+// Configure rebuilds the logger, loggers taken before keep the previous outputs
+func Configure(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	l, err := build(cfg)
+	if err != nil {
+		return errors.Join(errors.New("failed to build the logger"), err)
+	}
+	level, _ := ParseLevel(cfg.Level)
+	levels.configure(level, cfg.Levels)
+	setLogger(l)
+	return nil
+}
+
+func build(cfg Config) (*zap.Logger, error) {
+	encoderCfg := getEncoderConfig()
+	if cfg.Encoding == ENCODING_CONSOLE {
+		encoderCfg.LevelKey = "level"
+		encoderCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+	}
+	zapCfg := zap.Config{
+		// the levels are checked per logger name by the level core
+		Level:             zap.NewAtomicLevelAt(zapcore.DebugLevel),
+		Development:       false,
+		DisableCaller:     !cfg.Caller,
+		DisableStacktrace: true,
+		Encoding:          cfg.Encoding,
+		EncoderConfig:     encoderCfg,
+		OutputPaths:       cfg.OutputPaths,
+		ErrorOutputPaths:  cfg.ErrorOutputPaths,
+	}
+	if cfg.SamplingInitial > 0 {
+		zapCfg.Sampling = &zap.SamplingConfig{Initial: cfg.SamplingInitial, Thereafter: cfg.SamplingThereafter}
+	}
+	return zapCfg.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		// the level core is outermost so that it sees the logger name first
+		return newLevelCore(newRedactingCore(core))
+	}))
+}
@@ -0,0 +1,42 @@
+package log
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// WithFields returns a copy of ctx whose logger adds the fields to every entry
+func WithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	l, ok := ctx.Value(loggerKey{}).(*zap.Logger)
+	if !ok {
+		l = logger
+	}
+	// the trace fields are added when logging, the span may change in between
+	return context.WithValue(ctx, loggerKey{}, l.With(fields...))
+}
+
+// FromContext returns the logger of the request, or the global logger, with
+// the trace fields of the span found in ctx
+func FromContext(ctx context.Context) *zap.Logger {
+	l, ok := ctx.Value(loggerKey{}).(*zap.Logger)
+	if !ok {
+		l = logger
+	}
+	if fields := TraceFields(ctx); len(fields) > 0 {
+		return l.With(fields...)
+	}
+	return l
+}
+
+// WithLoggerOf returns a copy of ctx holding the logger of the request of from,
+// without the other values of from
+func WithLoggerOf(ctx, from context.Context) context.Context {
+	l, ok := from.Value(loggerKey{}).(*zap.Logger)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, loggerKey{}, l)
+}
@@ -0,0 +1,240 @@
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// ROOT_LOGGER names the level of every logger without a level of its own
+const ROOT_LOGGER = "root"
+
+// LevelOverride is a level set at runtime
+type LevelOverride struct {
+	Logger string `json:"logger"`
+	Level  string `json:"level"`
+	// ExpiresAt is when the level reverts, it is kept until reset when nil
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LevelState describes the configured levels and the runtime overrides
+type LevelState struct {
+	Level     string            `json:"level"`
+	Levels    map[string]string `json:"levels"`
+	Overrides []LevelOverride   `json:"overrides"`
+}
+
+type override struct {
+	level     zapcore.Level
+	expiresAt time.Time
+	timer     timer
+}
+
+type timer interface {
+	Stop() bool
+}
+
+// clock schedules the revert of the overrides with a ttl, tests replace it
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
+
+// levelRegistry resolves the level of a logger name: a runtime override, then
+// a configured level, of the name or of its closest parent, then the root level
+type levelRegistry struct {
+	mu         sync.RWMutex
+	clock      clock
+	root       zapcore.Level
+	configured map[string]zapcore.Level
+	overrides  map[string]*override
+	// minimum is the lowest level of all, it lets disabled entries skip the lookup
+	minimum atomic.Int32
+}
+
+var levels = newLevelRegistry(zapcore.DebugLevel)
+
+func newLevelRegistry(root zapcore.Level) *levelRegistry {
+	r := &levelRegistry{
+		clock:      systemClock{},
+		root:       root,
+		configured: make(map[string]zapcore.Level),
+		overrides:  make(map[string]*override),
+	}
+	r.minimum.Store(int32(root))
+	return r
+}
+
+func (r *levelRegistry) configure(root zapcore.Level, configured map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.root = root
+	r.configured = make(map[string]zapcore.Level, len(configured))
+	for name, text := range configured {
+		level, _ := ParseLevel(text)
+		r.configured[name] = level
+	}
+	r.updateMinimum()
+}
+
+// updateMinimum must be called with the lock held
+func (r *levelRegistry) updateMinimum() {
+	minimum := r.root
+	for _, level := range r.configured {
+		minimum = min(minimum, level)
+	}
+	for _, o := range r.overrides {
+		minimum = min(minimum, o.level)
+	}
+	r.minimum.Store(int32(minimum))
+}
+
+func (r *levelRegistry) lowest() zapcore.Level {
+	return zapcore.Level(r.minimum.Load())
+}
+
+func (r *levelRegistry) enabled(name string, level zapcore.Level) bool {
+	if level < r.lowest() {
+		return false
+	}
+	return level >= r.level(name)
+}
+
+func (r *levelRegistry) level(name string) zapcore.Level {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name != "" {
+		if o, ok := r.overrides[name]; ok {
+			return o.level
+		}
+		if level, ok := r.configured[name]; ok {
+			return level
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	if o, ok := r.overrides[ROOT_LOGGER]; ok {
+		return o.level
+	}
+	return r.root
+}
+
+func (r *levelRegistry) set(name string, level zapcore.Level, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stop(name)
+	o := &override{level: level}
+	if ttl > 0 {
+		o.expiresAt = r.clock.Now().Add(ttl)
+		o.timer = r.clock.AfterFunc(ttl, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			// a later override replaced this one
+			if r.overrides[name] == o {
+				delete(r.overrides, name)
+				r.updateMinimum()
+			}
+		})
+	}
+	r.overrides[name] = o
+	r.updateMinimum()
+}
+
+func (r *levelRegistry) reset(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.overrides[name]
+	r.stop(name)
+	delete(r.overrides, name)
+	r.updateMinimum()
+	return ok
+}
+
+// stop must be called with the lock held
+func (r *levelRegistry) stop(name string) {
+	if o, ok := r.overrides[name]; ok && o.timer != nil {
+		o.timer.Stop()
+	}
+}
+
+func (r *levelRegistry) state() LevelState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	state := LevelState{
+		Level:     r.root.String(),
+		Levels:    make(map[string]string, len(r.configured)),
+		Overrides: make([]LevelOverride, 0, len(r.overrides)),
+	}
+	for name, level := range r.configured {
+		state.Levels[name] = level.String()
+	}
+	for name, o := range r.overrides {
+		override := LevelOverride{Logger: name, Level: o.level.String()}
+		if !o.expiresAt.IsZero() {
+			expiresAt := o.expiresAt
+			override.ExpiresAt = &expiresAt
+		}
+		state.Overrides = append(state.Overrides, override)
+	}
+	sort.Slice(state.Overrides, func(i, j int) bool {
+		return state.Overrides[i].Logger < state.Overrides[j].Logger
+	})
+	return state
+}
+
+// SetLevel overrides the level of a logger name and its children, or of
+// every logger with ROOT_LOGGER, the override reverts after ttl when greater than 0
+func SetLevel(name string, level zapcore.Level, ttl time.Duration) {
+	levels.set(name, level, ttl)
+}
+
+// ResetLevel removes the override of a logger name, it reports whether there was one
+func ResetLevel(name string) bool {
+	return levels.reset(name)
+}
+
+// Levels returns the configured levels and the runtime overrides
+func Levels() LevelState {
+	return levels.state()
+}
+
+// levelCore filters entries with the level of their logger name
+type levelCore struct {
+	zapcore.Core
+}
+
+func newLevelCore(core zapcore.Core) zapcore.Core {
+	return &levelCore{Core: core}
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return level >= levels.lowest()
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields)}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if levels.enabled(entry.LoggerName, entry.Level) {
+		return c.Core.Check(entry, checked)
+	}
+	return checked
+}
@@ -1,38 +1,49 @@
 package log
 
 import (
-	"net/http"
-	"time"
-
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-var logger *zap.Logger
+var (
+	logger *zap.Logger
+	// funcLogger skips the frame of the package functions when reporting the caller
+	funcLogger *zap.Logger
+)
 
 func init() {
-	encoderCfg := getEncoderConfig()
-	logger, _ = getConfig(encoderCfg).Build()
+	l, _ := build(DefaultConfig())
+	setLogger(l)
+}
+
+func setLogger(l *zap.Logger) {
+	logger = l
+	funcLogger = l.WithOptions(zap.AddCallerSkip(1))
+}
+
+// Named returns a logger whose level can be changed on its own, see SetLevel
+func Named(name string) *zap.Logger {
+	return logger.Named(name)
 }
 
 func Info(msg string, fields ...zap.Field) {
-	logger.Info(msg, fields...)
+	funcLogger.Info(msg, fields...)
 }
 
 func Error(msg string, fields ...zap.Field) {
-	logger.Error(msg, fields...)
+	funcLogger.Error(msg, fields...)
 }
 
 func Errorf(err error, fields ...zap.Field) {
-	logger.Error(err.Error(), fields...)
+	funcLogger.Error(err.Error(), fields...)
 }
 
 func Debug(msg string, fields ...zap.Field) {
-	logger.Debug(msg, fields...)
+	funcLogger.Debug(msg, fields...)
 }
 
 func Warn(msg string, fields ...zap.Field) {
-	logger.Warn(msg, fields...)
+	funcLogger.Warn(msg, fields...)
 }
 
 func getEncoderConfig() zapcore.EncoderConfig {
@@ -43,37 +54,3 @@ func getEncoderConfig() zapcore.EncoderConfig {
 	encoderCfg.LevelKey = zapcore.OmitKey
 	return encoderCfg
 }
-
-func getConfig(encoderConfig zapcore.EncoderConfig) zap.Config {
-	return zap.Config{
-		Level:             zap.NewAtomicLevelAt(zap.DebugLevel),
-		Development:       false,
-		DisableCaller:     true,
-		DisableStacktrace: true,
-		Sampling:          nil,
-		Encoding:          "json",
-		EncoderConfig:     encoderConfig,
-		OutputPaths: []string{
-			"stdout",
-		},
-		ErrorOutputPaths: []string{
-			"stderr",
-		},
-	}
-}
-
-func GetLoggingFieldsByRequest(r *http.Request, statusCode int, duration time.Duration) []zap.Field {
-	var query string = ""
-	if r.URL.RawQuery != "" {
-		query = "?" + r.URL.RawQuery
-	}
-	loggingDefault := []zap.Field{
-		zap.Int("status_code", statusCode),
-		zap.String("remote_host", r.RemoteAddr),
-		zap.String("method", r.Method),
-		zap.String("path", r.URL.Path+query),
-		zap.Duration("response_time", duration),
-	}
-
-	return loggingDefault
-}
@@ -1,6 +1,20 @@
 package log
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
 
 func TestInfo(t *testing.T) {
 	Info("This is an info message")
@@ -17,3 +31,155 @@ func TestDebug(t *testing.T) {
 func TestWarn(t *testing.T) {
 	Warn("This is a warning message")
 }
+
+func TestTraceFields(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	spanID, _ := trace.SpanIDFromHex("b7ad6b7169203331")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	fields := TraceFields(ctx)
+	if len(fields) != 3 || fields[0].Key != "trace_id" || fields[0].String != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("otel fields = %v", fields)
+	}
+
+	SetTraceFields(TRACE_FIELDS_DATADOG)
+	defer SetTraceFields(TRACE_FIELDS_OTEL)
+	fields = TraceFields(ctx)
+	// 0x8448eb211c80319c and 0xb7ad6b7169203331 as decimals
+	if fields[0].Key != "dd.trace_id" || fields[0].String != "9532127138774266268" {
+		t.Errorf("datadog trace id = %v", fields[0])
+	}
+	if fields[1].Key != "dd.span_id" || fields[1].String != "13235353014750950193" {
+		t.Errorf("datadog span id = %v", fields[1])
+	}
+
+	if fields := TraceFields(context.Background()); fields != nil {
+		t.Errorf("fields without span = %v", fields)
+	}
+}
+
+func TestCredentialsNeverReachStdout(t *testing.T) {
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = writer
+	l, _ := build(DefaultConfig())
+	os.Stdout = stdout
+
+	header := http.Header{
+		"Authorization": {"Bearer secret-token"},
+		"Cookie":        {"session=secret-cookie"},
+	}
+	l.Info("Get header", zap.Any("Header", header))
+	l.Info("request", zap.String("Authorization", "Basic secret-basic"), zap.String("path", "/login?access_token=secret-query"))
+	l.With(zap.String("token", "Bearer secret-with")).Error("failed with Bearer secret-message")
+	l.Info("failed", zap.Error(errors.New("upstream rejected Bearer secret-error")))
+	target, _ := url.Parse("http://upstream/callback?code=secret-stringer")
+	l.Info("redirect", zap.Stringer("url", target), zap.Strings("tokens", []string{"Bearer secret-strings"}))
+	l.Info("login", zap.Any("body", struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+		ID       int64  `json:"id"`
+	}{"bob", "secret-struct", 1844674407370955165}))
+	writer.Close()
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "Get header") {
+		t.Fatalf("nothing logged: %s", output)
+	}
+	if !strings.Contains(string(output), `"user":"bob"`) || !strings.Contains(string(output), "1844674407370955165") {
+		t.Errorf("struct fields were redacted: %s", output)
+	}
+	for _, secret := range []string{"secret-token", "secret-cookie", "secret-basic", "secret-query", "secret-with", "secret-message", "secret-error", "secret-stringer", "secret-strings", "secret-struct"} {
+		if strings.Contains(string(output), secret) {
+			t.Errorf("%s reached stdout: %s", secret, output)
+		}
+	}
+}
+
+// manualClock fires the scheduled functions on advance
+type manualClock struct {
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *manualTimer) Stop() bool {
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) timer {
+	t := &manualTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if !t.stopped && !t.at.After(c.now) {
+			t.stopped = true
+			t.f()
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	clock := &manualClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r := newLevelRegistry(zapcore.InfoLevel)
+	r.clock = clock
+	r.configure(zapcore.InfoLevel, map[string]string{"middleware": "warn"})
+	if r.enabled("", zapcore.DebugLevel) || !r.enabled("", zapcore.InfoLevel) {
+		t.Error("root level ignored")
+	}
+	if r.enabled("middleware.openapi", zapcore.InfoLevel) {
+		t.Error("configured level of the parent ignored")
+	}
+
+	r.set("middleware", zapcore.DebugLevel, 0)
+	if !r.enabled("middleware.openapi", zapcore.DebugLevel) {
+		t.Error("override ignored")
+	}
+	if r.enabled("controller", zapcore.DebugLevel) {
+		t.Error("override applied to another logger")
+	}
+	if !r.reset("middleware") || r.enabled("middleware", zapcore.InfoLevel) {
+		t.Error("override not reset")
+	}
+
+	r.set(ROOT_LOGGER, zapcore.DebugLevel, time.Minute)
+	if !r.enabled("controller", zapcore.DebugLevel) {
+		t.Error("root override ignored")
+	}
+	if expiresAt := r.state().Overrides[0].ExpiresAt; expiresAt == nil || !expiresAt.Equal(clock.now.Add(time.Minute)) {
+		t.Errorf("root override expires at %v", expiresAt)
+	}
+	clock.advance(59 * time.Second)
+	if !r.enabled("controller", zapcore.DebugLevel) {
+		t.Error("root override reverted before its ttl")
+	}
+	clock.advance(time.Second)
+	if r.enabled("controller", zapcore.DebugLevel) || r.lowest() != zapcore.InfoLevel {
+		t.Errorf("root override not reverted, lowest level %s", r.lowest())
+	}
+}
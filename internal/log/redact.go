@@ -0,0 +1,138 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var redactor atomic.Pointer[redact.Redactor]
+
+func init() {
+	redactor.Store(redact.Default())
+}
+
+// SetRedactor replaces the redactor applied to every log entry
+func SetRedactor(r *redact.Redactor) {
+	redactor.Store(r)
+}
+
+// Redactor returns the redactor applied to every log entry
+func Redactor() *redact.Redactor {
+	return redactor.Load()
+}
+
+// redactingCore redacts the message and fields of every entry before they
+// are encoded, so that a field logged by mistake can not leak credentials
+type redactingCore struct {
+	zapcore.Core
+}
+
+func newRedactingCore(core zapcore.Core) zapcore.Core {
+	return &redactingCore{Core: core}
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redactor().String(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	r := Redactor()
+	if r == nil {
+		return fields
+	}
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = redactField(r, field)
+	}
+	return redacted
+}
+
+// unredactedKeys hold generated ids, long decimal ids could pass the card number check
+var unredactedKeys = map[string]bool{
+	"trace_id": true, "span_id": true, "dd.trace_id": true, "dd.span_id": true, "request_id": true,
+}
+
+// redactField redacts the string, error, stringer, array, object and
+// reflected fields. Arrays, objects and reflected values are encoded as the
+// JSON encoder would, their fields named after a sensitive param or header are
+// masked. Numbers, booleans, times and durations are left as is.
+func redactField(r *redact.Redactor, field zapcore.Field) zapcore.Field {
+	if unredactedKeys[field.Key] {
+		return field
+	}
+	switch field.Type {
+	case zapcore.StringType:
+		if r.IsDeniedHeader(field.Key) {
+			return zap.String(field.Key, redact.REDACTED)
+		}
+		return zap.String(field.Key, r.URL(r.String(field.String)))
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok {
+			return zap.String(field.Key, r.String(err.Error()))
+		}
+	case zapcore.StringerType:
+		if r.IsDeniedHeader(field.Key) {
+			return zap.String(field.Key, redact.REDACTED)
+		}
+		if text, ok := stringOf(field.Interface); ok {
+			return zap.String(field.Key, r.URL(r.String(text)))
+		}
+	case zapcore.ReflectType:
+		switch value := field.Interface.(type) {
+		case http.Header:
+			return zap.Any(field.Key, r.Header(value))
+		case map[string]string:
+			return zap.Any(field.Key, r.Params(value))
+		}
+		data, err := json.Marshal(field.Interface)
+		if err != nil {
+			return field
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		// large integers such as ids keep their digits
+		decoder.UseNumber()
+		var decoded any
+		if err := decoder.Decode(&decoded); err != nil {
+			return field
+		}
+		return zap.Any(field.Key, r.Value(decoded))
+	case zapcore.ArrayMarshalerType, zapcore.ObjectMarshalerType:
+		enc := zapcore.NewMapObjectEncoder()
+		field.AddTo(enc)
+		return zap.Any(field.Key, r.Value(enc.Fields[field.Key]))
+	}
+	return field
+}
+
+// stringOf calls String, ok is false when it panics, e.g. on a nil pointer
+func stringOf(value any) (text string, ok bool) {
+	stringer, isStringer := value.(fmt.Stringer)
+	if !isStringer {
+		return "", false
+	}
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return stringer.String(), true
+}
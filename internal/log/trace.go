@@ -0,0 +1,65 @@
+package log
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// TRACE_FIELDS_OTEL names the fields trace_id, span_id and sampled with hex ids
+	TRACE_FIELDS_OTEL = "otel"
+	// TRACE_FIELDS_DATADOG names the fields dd.trace_id, dd.span_id and dd.sampled
+	// with the decimal ids the Datadog log pipeline correlates on
+	TRACE_FIELDS_DATADOG = "datadog"
+)
+
+var traceFieldsStyle atomic.Value
+
+func init() {
+	traceFieldsStyle.Store(TRACE_FIELDS_OTEL)
+}
+
+// ValidateTraceFields checks the naming of the trace fields
+func ValidateTraceFields(style string) error {
+	switch style {
+	case "", TRACE_FIELDS_OTEL, TRACE_FIELDS_DATADOG:
+		return nil
+	}
+	return fmt.Errorf("unknown log trace fields %q, expected one of otel, datadog", style)
+}
+
+// SetTraceFields switches the naming of the trace fields added by FromContext
+func SetTraceFields(style string) {
+	if style == "" {
+		style = TRACE_FIELDS_OTEL
+	}
+	traceFieldsStyle.Store(style)
+}
+
+// TraceFields returns the fields of the span context of ctx, if any
+func TraceFields(ctx context.Context) []zap.Field {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	traceID, spanID := spanContext.TraceID(), spanContext.SpanID()
+	if traceFieldsStyle.Load() == TRACE_FIELDS_DATADOG {
+		// Datadog ids are the lower 64 bits of the trace id and the span id as decimals
+		return []zap.Field{
+			zap.String("dd.trace_id", strconv.FormatUint(binary.BigEndian.Uint64(traceID[8:]), 10)),
+			zap.String("dd.span_id", strconv.FormatUint(binary.BigEndian.Uint64(spanID[:]), 10)),
+			zap.Bool("dd.sampled", spanContext.IsSampled()),
+		}
+	}
+	return []zap.Field{
+		zap.String("trace_id", traceID.String()),
+		zap.String("span_id", spanID.String()),
+		zap.Bool("sampled", spanContext.IsSampled()),
+	}
+}
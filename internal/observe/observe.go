@@ -9,31 +9,34 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 
-	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 const OBSERVCE_MODE_OTEL = "otel"
 
-func Register(applicationName, serviceName, serviceVersion, mode string) error {
+func Register(applicationName, serviceName, serviceVersion, mode string, propagationConfig PropagationConfig) error {
 	res, err := newOtlpResource(applicationName, serviceName, serviceVersion)
 	if err != nil {
 		return errors.Join(errors.New("failed to create observability provider: "), err)
 	}
 
 	if mode == "otel" {
-		tracerProvider, err := newTracerProvider(res)
+		tracerProvider, processor, err := newTracerProvider(res)
 		if err != nil {
 			return errors.Join(errors.New("failed to create observability provider: "), err)
 		}
 		otel.SetTracerProvider(tracerProvider)
+		providers.register(serviceName, serviceVersion, processor)
 	}
 
-	propagator := propagation.NewCompositeTextMapPropagator(propagation.Baggage{}, propagation.TraceContext{})
+	propagator, err := NewPropagator(propagationConfig)
+	if err != nil {
+		return errors.Join(errors.New("failed to create propagator: "), err)
+	}
 	otel.SetTextMapPropagator(propagator)
 	return nil
 }
@@ -61,17 +64,20 @@ func newMetricProvider(res *resource.Resource) (*metric.MeterProvider, error) {
 	return metricProvider, nil
 }
 
-func newTracerProvider(res *resource.Resource) (*trace.TracerProvider, error) {
+// newTracerProvider returns the provider and its span processor, which the
+// providers of the virtual hosts reuse
+func newTracerProvider(res *resource.Resource) (*trace.TracerProvider, trace.SpanProcessor, error) {
 	ctx := context.Background()
 	exp, err := otlptracegrpc.New(ctx)
 	if err != nil {
-		return nil, errors.Join(errors.New("failed to create trace exporter"), err)
+		return nil, nil, errors.Join(errors.New("failed to create trace exporter"), err)
 	}
 	// Use the exporter
+	processor := trace.NewBatchSpanProcessor(exp)
 	traceProvider := trace.NewTracerProvider(
 		trace.WithResource(res),
-		trace.WithBatcher(exp),
+		trace.WithSpanProcessor(processor),
 		trace.WithSampler(DemoSampler{}),
 	)
-	return traceProvider, nil
+	return traceProvider, processor, nil
 }
@@ -0,0 +1,142 @@
+package observe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	PROPAGATOR_TRACECONTEXT = "tracecontext"
+	PROPAGATOR_BAGGAGE      = "baggage"
+	PROPAGATOR_B3           = "b3"
+	PROPAGATOR_B3_MULTI     = "b3multi"
+	PROPAGATOR_JAEGER       = "jaeger"
+)
+
+const (
+	// INCOMING_TRACE_ACCEPT extracts trace context sent by the client
+	INCOMING_TRACE_ACCEPT = "accept"
+	// INCOMING_TRACE_IGNORE starts a new trace but leaves the client headers untouched
+	INCOMING_TRACE_IGNORE = "ignore"
+	// INCOMING_TRACE_STRIP starts a new trace and removes the client headers before proxying
+	INCOMING_TRACE_STRIP = "strip"
+)
+
+// PropagationConfig holds the propagators used to extract the incoming
+// trace context and to inject it toward the upstream.
+type PropagationConfig struct {
+	Extract       []string
+	Inject        []string
+	IncomingTrace string
+}
+
+// DefaultPropagators is the list used when no propagators are configured
+var DefaultPropagators = []string{PROPAGATOR_BAGGAGE, PROPAGATOR_TRACECONTEXT}
+
+// NewPropagator builds a propagator that extracts with the Extract list
+// and injects with the Inject list. An empty Inject list reuses Extract.
+func NewPropagator(cfg PropagationConfig) (propagation.TextMapPropagator, error) {
+	extractNames := cfg.Extract
+	if len(extractNames) == 0 {
+		extractNames = DefaultPropagators
+	}
+	injectNames := cfg.Inject
+	if len(injectNames) == 0 {
+		injectNames = extractNames
+	}
+	extract, err := newCompositePropagator(extractNames)
+	if err != nil {
+		return nil, err
+	}
+	inject, err := newCompositePropagator(injectNames)
+	if err != nil {
+		return nil, err
+	}
+	return splitPropagator{extract: extract, inject: inject}, nil
+}
+
+// ValidateIncomingTrace checks the incoming trace policy name
+func ValidateIncomingTrace(policy string) error {
+	switch policy {
+	case "", INCOMING_TRACE_ACCEPT, INCOMING_TRACE_IGNORE, INCOMING_TRACE_STRIP:
+		return nil
+	}
+	return fmt.Errorf("unknown incoming trace policy %q, expected one of accept, ignore, strip", policy)
+}
+
+func newCompositePropagator(names []string) (propagation.TextMapPropagator, error) {
+	propagators := make([]propagation.TextMapPropagator, 0, len(names))
+	for _, name := range names {
+		p, err := propagatorByName(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		propagators = append(propagators, p)
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+func propagatorByName(name string) (propagation.TextMapPropagator, error) {
+	switch strings.ToLower(name) {
+	case PROPAGATOR_TRACECONTEXT:
+		return propagation.TraceContext{}, nil
+	case PROPAGATOR_BAGGAGE:
+		return propagation.Baggage{}, nil
+	case PROPAGATOR_B3:
+		return b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)), nil
+	case PROPAGATOR_B3_MULTI:
+		return b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)), nil
+	case PROPAGATOR_JAEGER:
+		return jaeger.Jaeger{}, nil
+	}
+	return nil, fmt.Errorf("unknown propagator %q, expected one of tracecontext, baggage, b3, b3multi, jaeger", name)
+}
+
+// traceHeaderFields are the headers of every supported propagator, b3 in both
+// encodings, x-b3-parentspanid is not read by the b3 propagator but is by other hops
+var traceHeaderFields = append(append(append(append(
+	propagation.TraceContext{}.Fields(),
+	propagation.Baggage{}.Fields()...),
+	b3.New(b3.WithInjectEncoding(b3.B3SingleHeader|b3.B3MultipleHeader)).Fields()...),
+	"x-b3-parentspanid"),
+	jaeger.Jaeger{}.Fields()...)
+
+// TraceHeaderFields returns the headers of every supported propagator,
+// whatever propagators are configured, an untrusted client may send any of them
+func TraceHeaderFields() []string {
+	return traceHeaderFields
+}
+
+// splitPropagator uses different propagators for extracting and injecting
+type splitPropagator struct {
+	extract propagation.TextMapPropagator
+	inject  propagation.TextMapPropagator
+}
+
+func (s splitPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	s.inject.Inject(ctx, carrier)
+}
+
+func (s splitPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return s.extract.Extract(ctx, carrier)
+}
+
+// Fields returns the union of the extract and inject fields so that callers
+// stripping trace headers remove every format we know about.
+func (s splitPropagator) Fields() []string {
+	seen := make(map[string]struct{})
+	var fields []string
+	for _, f := range append(s.extract.Fields(), s.inject.Fields()...) {
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		fields = append(fields, f)
+	}
+	return fields
+}
@@ -0,0 +1,105 @@
+package observe
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var spanContext = trace.NewSpanContext(trace.SpanContextConfig{
+	TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceFlags: trace.FlagsSampled,
+})
+
+func inject(propagator propagation.TextMapPropagator) http.Header {
+	header := http.Header{}
+	propagator.Inject(trace.ContextWithSpanContext(context.Background(), spanContext), propagation.HeaderCarrier(header))
+	return header
+}
+
+func extract(propagator propagation.TextMapPropagator, header http.Header) trace.SpanContext {
+	return trace.SpanContextFromContext(propagator.Extract(context.Background(), propagation.HeaderCarrier(header)))
+}
+
+func TestPropagators(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{PROPAGATOR_TRACECONTEXT, "Traceparent"},
+		{PROPAGATOR_B3, "B3"},
+		{PROPAGATOR_B3_MULTI, "X-B3-Traceid"},
+		{PROPAGATOR_JAEGER, "Uber-Trace-Id"},
+	}
+	for _, tt := range tests {
+		propagator, err := NewPropagator(PropagationConfig{Extract: []string{tt.name}})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		header := inject(propagator)
+		if header.Get(tt.header) == "" {
+			t.Errorf("%s: %s not injected, got %v", tt.name, tt.header, header)
+			continue
+		}
+		if extracted := extract(propagator, header); !extracted.Equal(spanContext.WithRemote(true)) {
+			t.Errorf("%s: extracted %v", tt.name, extracted)
+		}
+	}
+
+	baggage, err := NewPropagator(PropagationConfig{Extract: []string{PROPAGATOR_BAGGAGE}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := baggage.Fields(); !slices.Equal(fields, []string{"baggage"}) {
+		t.Errorf("baggage fields = %v", fields)
+	}
+}
+
+func TestCompositePropagator(t *testing.T) {
+	propagator, err := NewPropagator(PropagationConfig{Extract: []string{"B3", " tracecontext "}, Inject: []string{PROPAGATOR_JAEGER}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := inject(propagator); header.Get("Uber-Trace-Id") == "" || header.Get("Traceparent") != "" || header.Get("B3") != "" {
+		t.Errorf("injected with the extract list: %v", header)
+	}
+	for _, name := range []string{PROPAGATOR_B3, PROPAGATOR_TRACECONTEXT} {
+		single, _ := NewPropagator(PropagationConfig{Extract: []string{name}})
+		if extracted := extract(propagator, inject(single)); extracted.TraceID() != spanContext.TraceID() {
+			t.Errorf("%s headers not extracted by the composite propagator", name)
+		}
+	}
+	fields := propagator.Fields()
+	for _, field := range []string{"b3", "traceparent", "uber-trace-id"} {
+		if !slices.Contains(fields, field) {
+			t.Errorf("fields %v lack %s", fields, field)
+		}
+	}
+
+	defaults, err := NewPropagator(PropagationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := defaults.Fields(); !slices.Contains(fields, "traceparent") || !slices.Contains(fields, "baggage") {
+		t.Errorf("default fields = %v", fields)
+	}
+}
+
+func TestUnknownPropagator(t *testing.T) {
+	for _, cfg := range []PropagationConfig{
+		{Extract: []string{"zipkin"}},
+		{Extract: []string{PROPAGATOR_B3}, Inject: []string{PROPAGATOR_TRACECONTEXT, "xray"}},
+	} {
+		if _, err := NewPropagator(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	if err := ValidateIncomingTrace("drop"); err == nil {
+		t.Error("unknown incoming trace policy accepted")
+	}
+}
@@ -0,0 +1,90 @@
+package observe
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+)
+
+const TRACER_NAME = "reverse-proxy"
+
+// applicationProviders creates one tracer provider per application so that
+// every virtual host reports spans with its own resource. The providers share
+// the span processor, hence the exporter, of the global provider.
+type applicationProviders struct {
+	mu sync.Mutex
+	// generation changes on every register, it invalidates the resolved tracers
+	generation     atomic.Uint64
+	serviceName    string
+	serviceVersion string
+	processor      trace.SpanProcessor
+	providers      map[string]*trace.TracerProvider
+}
+
+var providers = &applicationProviders{providers: make(map[string]*trace.TracerProvider)}
+
+func (p *applicationProviders) register(serviceName, serviceVersion string, processor trace.SpanProcessor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.serviceName = serviceName
+	p.serviceVersion = serviceVersion
+	p.processor = processor
+	p.providers = make(map[string]*trace.TracerProvider)
+	p.generation.Add(1)
+}
+
+func (p *applicationProviders) tracer(applicationName string) oteltrace.Tracer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.processor == nil {
+		return otel.GetTracerProvider().Tracer(TRACER_NAME)
+	}
+	provider, ok := p.providers[applicationName]
+	if !ok {
+		res, err := newOtlpResource(applicationName, p.serviceName, p.serviceVersion)
+		if err != nil {
+			return otel.GetTracerProvider().Tracer(TRACER_NAME)
+		}
+		provider = trace.NewTracerProvider(
+			trace.WithResource(res),
+			trace.WithSpanProcessor(p.processor),
+			trace.WithSampler(DemoSampler{}),
+		)
+		p.providers[applicationName] = provider
+	}
+	return provider.Tracer(TRACER_NAME)
+}
+
+// applicationTracer resolves the provider of its application on its first span
+// after Register, so it can be created before Register is called
+type applicationTracer struct {
+	embedded.Tracer
+	applicationName string
+	resolved        atomic.Pointer[resolvedTracer]
+}
+
+// resolvedTracer is the tracer of an application for a generation of the providers
+type resolvedTracer struct {
+	generation uint64
+	tracer     oteltrace.Tracer
+}
+
+// Tracer returns a tracer whose spans carry the resource of the application
+func Tracer(applicationName string) oteltrace.Tracer {
+	return &applicationTracer{applicationName: applicationName}
+}
+
+func (t *applicationTracer) Start(ctx context.Context, spanName string, opts ...oteltrace.SpanStartOption) (context.Context, oteltrace.Span) {
+	generation := providers.generation.Load()
+	resolved := t.resolved.Load()
+	if resolved == nil || resolved.generation != generation {
+		resolved = &resolvedTracer{generation: generation, tracer: providers.tracer(t.applicationName)}
+		t.resolved.Store(resolved)
+	}
+	return resolved.tracer.Start(ctx, spanName, opts...)
+}
@@ -0,0 +1,162 @@
+package openapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+)
+
+const (
+	// LABEL_TEMPLATE uses the path template as metric label
+	LABEL_TEMPLATE = "template"
+	// LABEL_OPERATION uses the operationId as metric label
+	LABEL_OPERATION = "operation"
+)
+
+const (
+	UNDOCUMENTED_ALLOW  = "allow"
+	UNDOCUMENTED_LOG    = "log"
+	UNDOCUMENTED_REJECT = "reject"
+)
+
+// Operation is a documented method and path template
+type Operation struct {
+	Method      string
+	Template    string
+	OperationID string
+	// Params holds the path parameters of a matched request
+	Params map[string]string
+	// specPath is the template as written in the document, without the server base path
+	specPath  string
+	pathItem  *openapi3.PathItem
+	operation *openapi3.Operation
+}
+
+// Name returns the operationId, or the method and template when it is not set
+func (o Operation) Name() string {
+	if o.OperationID != "" {
+		return o.OperationID
+	}
+	return o.Method + " " + o.Template
+}
+
+// Spec is an OpenAPI 3 document indexed by path template
+type Spec struct {
+	Document   *openapi3.T
+	Operations []Operation
+	tree       *utils.Tree
+	operations map[string]Operation
+}
+
+// Load reads and validates an OpenAPI 3 document in yaml or json
+func Load(path string) (*Spec, error) {
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+	doc, err := loader.LoadFromFile(path)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to load openapi file %s", path), err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, errors.Join(fmt.Errorf("invalid openapi file %s", path), err)
+	}
+	return newSpec(doc), nil
+}
+
+func newSpec(doc *openapi3.T) *Spec {
+	spec := &Spec{
+		Document:   doc,
+		tree:       utils.NewTree(),
+		operations: make(map[string]Operation),
+	}
+	basePath := basePath(doc)
+	templates := doc.Paths.InMatchingOrder()
+	sort.Strings(templates)
+	for _, template := range templates {
+		pathItem := doc.Paths.Value(template)
+		for method, operation := range pathItem.Operations() {
+			op := Operation{
+				Method:      method,
+				Template:    basePath + template,
+				OperationID: operation.OperationID,
+				specPath:    template,
+				pathItem:    pathItem,
+				operation:   operation,
+			}
+			spec.Operations = append(spec.Operations, op)
+			spec.operations[operationKey(op.Method, op.Template)] = op
+			spec.tree.InsertRoute(op.Method, op.Template, op.OperationID)
+		}
+	}
+	return spec
+}
+
+// basePath returns the path of the first server url, e.g. /v1 for https://api.example.com/v1
+func basePath(doc *openapi3.T) string {
+	if len(doc.Servers) == 0 {
+		return ""
+	}
+	serverURL, err := url.Parse(doc.Servers[0].URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(serverURL.Path, "/")
+}
+
+// FillTree inserts every documented template into the tree, the templates
+// that can not be inserted are skipped and returned as one error
+func (s *Spec) FillTree(tree *utils.Tree) error {
+	var errs []error
+	for _, op := range s.Operations {
+		if err := tree.InsertRoute(op.Method, op.Template, op.OperationID); err != nil {
+			errs = append(errs, fmt.Errorf("operation %s: %w", op.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Match returns the documented operation of the request, ok is false for undocumented paths
+func (s *Spec) Match(method, path string) (Operation, bool) {
+	match, ok := s.tree.Match(method, path)
+	if !ok {
+		return Operation{}, false
+	}
+	return s.Operation(method, match.Pattern, match.Params)
+}
+
+// Operation returns the documented operation of a method and template
+func (s *Spec) Operation(method, template string, params map[string]string) (Operation, bool) {
+	op, ok := s.operations[operationKey(method, template)]
+	if !ok {
+		return Operation{}, false
+	}
+	op.Params = params
+	return op, true
+}
+
+func operationKey(method, template string) string {
+	return strings.ToUpper(method) + " " + template
+}
+
+// ValidateLabel checks the metric label mode
+func ValidateLabel(label string) error {
+	switch label {
+	case "", LABEL_TEMPLATE, LABEL_OPERATION:
+		return nil
+	}
+	return fmt.Errorf("unknown openapi metrics label %q, expected one of template, operation", label)
+}
+
+// ValidateUndocumented checks the undocumented path policy
+func ValidateUndocumented(policy string) error {
+	switch policy {
+	case "", UNDOCUMENTED_ALLOW, UNDOCUMENTED_LOG, UNDOCUMENTED_REJECT:
+		return nil
+	}
+	return fmt.Errorf("unknown openapi undocumented policy %q, expected one of allow, log, reject", policy)
+}
@@ -0,0 +1,74 @@
+package openapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+)
+
+const testDocument = `
+openapi: 3.0.3
+info: {title: test, version: "1"}
+servers: [{url: "https://api.example.com/v1"}]
+paths:
+  /users/{id}:
+    get:
+      operationId: getUser
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+      responses:
+        "200": {description: ok}
+  /users:
+    post:
+      operationId: createUser
+      responses:
+        "201": {description: created}
+`
+
+func TestSpecMatch(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(testDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := newSpec(doc)
+
+	tests := []struct {
+		method     string
+		path       string
+		documented bool
+		template   string
+		operation  string
+	}{
+		{"GET", "/v1/users/42", true, "/v1/users/{id}", "getUser"},
+		{"POST", "/v1/users", true, "/v1/users", "createUser"},
+		{"DELETE", "/v1/users/42", false, "", ""},
+		{"GET", "/v1/users/42/orders", false, "", ""},
+		{"GET", "/users/42", false, "", ""},
+	}
+	for _, tt := range tests {
+		op, ok := spec.Match(tt.method, tt.path)
+		if ok != tt.documented || op.Template != tt.template || op.OperationID != tt.operation {
+			t.Errorf("Match(%s %s) = %+v, %v; want %s %s, %v", tt.method, tt.path, op, ok, tt.template, tt.operation, tt.documented)
+		}
+	}
+}
+
+func TestSpecFillTree(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(testDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := newSpec(doc)
+	spec.Operations = append(spec.Operations, Operation{Method: "GET", Template: "/v1/files/{path...}/raw", OperationID: "getFile"})
+
+	tree := utils.NewTree()
+	err = spec.FillTree(tree)
+	if err == nil || !strings.Contains(err.Error(), "getFile") {
+		t.Fatalf("FillTree error = %v, want the invalid operation", err)
+	}
+	if match, ok := tree.Match("GET", "/v1/users/42"); !ok || match.Name != "getUser" {
+		t.Errorf("valid operations were not inserted: %+v", match)
+	}
+}
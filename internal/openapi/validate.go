@@ -0,0 +1,235 @@
+package openapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+)
+
+const (
+	// VALIDATION_ENFORCE rejects invalid requests with a 400
+	VALIDATION_ENFORCE = "enforce"
+	// VALIDATION_REPORT logs and counts violations but proxies the request
+	VALIDATION_REPORT = "report"
+	VALIDATION_OFF    = "off"
+)
+
+const (
+	DIRECTION_REQUEST  = "request"
+	DIRECTION_RESPONSE = "response"
+)
+
+// DEFAULT_MAX_BODY_BYTES is the largest body validated when no maximum is configured
+const DEFAULT_MAX_BODY_BYTES = 1024 * 1024
+
+// Violation describes why a request or response does not match the document
+type Violation struct {
+	Direction string
+	// Rule is the part that failed, e.g. path_param, query_param, header, body, response_status
+	Rule    string
+	Field   string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// ValidationConfig holds the validation modes of the document operations
+type ValidationConfig struct {
+	// Mode is the default mode of every operation
+	Mode string
+	// RouteModes overrides the mode per operationId or path template
+	RouteModes map[string]string
+	// ResponseSampleRatio is the ratio of responses validated, between 0 and 1
+	ResponseSampleRatio float64
+	// MaxBodyBytes is the largest request or decoded response body validated,
+	// the body of a larger message is not validated. 0 is DEFAULT_MAX_BODY_BYTES
+	MaxBodyBytes int64
+}
+
+// ValidateMode checks a validation mode name
+func ValidateMode(mode string) error {
+	switch mode {
+	case "", VALIDATION_ENFORCE, VALIDATION_REPORT, VALIDATION_OFF:
+		return nil
+	}
+	return fmt.Errorf("unknown openapi validation mode %q, expected one of enforce, report, off", mode)
+}
+
+// Validate checks every mode of the config
+func (c ValidationConfig) Validate() error {
+	if err := ValidateMode(c.Mode); err != nil {
+		return err
+	}
+	for route, mode := range c.RouteModes {
+		if err := ValidateMode(mode); err != nil {
+			return errors.Join(fmt.Errorf("invalid validation mode of route %s", route), err)
+		}
+	}
+	if c.ResponseSampleRatio < 0 || c.ResponseSampleRatio > 1 {
+		return fmt.Errorf("openapi response sample ratio must be between 0 and 1, got %v", c.ResponseSampleRatio)
+	}
+	if c.MaxBodyBytes < 0 {
+		return fmt.Errorf("openapi validation max body size can not be negative, got %d", c.MaxBodyBytes)
+	}
+	return nil
+}
+
+// BodyLimit returns the largest body validated
+func (c ValidationConfig) BodyLimit() int64 {
+	if c.MaxBodyBytes == 0 {
+		return DEFAULT_MAX_BODY_BYTES
+	}
+	return c.MaxBodyBytes
+}
+
+// ModeOf returns the validation mode of an operation
+func (c ValidationConfig) ModeOf(op Operation) string {
+	if mode, ok := c.RouteModes[op.OperationID]; ok && op.OperationID != "" {
+		return mode
+	}
+	if mode, ok := c.RouteModes[op.Template]; ok {
+		return mode
+	}
+	if c.Mode == "" {
+		return VALIDATION_OFF
+	}
+	return c.Mode
+}
+
+func (s *Spec) requestInput(r *http.Request, op Operation) *openapi3filter.RequestValidationInput {
+	return &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: op.Params,
+		Route: &routers.Route{
+			Spec:      s.Document,
+			Path:      op.specPath,
+			PathItem:  op.pathItem,
+			Method:    op.Method,
+			Operation: op.operation,
+		},
+		Options: &openapi3filter.Options{
+			// authentication is the job of the upstream
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+}
+
+// ValidateRequest checks the path params, query, headers and body of the request.
+// The body is read and replaced so that it can still be proxied, a body larger
+// than maxBodyBytes is not read nor validated.
+func (s *Spec) ValidateRequest(ctx context.Context, r *http.Request, op Operation, maxBodyBytes int64) *Violation {
+	input := s.requestInput(r, op)
+	input.Options.ExcludeRequestBody = !bufferBody(r, maxBodyBytes)
+	err := openapi3filter.ValidateRequest(ctx, input)
+	if err == nil {
+		return nil
+	}
+	return requestViolation(err)
+}
+
+// ValidateResponse checks the status, headers and body of a captured response.
+// A gzip body is decoded, a body with another content encoding or larger than
+// maxBodyBytes once decoded is not validated.
+func (s *Spec) ValidateResponse(ctx context.Context, r *http.Request, op Operation, status int, header http.Header, body []byte, maxBodyBytes int64) *Violation {
+	body, ok := decodeBody(header, body, maxBodyBytes)
+	if !ok {
+		return nil
+	}
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: s.requestInput(r, op),
+		Status:                 status,
+		Header:                 header,
+		Body:                   io.NopCloser(bytes.NewReader(body)),
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+		},
+	}
+	err := openapi3filter.ValidateResponse(ctx, input)
+	if err == nil {
+		return nil
+	}
+	return responseViolation(err)
+}
+
+// bufferBody reads the body up to maxBodyBytes and puts back a body serving
+// the read bytes then the rest, ok is false when the body is larger
+func bufferBody(r *http.Request, maxBodyBytes int64) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+	return err == nil && int64(len(body)) <= maxBodyBytes
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decodeBody returns the body without its content encoding, ok is false when
+// the encoding is not supported, the body can not be decoded or is larger
+// than maxBodyBytes once decoded
+func decodeBody(header http.Header, body []byte, maxBodyBytes int64) ([]byte, bool) {
+	switch encoding := strings.ToLower(header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+		return body, int64(len(body)) <= maxBodyBytes
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false
+		}
+		// a small body can decode to an unbounded one
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxBodyBytes+1))
+		if err != nil || int64(len(decoded)) > maxBodyBytes {
+			return nil, false
+		}
+		return decoded, true
+	}
+	return nil, false
+}
+
+func requestViolation(err error) *Violation {
+	violation := &Violation{Direction: DIRECTION_REQUEST, Rule: "request", Message: err.Error()}
+	var requestErr *openapi3filter.RequestError
+	var securityErr *openapi3filter.SecurityRequirementsError
+	switch {
+	case errors.As(err, &requestErr):
+		if requestErr.Parameter != nil {
+			violation.Rule = requestErr.Parameter.In + "_param"
+			violation.Field = requestErr.Parameter.Name
+		} else if requestErr.RequestBody != nil {
+			violation.Rule = "body"
+		}
+	case errors.As(err, &securityErr):
+		violation.Rule = "security"
+	}
+	return violation
+}
+
+func responseViolation(err error) *Violation {
+	violation := &Violation{Direction: DIRECTION_RESPONSE, Rule: "response", Message: err.Error()}
+	var responseErr *openapi3filter.ResponseError
+	if errors.As(err, &responseErr) {
+		reason := strings.ToLower(responseErr.Reason)
+		switch {
+		case strings.Contains(reason, "status"):
+			violation.Rule = "response_status"
+		case strings.Contains(reason, "header"):
+			violation.Rule = "response_header"
+		case strings.Contains(reason, "body"):
+			violation.Rule = "response_body"
+		}
+	}
+	return violation
+}
@@ -0,0 +1,154 @@
+package openapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const validationDocument = `
+openapi: 3.0.3
+info: {title: test, version: "1"}
+paths:
+  /users/{id}:
+    get:
+      operationId: getUser
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+        - {name: fields, in: query, schema: {type: string, enum: [name, email]}}
+      responses:
+        "200":
+          description: ok
+          content:
+            application/json:
+              schema:
+                type: object
+                required: [id, name]
+                properties:
+                  id: {type: integer}
+                  name: {type: string}
+  /users:
+    post:
+      operationId: createUser
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name: {type: string}
+      responses:
+        "201": {description: created}
+`
+
+func validationSpec(t *testing.T) *Spec {
+	t.Helper()
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(validationDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSpec(doc)
+}
+
+func TestValidateRequest(t *testing.T) {
+	spec := validationSpec(t)
+	tests := []struct {
+		method string
+		target string
+		body   string
+		rule   string
+		field  string
+	}{
+		{"GET", "/users/42?fields=name", "", "", ""},
+		{"GET", "/users/abc", "", "path_param", "id"},
+		{"GET", "/users/42?fields=password", "", "query_param", "fields"},
+		{"POST", "/users", `{"name":"bob"}`, "", ""},
+		{"POST", "/users", `{"email":"bob@example.com"}`, "body", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		op, ok := spec.Match(r.Method, r.URL.Path)
+		if !ok {
+			t.Fatalf("%s %s is not documented", tt.method, tt.target)
+		}
+		violation := spec.ValidateRequest(context.Background(), r, op, DEFAULT_MAX_BODY_BYTES)
+		switch {
+		case tt.rule == "" && violation != nil:
+			t.Errorf("%s %s: unexpected violation %+v", tt.method, tt.target, violation)
+		case tt.rule != "" && (violation == nil || violation.Rule != tt.rule || violation.Field != tt.field || violation.Direction != DIRECTION_REQUEST):
+			t.Errorf("%s %s: violation = %+v, want rule %s field %s", tt.method, tt.target, violation, tt.rule, tt.field)
+		}
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	spec := validationSpec(t)
+	r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	op, _ := spec.Match(r.Method, r.URL.Path)
+	jsonHeader := func() http.Header { return http.Header{"Content-Type": {"application/json"}} }
+
+	if violation := spec.ValidateResponse(context.Background(), r, op, 200, jsonHeader(), []byte(`{"id":42,"name":"bob"}`), DEFAULT_MAX_BODY_BYTES); violation != nil {
+		t.Errorf("valid response: %+v", violation)
+	}
+	if violation := spec.ValidateResponse(context.Background(), r, op, 200, jsonHeader(), []byte(`{"id":"42"}`), DEFAULT_MAX_BODY_BYTES); violation == nil || violation.Rule != "response_body" || violation.Direction != DIRECTION_RESPONSE {
+		t.Errorf("invalid body: %+v", violation)
+	}
+	if violation := spec.ValidateResponse(context.Background(), r, op, 418, jsonHeader(), nil, DEFAULT_MAX_BODY_BYTES); violation == nil || violation.Rule != "response_status" {
+		t.Errorf("undocumented status: %+v", violation)
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(`{"id":42,"name":"bob"}`))
+	writer.Close()
+	header := jsonHeader()
+	header.Set("Content-Encoding", "gzip")
+	if violation := spec.ValidateResponse(context.Background(), r, op, 200, header, compressed.Bytes(), DEFAULT_MAX_BODY_BYTES); violation != nil {
+		t.Errorf("gzip response: %+v", violation)
+	}
+	header.Set("Content-Encoding", "br")
+	if violation := spec.ValidateResponse(context.Background(), r, op, 200, header, []byte{0x8b, 0x01}, DEFAULT_MAX_BODY_BYTES); violation != nil {
+		t.Errorf("a body with an unsupported encoding was validated: %+v", violation)
+	}
+}
+
+func TestValidateBodyLimit(t *testing.T) {
+	spec := validationSpec(t)
+	body := `{"email":"bob@example.com"}`
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	op, _ := spec.Match(r.Method, r.URL.Path)
+	if violation := spec.ValidateRequest(context.Background(), r, op, 8); violation != nil {
+		t.Errorf("a body over the limit was validated: %+v", violation)
+	}
+	if proxied, _ := io.ReadAll(r.Body); string(proxied) != body {
+		t.Errorf("proxied body = %q", proxied)
+	}
+
+	// a small gzip body decoding past the limit is not decoded entirely
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(bytes.Repeat([]byte(" "), 1024*1024))
+	writer.Close()
+	r = httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	op, _ = spec.Match(r.Method, r.URL.Path)
+	header := http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}}
+	if violation := spec.ValidateResponse(context.Background(), r, op, 200, header, compressed.Bytes(), 1024); violation != nil {
+		t.Errorf("a response decoding past the limit was validated: %+v", violation)
+	}
+	if _, ok := decodeBody(header, compressed.Bytes(), 1024); ok {
+		t.Error("a body decoding past the limit was decoded")
+	}
+}
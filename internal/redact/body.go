@@ -0,0 +1,39 @@
+package redact
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// BODY_SLACK is kept past the max body size so that a value cut by the cap is
+// still recognised by the patterns
+const BODY_SLACK = 256
+
+// KeptBytes is the number of body bytes to keep to redact a body capped at maxBytes
+func KeptBytes(maxBytes int) int {
+	return maxBytes + BODY_SLACK
+}
+
+// KeptBody returns the bytes to keep of a whole body capped at maxBytes
+func KeptBody(body []byte, maxBytes int) []byte {
+	return body[:min(len(body), KeptBytes(maxBytes))]
+}
+
+// TrimCutRune drops a multibyte character cut at the end of the kept bytes
+func TrimCutRune(body []byte) []byte {
+	for i := 1; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+		body = body[:len(body)-1]
+	}
+	return body
+}
+
+// TruncatedBody redacts the kept bytes of a text body of size bytes and cuts
+// the text at maxBytes when the body is over the cap, the text of a body within
+// the cap is kept whole even if it grew
+func (r *Redactor) TruncatedBody(kept []byte, size int64, maxBytes int) string {
+	text := string(r.Body(kept))
+	if size > int64(maxBytes) && len(text) > maxBytes {
+		text = strings.ToValidUTF8(text[:maxBytes], "")
+	}
+	return text
+}
@@ -0,0 +1,279 @@
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// REDACTED replaces every sensitive value
+const REDACTED = "[REDACTED]"
+
+// Built-in patterns scrubbing free text such as captured bodies
+const (
+	PATTERN_BEARER = "bearer"
+	PATTERN_JWT    = "jwt"
+	PATTERN_EMAIL  = "email"
+	// PATTERN_CARD masks digit sequences having a card issuer prefix and passing the Luhn check
+	PATTERN_CARD = "card"
+)
+
+var builtinPatterns = map[string]*regexp.Regexp{
+	PATTERN_BEARER: regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`),
+	PATTERN_JWT:    regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	PATTERN_EMAIL:  regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	PATTERN_CARD:   regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+}
+
+// DefaultHeaders are the headers redacted when no denylist is configured
+var DefaultHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+	"X-Api-Key", "X-Auth-Token", "X-Csrf-Token",
+}
+
+// DefaultQueryParams are the query parameters masked when none are configured
+var DefaultQueryParams = []string{
+	"token", "access_token", "refresh_token", "id_token", "api_key", "apikey",
+	"key", "password", "secret", "client_secret", "signature", "code",
+}
+
+// DefaultPatterns are the patterns applied when none are configured
+var DefaultPatterns = []string{PATTERN_BEARER, PATTERN_JWT, PATTERN_EMAIL, PATTERN_CARD}
+
+// Config holds what is redacted from logs, span attributes and captured bodies
+type Config struct {
+	// Headers are always redacted
+	Headers []string
+	// AllowHeaders, when set, redacts every header missing from the list
+	AllowHeaders []string
+	// QueryParams are masked in urls, they also mask route params of the same name
+	QueryParams []string
+	// Patterns are built-in pattern names or regular expressions scrubbed from text
+	Patterns []string
+}
+
+// Redactor masks sensitive values, a nil Redactor leaves values untouched
+type Redactor struct {
+	headers     map[string]bool
+	allow       map[string]bool
+	queryParams map[string]bool
+	patterns    []*regexp.Regexp
+	card        bool
+}
+
+// New compiles the config
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{
+		headers:     make(map[string]bool),
+		queryParams: make(map[string]bool),
+	}
+	for _, name := range cfg.Headers {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	if len(cfg.AllowHeaders) > 0 {
+		r.allow = make(map[string]bool)
+		for _, name := range cfg.AllowHeaders {
+			r.allow[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	for _, name := range cfg.QueryParams {
+		r.queryParams[strings.ToLower(name)] = true
+	}
+	for _, pattern := range cfg.Patterns {
+		if pattern == PATTERN_CARD {
+			r.card = true
+			continue
+		}
+		if re, ok := builtinPatterns[pattern]; ok {
+			r.patterns = append(r.patterns, re)
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid redaction pattern %q", pattern), err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Default returns a redactor with the default lists
+func Default() *Redactor {
+	r, _ := New(Config{Headers: DefaultHeaders, QueryParams: DefaultQueryParams, Patterns: DefaultPatterns})
+	return r
+}
+
+// IsSensitiveHeader reports whether the values of the header are redacted
+func (r *Redactor) IsSensitiveHeader(name string) bool {
+	if r == nil {
+		return false
+	}
+	name = http.CanonicalHeaderKey(name)
+	if r.headers[name] {
+		return true
+	}
+	return r.allow != nil && !r.allow[name]
+}
+
+// Header returns a copy of the header with the sensitive values redacted
+func (r *Redactor) Header(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		if r.IsSensitiveHeader(name) {
+			redacted[name] = []string{REDACTED}
+			continue
+		}
+		copied := make([]string, len(values))
+		for i, value := range values {
+			copied[i] = r.String(value)
+		}
+		redacted[name] = copied
+	}
+	return redacted
+}
+
+// IsDeniedHeader reports whether the header is in the denylist, it is used
+// for log fields named after a header
+func (r *Redactor) IsDeniedHeader(name string) bool {
+	return r != nil && r.headers[http.CanonicalHeaderKey(name)]
+}
+
+// IsSensitiveParam reports whether a query or route param is masked
+func (r *Redactor) IsSensitiveParam(name string) bool {
+	return r != nil && r.queryParams[strings.ToLower(name)]
+}
+
+// Query masks the sensitive parameters of a raw query, the order is kept
+func (r *Redactor) Query(rawQuery string) string {
+	if r == nil || rawQuery == "" {
+		return rawQuery
+	}
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		key, _, hasValue := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if hasValue && r.IsSensitiveParam(name) {
+			parts[i] = key + "=" + url.QueryEscape(REDACTED)
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// URL masks the query of a path or url such as /login?token=abc
+func (r *Redactor) URL(rawURL string) string {
+	path, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+	return path + "?" + r.Query(query)
+}
+
+// Params returns a copy of route params with the sensitive values masked
+func (r *Redactor) Params(params map[string]string) map[string]string {
+	if r == nil || len(params) == 0 {
+		return params
+	}
+	redacted := make(map[string]string, len(params))
+	for name, value := range params {
+		if r.IsSensitiveParam(name) {
+			value = REDACTED
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// String scrubs the configured patterns from free text
+func (r *Redactor) String(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, REDACTED)
+	}
+	if r.card {
+		text = builtinPatterns[PATTERN_CARD].ReplaceAllStringFunc(text, func(digits string) string {
+			if isCardNumber(digits) {
+				return REDACTED
+			}
+			return digits
+		})
+	}
+	return text
+}
+
+// Value redacts a decoded JSON value: the fields named after a sensitive
+// param or header are masked and the patterns are scrubbed from the strings
+func (r *Redactor) Value(value any) any {
+	if r == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		redacted := make(map[string]any, len(v))
+		for key, field := range v {
+			if r.IsSensitiveParam(key) || r.IsDeniedHeader(key) {
+				redacted[key] = REDACTED
+				continue
+			}
+			redacted[key] = r.Value(field)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(v))
+		for i, item := range v {
+			redacted[i] = r.Value(item)
+		}
+		return redacted
+	case string:
+		return r.URL(r.String(v))
+	}
+	return value
+}
+
+// Body scrubs the configured patterns from a captured body
+func (r *Redactor) Body(body []byte) []byte {
+	if r == nil || len(body) == 0 {
+		return body
+	}
+	return []byte(r.String(string(body)))
+}
+
+// isCardNumber reports whether the digits look like a payment card number,
+// numeric ids are told apart by the issuer prefix and the Luhn checksum
+func isCardNumber(number string) bool {
+	// Mastercard starts with 2 or 5, Amex and Diners with 3, Visa with 4, Discover and UnionPay with 6
+	if number[0] < '2' || number[0] > '6' {
+		return false
+	}
+	return luhn(number)
+}
+
+// luhn reports whether the digits, ignoring separators, pass the Luhn checksum
+func luhn(number string) bool {
+	sum, count := 0, 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		count++
+	}
+	return count >= 13 && sum%10 == 0
+}
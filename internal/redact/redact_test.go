@@ -0,0 +1,114 @@
+package redact
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	r := Default()
+	header := http.Header{
+		"Authorization": {"Bearer abc.def"},
+		"Cookie":        {"session=1"},
+		"Accept":        {"application/json"},
+	}
+	redacted := r.Header(header)
+	if redacted.Get("Authorization") != REDACTED || redacted.Get("Cookie") != REDACTED {
+		t.Errorf("credentials not redacted: %v", redacted)
+	}
+	if redacted.Get("Accept") != "application/json" {
+		t.Errorf("accept = %s", redacted.Get("Accept"))
+	}
+	if header.Get("Authorization") != "Bearer abc.def" {
+		t.Error("the original header was modified")
+	}
+
+	allow, _ := New(Config{AllowHeaders: []string{"Accept"}})
+	if allow.IsSensitiveHeader("Accept") || !allow.IsSensitiveHeader("X-Custom") {
+		t.Error("allowlist ignored")
+	}
+}
+
+func TestURL(t *testing.T) {
+	r := Default()
+	got := r.URL("/login?user=bob&access_token=abc&Password=x")
+	if got != "/login?user=bob&access_token=%5BREDACTED%5D&Password=%5BREDACTED%5D" {
+		t.Errorf("url = %s", got)
+	}
+	if got := r.URL("/users/42"); got != "/users/42" {
+		t.Errorf("url without query = %s", got)
+	}
+}
+
+func TestString(t *testing.T) {
+	r := Default()
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"Authorization: Bearer abc123", "Authorization: " + REDACTED},
+		{"token eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig", "token " + REDACTED},
+		{`{"email":"bob@example.com"}`, `{"email":"` + REDACTED + `"}`},
+		{"card 4111 1111 1111 1111", "card " + REDACTED},
+		// fails the Luhn check
+		{"order 1234567890123456", "order 1234567890123456"},
+		// pass the Luhn check without a card issuer prefix
+		{"id 1844674407370955165", "id 1844674407370955165"},
+		{"id 70000000000000003", "id 70000000000000003"},
+	}
+	for _, tt := range tests {
+		if got := r.String(tt.text); got != tt.expected {
+			t.Errorf("String(%q) = %q, want %q", tt.text, got, tt.expected)
+		}
+	}
+
+	custom, err := New(Config{Patterns: []string{`ssn-\d+`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := custom.String("id ssn-123"); got != "id "+REDACTED {
+		t.Errorf("custom pattern = %s", got)
+	}
+	if _, err := New(Config{Patterns: []string{"("}}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestValue(t *testing.T) {
+	value := Default().Value(map[string]any{
+		"user":     "bob",
+		"password": "secret",
+		"headers":  map[string]any{"Authorization": "Bearer abc"},
+		"links":    []any{"/callback?token=abc", 42},
+	})
+	expected := map[string]any{
+		"user":     "bob",
+		"password": REDACTED,
+		"headers":  map[string]any{"Authorization": REDACTED},
+		"links":    []any{"/callback?token=%5BREDACTED%5D", 42},
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Value = %v, want %v", value, expected)
+	}
+	if value := (*Redactor)(nil).Value("Bearer abc"); value != "Bearer abc" {
+		t.Errorf("nil redactor changed %v", value)
+	}
+}
+
+func TestTruncatedBody(t *testing.T) {
+	r := Default()
+	// the email is cut by the cap but still matched within the slack
+	body := []byte("contact bob@example.com")
+	kept := KeptBody(body, 12)
+	if got := r.TruncatedBody(kept, int64(len(body)), 12); got != "contact [RED" {
+		t.Errorf("truncated body = %q", got)
+	}
+	// a body within the cap is kept whole even if the redaction grew it
+	if got := r.TruncatedBody([]byte("to a@b.io"), 9, 9); got != "to "+REDACTED {
+		t.Errorf("body within the cap = %q", got)
+	}
+	if got := TrimCutRune([]byte("caf\xc3")); string(got) != "caf" {
+		t.Errorf("cut rune kept: %q", got)
+	}
+}
@@ -0,0 +1,199 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/capture"
+	"github.com/tae2089/reverse-proxy/internal/har"
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+// Request is a recorded request and the response the upstream gave at the time
+type Request struct {
+	Start  time.Time
+	Method string
+	// URL is the path and query, the target of the replay supplies the host
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Status and Latency are the recorded status and latency
+	Status  int
+	Latency time.Duration
+	// Incomplete is true when the body was truncated by the recorder or the
+	// query or the body holds a redacted value
+	Incomplete bool
+	// Source is the file and position of the request, e.g. traffic.har#3
+	Source string
+}
+
+// skippedHeaders are set by the http client of the replay
+var skippedHeaders = map[string]bool{
+	"Host": true, "Content-Length": true, "Connection": true, "Keep-Alive": true,
+	"Transfer-Encoding": true, "Te": true, "Trailer": true, "Upgrade": true,
+	"Proxy-Connection": true, "Accept-Encoding": true,
+}
+
+// Load reads the requests of a HAR file, a capture file or a directory of
+// them, sorted by start time. HAR files end with .har, other files are read
+// as capture records, one json object per line.
+func Load(path string) ([]Request, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			// the file a proxy is writing is skipped until it is complete
+			if entry.IsDir() || strings.HasSuffix(entry.Name(), har.PARTIAL_EXTENSION) {
+				continue
+			}
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	var requests []Request
+	for _, file := range files {
+		var loaded []Request
+		if strings.HasSuffix(file, har.FILE_EXTENSION) {
+			loaded, err = loadHAR(file)
+		} else {
+			loaded, err = loadCapture(file)
+		}
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to load %s", file), err)
+		}
+		requests = append(requests, loaded...)
+	}
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].Start.Before(requests[j].Start)
+	})
+	return requests, nil
+}
+
+func loadHAR(path string) ([]Request, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file har.File
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+	requests := make([]Request, 0, len(file.Log.Entries))
+	for i, entry := range file.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid url of entry %d", i), err)
+		}
+		request := Request{
+			Start:   entry.StartedDateTime,
+			Method:  entry.Request.Method,
+			URL:     u.RequestURI(),
+			Header:  make(http.Header),
+			Status:  entry.Response.Status,
+			Latency: time.Duration(entry.Time * float64(time.Millisecond)),
+			Source:  fmt.Sprintf("%s#%d", filepath.Base(path), i),
+		}
+		for _, header := range entry.Request.Headers {
+			request.Header.Add(header.Name, header.Value)
+		}
+		if postData := entry.Request.PostData; postData != nil {
+			request.Body = []byte(postData.Text)
+			if postData.Encoding == "base64" {
+				if request.Body, err = base64.StdEncoding.DecodeString(postData.Text); err != nil {
+					return nil, errors.Join(fmt.Errorf("invalid body of entry %d", i), err)
+				}
+			}
+		}
+		request.Incomplete = int64(len(request.Body)) < entry.Request.BodySize
+		requests = append(requests, request.clean())
+	}
+	return requests, nil
+}
+
+func loadCapture(path string) ([]Request, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var requests []Request
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record capture.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid record on line %d", line), err)
+		}
+		latency := time.Duration(record.ResponseTime * float64(time.Second))
+		request := Request{
+			// the record is written once the response is served
+			Start:   record.Time.Add(-latency),
+			Method:  record.Method,
+			URL:     record.URL,
+			Header:  record.Request.Header,
+			Body:    []byte(record.Request.Body),
+			Status:  record.Status,
+			Latency: latency,
+			Source:  fmt.Sprintf("%s:%d", filepath.Base(path), line),
+			// the body of an omitted content type is missing
+			Incomplete: record.Request.Truncated || record.Request.Omitted != "",
+		}
+		requests = append(requests, request.clean())
+	}
+	return requests, scanner.Err()
+}
+
+// clean removes the headers set by the client and the redacted header values,
+// they would be sent as is. A redacted value of the query or the body can not
+// be removed, the request is marked incomplete instead.
+func (r Request) clean() Request {
+	header := make(http.Header, len(r.Header))
+	for name, values := range r.Header {
+		name = http.CanonicalHeaderKey(name)
+		if skippedHeaders[name] {
+			continue
+		}
+		for _, value := range values {
+			if value != redact.REDACTED {
+				header.Add(name, value)
+			}
+		}
+	}
+	r.Header = header
+	if isRedacted(r.URL) || bytes.Contains(r.Body, []byte(redact.REDACTED)) {
+		r.Incomplete = true
+	}
+	return r
+}
+
+// isRedacted reports whether the query of a url holds a redacted value, it is
+// escaped when the recorder masked a query parameter
+func isRedacted(rawURL string) bool {
+	_, query, _ := strings.Cut(rawURL, "?")
+	if strings.Contains(query, redact.REDACTED) {
+		return true
+	}
+	unescaped, err := url.QueryUnescape(query)
+	return err == nil && strings.Contains(unescaped, redact.REDACTED)
+}
@@ -0,0 +1,152 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the target and the pace of a replay
+type Config struct {
+	// Target is the base url the requests are sent to, e.g. http://localhost:8080
+	Target      string
+	Concurrency int
+	// Rate caps the requests per second, unlimited when 0
+	Rate float64
+	// TimeScale replays the requests at their recorded pace divided by the
+	// scale, e.g. 2 replays twice as fast, the pace is ignored when 0
+	TimeScale float64
+	Timeout   time.Duration
+	// Header is added to every request, e.g. to mark replayed traffic
+	Header http.Header
+	// SkipIncomplete skips the requests whose recorded body is incomplete or redacted
+	SkipIncomplete bool
+}
+
+// Validate checks the target and the pace
+func (c Config) Validate() error {
+	u, err := url.Parse(c.Target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid replay target %q, expected an url such as http://localhost:8080", c.Target)
+	}
+	if c.Concurrency < 1 {
+		return fmt.Errorf("replay concurrency must be at least 1, got %d", c.Concurrency)
+	}
+	if c.Rate < 0 || c.TimeScale < 0 {
+		return errors.New("replay rate and time scale must not be negative")
+	}
+	return nil
+}
+
+// Result is the outcome of a replayed request
+type Result struct {
+	Request *Request
+	Status  int
+	Latency time.Duration
+	Err     error
+	Skipped bool
+}
+
+// Run replays the requests, they are sent in order but may complete out of
+// order when the concurrency is greater than 1
+func Run(ctx context.Context, cfg Config, requests []Request) []Result {
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		// redirects are part of the recorded responses
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Transport: &http.Transport{
+			Proxy:               nil,
+			MaxIdleConnsPerHost: cfg.Concurrency,
+		},
+	}
+	target := strings.TrimSuffix(cfg.Target, "/")
+	results := make([]Result, len(requests))
+	slots := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.Rate)
+	}
+	begin := time.Now()
+	var next time.Time
+	for i := range requests {
+		request := &requests[i]
+		results[i].Request = request
+		if cfg.SkipIncomplete && request.Incomplete {
+			results[i].Skipped = true
+			continue
+		}
+		// the recorded pace, then the rate limit
+		at := time.Now()
+		if cfg.TimeScale > 0 {
+			offset := request.Start.Sub(requests[0].Start)
+			at = begin.Add(time.Duration(float64(offset) / cfg.TimeScale))
+		}
+		if at.Before(next) {
+			at = next
+		}
+		next = at.Add(interval)
+		if !sleepUntil(ctx, at) {
+			results[i].Err = ctx.Err()
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(result *Result) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result.Status, result.Latency, result.Err = send(ctx, client, target, cfg.Header, result.Request)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+func sleepUntil(ctx context.Context, at time.Time) bool {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func send(ctx context.Context, client *http.Client, target string, header http.Header, request *Request) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, request.Method, target+request.URL, bytes.NewReader(request.Body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header = request.Header.Clone()
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	defer resp.Body.Close()
+	// the latency includes the body as the recorded one does
+	_, err = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, time.Since(start), err
+}
@@ -0,0 +1,67 @@
+package replay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const records = `{"timestamp":"2024-10-10T13:55:36Z","reason":"sample","method":"GET","url":"/users/1","status_code":200,"response_time":0.001,"request":{"header":{"Authorization":["[REDACTED]"],"Accept":["application/json"]},"size":0},"response":{"header":{},"size":0}}
+{"timestamp":"2024-10-10T13:55:37Z","reason":"status","method":"POST","url":"/orders","status_code":201,"response_time":0.002,"request":{"header":{"Content-Type":["application/json"]},"body":"{\"id\":1}","size":8},"response":{"header":{},"size":0}}
+{"timestamp":"2024-10-10T13:55:38Z","reason":"sample","method":"POST","url":"/upload","status_code":200,"response_time":0.001,"request":{"header":{},"body":"abc","size":900,"truncated":true},"response":{"header":{},"size":0}}
+{"timestamp":"2024-10-10T13:55:39Z","reason":"sample","method":"GET","url":"/callback?code=%5BREDACTED%5D","status_code":302,"response_time":0.001,"request":{"header":{},"size":0},"response":{"header":{},"size":0}}
+{"timestamp":"2024-10-10T13:55:40Z","reason":"sample","method":"POST","url":"/login","status_code":200,"response_time":0.001,"request":{"header":{},"body":"{\"email\":\"[REDACTED]\"}","size":27},"response":{"header":{},"size":0}}
+`
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.log")
+	if err := os.WriteFile(path, []byte(records), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	requests, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 5 {
+		t.Fatalf("requests = %+v", requests)
+	}
+	// truncated, then a redacted query and a redacted body
+	for i, request := range requests {
+		if request.Incomplete != (i >= 2) {
+			t.Errorf("%s incomplete = %v", request.URL, request.Incomplete)
+		}
+	}
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Error("redacted header replayed")
+		}
+		if r.Header.Get("X-Replay") != "true" {
+			t.Error("replay header missing")
+		}
+		if r.URL.Path == "/orders" {
+			// the new version answers 200 instead of 201
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer target.Close()
+
+	cfg := Config{Target: target.URL, Concurrency: 2, Timeout: time.Second, SkipIncomplete: true, Header: http.Header{"X-Replay": {"true"}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	report := NewReport(Run(context.Background(), cfg, requests), Thresholds{LatencyRatio: 1000, LatencyDelta: time.Second})
+	if report.Replayed != 2 || report.Skipped != 3 || report.StatusMatches != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.StatusDiffs) != 1 || report.StatusDiffs[0].URL != "/orders" || report.StatusDiffs[0].Status != http.StatusOK {
+		t.Errorf("status diffs = %+v", report.StatusDiffs)
+	}
+	if !report.HasDiffs() {
+		t.Error("diffs not reported")
+	}
+}
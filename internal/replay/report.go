@@ -0,0 +1,152 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Report compares the replayed responses with the recorded ones
+type Report struct {
+	Total    int `json:"total"`
+	Replayed int `json:"replayed"`
+	Skipped  int `json:"skipped"`
+	Errors   int `json:"errors"`
+	// StatusMatches counts the replayed requests answered with the recorded status
+	StatusMatches int    `json:"status_matches"`
+	StatusDiffs   []Diff `json:"status_diffs"`
+	// the latencies are in nanoseconds in json
+	RecordedLatency Percentiles `json:"recorded_latency_ns"`
+	ReplayedLatency Percentiles `json:"replayed_latency_ns"`
+	// Slower are the requests slower than the recording past the thresholds
+	Slower []Diff `json:"slower"`
+}
+
+// Diff is a replayed request that differs from the recording
+type Diff struct {
+	Source          string        `json:"source"`
+	Method          string        `json:"method"`
+	URL             string        `json:"url"`
+	RecordedStatus  int           `json:"recorded_status"`
+	Status          int           `json:"status"`
+	RecordedLatency time.Duration `json:"recorded_latency_ns"`
+	Latency         time.Duration `json:"latency_ns"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// Percentiles of a latency distribution
+type Percentiles struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// Thresholds decide when a replayed request is reported as slower, it must
+// exceed both the ratio and the delta
+type Thresholds struct {
+	LatencyRatio float64
+	LatencyDelta time.Duration
+}
+
+// NewReport compares the results with the recording
+func NewReport(results []Result, thresholds Thresholds) *Report {
+	report := &Report{Total: len(results)}
+	var recorded, replayed []time.Duration
+	for _, result := range results {
+		request := result.Request
+		if result.Skipped {
+			report.Skipped++
+			continue
+		}
+		diff := Diff{
+			Source:          request.Source,
+			Method:          request.Method,
+			URL:             request.URL,
+			RecordedStatus:  request.Status,
+			Status:          result.Status,
+			RecordedLatency: request.Latency,
+			Latency:         result.Latency,
+		}
+		if result.Err != nil {
+			report.Errors++
+			diff.Error = result.Err.Error()
+			report.StatusDiffs = append(report.StatusDiffs, diff)
+			continue
+		}
+		report.Replayed++
+		recorded = append(recorded, request.Latency)
+		replayed = append(replayed, result.Latency)
+		if result.Status == request.Status {
+			report.StatusMatches++
+		} else {
+			report.StatusDiffs = append(report.StatusDiffs, diff)
+		}
+		if float64(result.Latency) > float64(request.Latency)*thresholds.LatencyRatio && result.Latency-request.Latency > thresholds.LatencyDelta {
+			report.Slower = append(report.Slower, diff)
+		}
+	}
+	report.RecordedLatency = percentiles(recorded)
+	report.ReplayedLatency = percentiles(replayed)
+	sort.SliceStable(report.Slower, func(i, j int) bool {
+		return report.Slower[i].Latency-report.Slower[i].RecordedLatency > report.Slower[j].Latency-report.Slower[j].RecordedLatency
+	})
+	return report
+}
+
+func percentiles(latencies []time.Duration) Percentiles {
+	if len(latencies) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+	return Percentiles{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: latencies[len(latencies)-1]}
+}
+
+// HasDiffs reports whether a status differs, a request failed or was slower
+func (r *Report) HasDiffs() bool {
+	return len(r.StatusDiffs) > 0 || len(r.Slower) > 0
+}
+
+// WriteJSON writes the report as json
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes a summary and at most limit diffs of each kind
+func (r *Report) WriteText(w io.Writer, limit int) {
+	fmt.Fprintf(w, "requests: %d replayed, %d skipped, %d errors, %d of %d with the recorded status\n",
+		r.Replayed, r.Skipped, r.Errors, r.StatusMatches, r.Replayed)
+	fmt.Fprintf(w, "\n%-10s %10s %10s %10s %10s\n", "LATENCY", "P50", "P90", "P99", "MAX")
+	for _, row := range []struct {
+		name string
+		p    Percentiles
+	}{{"recorded", r.RecordedLatency}, {"replayed", r.ReplayedLatency}} {
+		fmt.Fprintf(w, "%-10s %10s %10s %10s %10s\n", row.name,
+			row.p.P50.Round(time.Microsecond), row.p.P90.Round(time.Microsecond),
+			row.p.P99.Round(time.Microsecond), row.p.Max.Round(time.Microsecond))
+	}
+	if len(r.StatusDiffs) > 0 {
+		fmt.Fprintf(w, "\nstatus differences: %d\n", len(r.StatusDiffs))
+		for _, diff := range r.StatusDiffs[:min(limit, len(r.StatusDiffs))] {
+			status := fmt.Sprint(diff.Status)
+			if diff.Error != "" {
+				status = diff.Error
+			}
+			fmt.Fprintf(w, "  %s %s (%s): recorded %d, replayed %s\n", diff.Method, diff.URL, diff.Source, diff.RecordedStatus, status)
+		}
+	}
+	if len(r.Slower) > 0 {
+		fmt.Fprintf(w, "\nslower requests: %d\n", len(r.Slower))
+		for _, diff := range r.Slower[:min(limit, len(r.Slower))] {
+			fmt.Fprintf(w, "  %s %s (%s): recorded %s, replayed %s\n", diff.Method, diff.URL, diff.Source,
+				diff.RecordedLatency.Round(time.Microsecond), diff.Latency.Round(time.Microsecond))
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tae2089/reverse-proxy/internal/canary"
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"github.com/tae2089/reverse-proxy/internal/shadow"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+	"go.uber.org/zap"
+)
+
+// AdminController serves the admin endpoints of the metrics listener
+type AdminController interface {
+	Metrics() http.HandlerFunc
+	Patterns() http.HandlerFunc
+	LogLevel() http.HandlerFunc
+	ShadowDiffs() http.HandlerFunc
+	Canary() http.HandlerFunc
+	BlueGreen() http.HandlerFunc
+}
+
+// auditLoggerName is the logger of the changes made through the admin endpoints
+const auditLoggerName = "audit"
+
+// DEFAULT_DRAIN_TIMEOUT caps the drain of a blue/green switch
+const DEFAULT_DRAIN_TIMEOUT = 30 * time.Second
+
+// PatternDiscovery holds the configured url patterns and the discoverer of an application
+type PatternDiscovery struct {
+	Configured []string
+	Discoverer *utils.Discoverer
+}
+
+// NewAdmin creates an admin controller serving the metrics of the given registry,
+// discovery holds the discoverer of every application and is nil when pattern
+// discovery is disabled, diffs is nil when no shadow response is compared
+func NewAdmin(registry *prometheus.Registry, discovery map[string]PatternDiscovery, diffs *shadow.DiffLog, analyzers *canary.Analyzers, splits *route.Splits) AdminController {
+	return &adminController{
+		discovery: discovery,
+		diffs:     diffs,
+		analyzers: analyzers,
+		splits:    splits,
+		// OpenMetrics is required for Prometheus to scrape exemplars
+		metricsHandler: promhttp.InstrumentMetricHandler(
+			registry,
+			promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+				EnableOpenMetrics: true,
+				Registry:          registry,
+			}),
+		),
+	}
+}
+
+type adminController struct {
+	discovery      map[string]PatternDiscovery
+	diffs          *shadow.DiffLog
+	analyzers      *canary.Analyzers
+	splits         *route.Splits
+	metricsHandler http.Handler
+}
+
+func (a *adminController) Metrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.metricsHandler.ServeHTTP(w, r)
+	}
+}
+
+// Patterns returns the URL patterns suggested by the discoverer of an
+// application, the application query parameter may be omitted when there is one
+func (a *adminController) Patterns() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if a.discovery == nil {
+			http.Error(w, "pattern discovery is disabled, enable it with --pattern-discovery", http.StatusNotFound)
+			return
+		}
+		application := r.URL.Query().Get("application")
+		if application == "" && len(a.discovery) == 1 {
+			for name := range a.discovery {
+				application = name
+			}
+		}
+		discovery, ok := a.discovery[application]
+		if !ok {
+			applications := make([]string, 0, len(a.discovery))
+			for name := range a.discovery {
+				applications = append(applications, name)
+			}
+			sort.Strings(applications)
+			http.Error(w, fmt.Sprintf("unknown application %q, expected one of %s", application, strings.Join(applications, ", ")), http.StatusBadRequest)
+			return
+		}
+		suggested := discovery.Discoverer.Suggest()
+		urlPatterns := append([]string{}, discovery.Configured...)
+		for _, suggestion := range suggested {
+			urlPatterns = append(urlPatterns, suggestion.Pattern)
+		}
+		writeJSON(w, http.StatusOK, domain.PatternSuggestions{
+			Application: application,
+			Configured:  discovery.Configured,
+			Suggested:   suggested,
+			UrlPatterns: strings.Join(urlPatterns, ","),
+		})
+	}
+}
+
+// LogLevel returns the log levels on GET, overrides the level of a logger on
+// PUT and removes the override of the logger query parameter on DELETE
+func (a *adminController) LogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var request domain.LogLevelRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "invalid log level request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			level, err := log.ParseLevel(request.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var ttl time.Duration
+			if request.TTL != "" {
+				if ttl, err = time.ParseDuration(request.TTL); err != nil || ttl <= 0 {
+					http.Error(w, fmt.Sprintf("invalid ttl %q, expected a positive duration such as 10m", request.TTL), http.StatusBadRequest)
+					return
+				}
+			}
+			if request.Logger == "" {
+				request.Logger = log.ROOT_LOGGER
+			}
+			log.SetLevel(request.Logger, level, ttl)
+			log.Named(loggerName).Info("log level changed", zap.String("logger", request.Logger), zap.String("level", level.String()), zap.Duration("ttl", ttl))
+		case http.MethodDelete:
+			logger := r.URL.Query().Get("logger")
+			if logger == "" {
+				logger = log.ROOT_LOGGER
+			}
+			if !log.ResetLevel(logger) {
+				http.Error(w, fmt.Sprintf("logger %q has no level override", logger), http.StatusNotFound)
+				return
+			}
+			log.Named(loggerName).Info("log level reset", zap.String("logger", logger))
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, log.Levels())
+	}
+}
+
+// ShadowDiffs returns the latest mismatches of the shadow responses on GET,
+// filtered by the application and route query parameters and capped by
+// limit, and removes them on DELETE
+func (a *adminController) ShadowDiffs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.diffs == nil {
+			http.Error(w, "shadow comparison is disabled, enable it with --shadow-compare", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			limit := 0
+			if value := query.Get("limit"); value != "" {
+				var err error
+				if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+					http.Error(w, fmt.Sprintf("invalid limit %q, expected a positive number", value), http.StatusBadRequest)
+					return
+				}
+			}
+			writeJSON(w, http.StatusOK, a.diffs.List(query.Get("application"), query.Get("route"), limit))
+		case http.MethodDelete:
+			a.diffs.Clear()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Canary returns the state of the canary analyses on GET and applies an
+// action, pause, resume, promote or abort, to the analysis of a route on POST
+func (a *adminController) Canary() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.analyzers.Len() == 0 {
+			http.Error(w, "no route has a canary analysis, declare one in the split of a route", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, a.analyzers.States())
+		case http.MethodPost:
+			var request domain.CanaryRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "invalid canary request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			analyzer, err := a.analyzers.Get(request.Application, request.Route)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			state, err := analyzer.Control(request.Action)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeJSON(w, http.StatusOK, state)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// BlueGreen returns the split routes on GET and switches the active upstream
+// group of a route on POST, new requests go to the group at once. The switch,
+// or the reason it was rejected, is written to the audit log.
+func (a *adminController) BlueGreen() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.splits.Len() == 0 {
+			http.Error(w, "no route has upstream groups, declare them in the split of a route", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, a.splits.States())
+			return
+		case http.MethodPost:
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var request domain.SwitchRequest
+		reject := func(statusCode int, message string) {
+			log.Named(auditLoggerName).Warn("upstream group switch rejected",
+				zap.String("application", request.Application),
+				zap.String("route", request.Route),
+				zap.String("to", request.Group),
+				zap.String("reason", request.Reason),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.Int("status_code", statusCode),
+				zap.String("error", message),
+			)
+			http.Error(w, message, statusCode)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			reject(http.StatusBadRequest, "invalid switch request: "+err.Error())
+			return
+		}
+		drainTimeout := DEFAULT_DRAIN_TIMEOUT
+		if request.DrainTimeout != "" {
+			var err error
+			if drainTimeout, err = time.ParseDuration(request.DrainTimeout); err != nil || drainTimeout <= 0 {
+				reject(http.StatusBadRequest, fmt.Sprintf("invalid drain_timeout %q, expected a positive duration such as 30s", request.DrainTimeout))
+				return
+			}
+		}
+		split, err := a.splits.Get(request.Application, request.Route)
+		if err != nil {
+			reject(http.StatusNotFound, err.Error())
+			return
+		}
+		request.Application = split.Application
+		previous, err := a.splits.Switch(split, request.Group)
+		if errors.Is(err, canary.ErrRunning) {
+			reject(http.StatusConflict, fmt.Sprintf("route %q has a running canary analysis, pause or abort it first", split.Name))
+			return
+		}
+		if err != nil {
+			reject(http.StatusBadRequest, err.Error())
+			return
+		}
+		response := domain.SwitchResponse{
+			Application: split.Application,
+			Route:       split.Name,
+			Previous:    previous,
+			Active:      request.Group,
+		}
+		if request.Drain {
+			ctx, cancel := context.WithTimeout(r.Context(), drainTimeout)
+			for _, group := range split.Split.Groups() {
+				if group.Name != request.Group {
+					response.InFlight += split.Split.Drain(ctx, group.Name)
+				}
+			}
+			cancel()
+			response.Drained = response.InFlight == 0
+		}
+		log.Named(auditLoggerName).Info("upstream group switched",
+			zap.String("application", split.Application),
+			zap.String("route", split.Name),
+			zap.String("from", previous),
+			zap.String("to", request.Group),
+			zap.String("reason", request.Reason),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.Bool("drain", request.Drain),
+			zap.Bool("drained", response.Drained),
+			zap.Int64("in_flight", response.InFlight),
+		)
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Named(loggerName).Error("failed to write admin response", zap.Error(err))
+	}
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"text/template"
+
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+)
+
+// HeaderTemplates renders request headers sent to the upstream from the matched route
+type HeaderTemplates map[string]*template.Template
+
+// ParseHeaderTemplates parses header values written as go templates, the data is route.TemplateData
+func ParseHeaderTemplates(headers map[string]string) (HeaderTemplates, error) {
+	templates := make(HeaderTemplates, len(headers))
+	for name, value := range headers {
+		tmpl, err := route.ParseTemplate(name, value)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid template of upstream header %s", name), err)
+		}
+		templates[http.CanonicalHeaderKey(name)] = tmpl
+	}
+	return templates, nil
+}
+
+// apply sets the rendered headers on the request, empty values are skipped
+func (h HeaderTemplates) apply(r *http.Request) {
+	if len(h) == 0 {
+		return
+	}
+	data := route.NewTemplateData(r)
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		if value, ok := route.RenderTemplate(r.Context(), &buf, h[name], data); ok {
+			r.Header.Set(name, value)
+		}
+	}
+}
+
+// HeaderScrubbing removes headers the upstream and the clients should not see
+type HeaderScrubbing struct {
+	// HopByHop are removed from requests and responses on top of the
+	// standard hop-by-hop headers the reverse proxy always removes
+	HopByHop []string
+	// Server removes the Server and X-Powered-By response headers
+	Server bool
+}
+
+func (s HeaderScrubbing) scrubRequest(r *http.Request) {
+	for _, name := range s.HopByHop {
+		r.Header.Del(name)
+	}
+}
+
+func (s HeaderScrubbing) scrubResponse(resp *http.Response) {
+	for _, name := range s.HopByHop {
+		resp.Header.Del(name)
+	}
+	if s.Server {
+		resp.Header.Del("Server")
+		resp.Header.Del("X-Powered-By")
+	}
+}
@@ -4,47 +4,218 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync/atomic"
+	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"github.com/tae2089/reverse-proxy/internal/shadow"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+// loggerName is the logger of the controllers, its level can be changed on /admin/log/level
+const loggerName = "controller"
+
 type ProxyController interface {
-	Metrics() func(http.ResponseWriter, *http.Request)
 	ProxyRequestHandler() http.HandlerFunc
 }
 
-func New(targetHost string) ProxyController {
-	proxy := newProxy(targetHost)
+// New creates a proxy controller spreading requests round-robin over the target hosts,
+// or over the upstream groups of split routes. mirror may be nil when no shadow
+// upstream is configured.
+func New(targetHosts []string, upstreamHeaders HeaderTemplates, scrubbing HeaderScrubbing, routes *route.Table, mirror *shadow.Mirror) ProxyController {
 	ctrl := &proxyController{
-		reverseProxy: proxy,
+		routes:          routes,
+		upstreamHeaders: upstreamHeaders,
+		scrubbing:       scrubbing,
+		mirror:          mirror,
+		groups:          make(map[string]map[string]*pool),
+	}
+	ctrl.upstreams = ctrl.newPool(targetHosts)
+	for _, rt := range routes.Routes() {
+		if rt.Split == nil {
+			continue
+		}
+		groups := make(map[string]*pool, len(rt.Split.Groups()))
+		for _, group := range rt.Split.Groups() {
+			groups[group.Name] = ctrl.newPool(group.TargetHosts)
+		}
+		ctrl.groups[rt.Name] = groups
 	}
 	return ctrl
 }
 
-type proxyController struct {
+type upstream struct {
+	address      string
 	reverseProxy *httputil.ReverseProxy
 }
 
-func (p *proxyController) ProxyRequestHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		p.reverseProxy.ServeHTTP(w, r)
+// pool spreads requests round-robin over its upstreams
+type pool struct {
+	upstreams []upstream
+	next      atomic.Uint64
+}
+
+func (p *proxyController) newPool(targetHosts []string) *pool {
+	upstreams := &pool{}
+	for _, targetHost := range targetHosts {
+		proxy, target := newProxy(targetHost)
+		proxy.ModifyResponse = p.modifyResponse
+		proxy.ErrorHandler = p.errorHandler
+		upstreams.upstreams = append(upstreams.upstreams, upstream{address: target.Host, reverseProxy: proxy})
 	}
+	return upstreams
+}
+
+type proxyController struct {
+	upstreams *pool
+	// groups holds the upstream groups of the split routes by route and group name
+	groups          map[string]map[string]*pool
+	upstreamHeaders HeaderTemplates
+	scrubbing       HeaderScrubbing
+	routes          *route.Table
+	mirror          *shadow.Mirror
 }
 
-func (p *proxyController) Metrics() func(http.ResponseWriter, *http.Request) {
+func (p *proxyController) ProxyRequestHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
+		p.scrubbing.scrubRequest(r)
+		p.upstreamHeaders.apply(r)
+		rt, match, routed := p.route(r)
+		if routed {
+			rt.ApplyRequestHeaders(r)
+			r = rt.RewriteRequest(r, match.Params)
+		}
+		p.mirrorRequest(r, rt, match)
+		upstreams, variant, reason, done := p.pool(r, rt)
+		// the group is drained by the blue/green switch once no request is in flight
+		defer done()
+		target := upstreams.upstream()
+		if variant != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("upstream.variant", variant))
+		}
+		if info, ok := domain.UpstreamInfoFromContext(r.Context()); ok {
+			info.Address = target.address
+			info.Variant = variant
+			info.Overridden = reason == route.SELECTED_BY_OVERRIDE
+			info.Start = time.Now()
 		}
-		promhttp.Handler().ServeHTTP(w, r)
+		target.reverseProxy.ServeHTTP(w, r)
+	}
+}
+
+// mirrorRequest sends a copy of the outgoing request to the shadow upstream
+func (p *proxyController) mirrorRequest(r *http.Request, rt *route.Route, match *domain.RouteMatch) {
+	if p.mirror == nil {
+		return
+	}
+	var percent *float64
+	if rt != nil {
+		percent = rt.Shadow.Percent
+	}
+	if !p.mirror.Sample(percent) {
+		return
+	}
+	name := ""
+	if match != nil {
+		name = match.Name
+	}
+	if comparison := p.mirror.Send(r, name); comparison != nil {
+		*r = *r.WithContext(shadow.WithComparison(r.Context(), comparison))
+	}
+}
+
+// pool returns the upstreams of the request, the upstream group selected for
+// split routes and the reason it was selected, the target hosts otherwise.
+// The returned func ends the request in flight to the group.
+func (p *proxyController) pool(r *http.Request, rt *route.Route) (*pool, string, string, func()) {
+	if rt == nil || rt.Split == nil {
+		return p.upstreams, "", "", func() {}
+	}
+	variant, reason, done := rt.Split.Select(r, rt.Name)
+	log.FromContext(r.Context()).Named(loggerName).Debug("upstream group selected", zap.String("route", rt.Name), zap.String("variant", variant), zap.String("reason", reason))
+	return p.groups[rt.Name][variant], variant, reason, done
+}
+
+// upstream returns the next target host
+func (p *pool) upstream() upstream {
+	if len(p.upstreams) == 1 {
+		return p.upstreams[0]
+	}
+	return p.upstreams[(p.next.Add(1)-1)%uint64(len(p.upstreams))]
+}
+
+// recordLatency stores the time the upstream took to answer and hands it to
+// the observer of split routes, unless the client forced the group
+func (p *proxyController) recordLatency(r *http.Request, statusCode int) {
+	info, ok := domain.UpstreamInfoFromContext(r.Context())
+	if !ok {
+		return
+	}
+	info.Latency = time.Since(info.Start)
+	// an override, e.g. QA testing the canary, does not reflect its health
+	if info.Variant == "" || info.Overridden {
+		return
+	}
+	if rt, _, ok := p.route(r); ok && rt.Split != nil {
+		rt.Split.Observe(info.Variant, statusCode, info.Latency)
+	}
+}
+
+// route returns the configured route of the request, if any
+func (p *proxyController) route(r *http.Request) (*route.Route, *domain.RouteMatch, bool) {
+	match, ok := domain.RouteMatchFromContext(r.Context())
+	if !ok {
+		return nil, nil, false
+	}
+	rt, ok := p.routes.Get(match.Name)
+	return rt, match, ok
+}
+
+// modifyResponse scrubs the upstream headers, applies the response header rules
+// and maps redirects and cookies of the upstream back to the public path
+func (p *proxyController) modifyResponse(resp *http.Response) error {
+	p.recordLatency(resp.Request, resp.StatusCode)
+	// the shadow response is compared with the response of the upstream as is
+	if comparison, ok := shadow.ComparisonFromContext(resp.Request.Context()); ok {
+		comparison.Primary(resp)
+	}
+	p.scrubbing.scrubResponse(resp)
+	rt, _, ok := p.route(resp.Request)
+	if !ok {
+		return nil
+	}
+	rt.ApplyResponseHeaders(resp)
+	incoming := resp.Request
+	scheme := "http"
+	if incoming.TLS != nil {
+		scheme = "https"
+	}
+	if proto := incoming.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	// the outgoing request keeps the Host header of the client
+	rt.RewriteResponse(resp, scheme, incoming.Host, incoming.URL)
+	return nil
+}
+
+// errorHandler logs upstream errors with the logger of the request
+func (p *proxyController) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	p.recordLatency(r, http.StatusBadGateway)
+	if comparison, ok := shadow.ComparisonFromContext(r.Context()); ok {
+		comparison.Abort()
 	}
+	log.FromContext(r.Context()).Named(loggerName).Error("upstream request failed", zap.String("upstream", r.URL.Host), zap.Error(err))
+	w.WriteHeader(http.StatusBadGateway)
 }
 
-func newProxy(targetHost string) *httputil.ReverseProxy {
+func newProxy(targetHost string) (*httputil.ReverseProxy, *url.URL) {
 	url, err := url.Parse(targetHost)
 	if err != nil {
 		panic(err)
 	}
-	return httputil.NewSingleHostReverseProxy(url)
+	return httputil.NewSingleHostReverseProxy(url), url
 }
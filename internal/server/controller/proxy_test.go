@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+)
+
+type observer struct {
+	variants []string
+}
+
+func (o *observer) Observe(variant string, statusCode int, latency time.Duration) {
+	o.variants = append(o.variants, variant)
+}
+
+func TestOverrideNotObserved(t *testing.T) {
+	stable := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer stable.Close()
+	canary := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer canary.Close()
+
+	routes, err := route.NewTable([]route.Config{{
+		Name:    "users",
+		Pattern: "/users",
+		Split: route.SplitConfig{
+			Groups: []route.GroupConfig{
+				{Name: "stable", TargetHosts: []string{stable.URL}, Weight: 100},
+				{Name: "canary", TargetHosts: []string{canary.URL}},
+			},
+			Override: route.OverrideConfig{Header: "X-Variant"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := routes.Get("users")
+	observed := &observer{}
+	rt.Split.SetObserver(observed)
+	handler := New([]string{stable.URL}, nil, HeaderScrubbing{}, routes, nil).ProxyRequestHandler()
+
+	serve := func(variant string) *domain.UpstreamInfo {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		if variant != "" {
+			r.Header.Set("X-Variant", variant)
+		}
+		info := &domain.UpstreamInfo{}
+		ctx := domain.WithRouteMatch(r.Context(), &domain.RouteMatch{Name: "users", Pattern: "/users"})
+		handler(httptest.NewRecorder(), r.WithContext(domain.WithUpstreamInfo(ctx, info)))
+		return info
+	}
+	if info := serve("canary"); info.Variant != "canary" || !info.Overridden {
+		t.Errorf("overridden request = %+v", info)
+	}
+	if info := serve(""); info.Variant != "stable" || info.Overridden {
+		t.Errorf("weighted request = %+v", info)
+	}
+	if len(observed.variants) != 1 || observed.variants[0] != "stable" {
+		t.Errorf("observed variants = %v, want only the weighted request", observed.variants)
+	}
+}
@@ -0,0 +1,57 @@
+package domain
+
+import "github.com/tae2089/reverse-proxy/internal/utils"
+
+// PatternSuggestions is the response of the pattern discovery admin endpoint
+type PatternSuggestions struct {
+	Application string                    `json:"application"`
+	Configured  []string                  `json:"configured"`
+	Suggested   []utils.PatternSuggestion `json:"suggested"`
+	// UrlPatterns is a ready to use value of --url-patterns
+	UrlPatterns string `json:"url_patterns"`
+}
+
+// LogLevelRequest changes the level of a logger on the log level admin endpoint
+type LogLevelRequest struct {
+	// Logger is a logger name such as middleware, root when empty
+	Logger string `json:"logger"`
+	Level  string `json:"level"`
+	// TTL reverts the level after a duration such as 10m, the level is kept when empty
+	TTL string `json:"ttl"`
+}
+
+// CanaryRequest applies an action to the canary analysis of a route
+type CanaryRequest struct {
+	// Application is required when several virtual hosts have a route of that name
+	Application string `json:"application"`
+	Route       string `json:"route"`
+	// Action is one of pause, resume, promote or abort
+	Action string `json:"action"`
+}
+
+// SwitchRequest switches the active upstream group of a split route
+type SwitchRequest struct {
+	// Application is required when several virtual hosts have a route of that name
+	Application string `json:"application"`
+	Route       string `json:"route"`
+	// Group becomes the active group, e.g. green
+	Group string `json:"group"`
+	// Drain waits until the other groups have no request in flight
+	Drain bool `json:"drain"`
+	// DrainTimeout caps the drain, e.g. 30s, 30s when empty
+	DrainTimeout string `json:"drain_timeout"`
+	// Reason is written to the audit log
+	Reason string `json:"reason"`
+}
+
+// SwitchResponse is the outcome of a switch of the active upstream group
+type SwitchResponse struct {
+	Application string `json:"application"`
+	Route       string `json:"route"`
+	// Previous is the group that was active, empty when several groups had a weight
+	Previous string `json:"previous"`
+	Active   string `json:"active"`
+	// Drained is set when the drain completed, InFlight are the requests left otherwise
+	Drained  bool  `json:"drained"`
+	InFlight int64 `json:"in_flight"`
+}
@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorResponse is the body of the errors returned by the proxy itself
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+	Method  string `json:"method,omitempty"`
+	Path    string `json:"path,omitempty"`
+	// Rule and Field locate a validation error, e.g. query_param and limit
+	Rule  string `json:"rule,omitempty"`
+	Field string `json:"field,omitempty"`
+}
+
+// WriteError writes an ErrorResponse as json
+func WriteError(w http.ResponseWriter, statusCode int, body ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
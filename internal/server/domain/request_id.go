@@ -0,0 +1,16 @@
+package domain
+
+import "context"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx holding the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
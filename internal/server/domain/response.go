@@ -8,20 +8,52 @@ import (
 
 type ResponseCapture struct {
 	http.ResponseWriter
-	body       *bytes.Buffer
-	statusCode int
+	body        *bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	// finalHeader replaces the headers of the handler when the status is written
+	finalHeader http.Header
 }
 
 func (r *ResponseCapture) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	for name, values := range r.finalHeader {
+		r.Header()[name] = values
+	}
 	r.statusCode = statusCode
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (r *ResponseCapture) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
 	r.body.Write(b)
 	return r.ResponseWriter.Write(b)
 }
 
+// SetHeaderOnWrite sets a header when the status is written, overriding the
+// value set by the handler, e.g. a header echoed by the upstream
+func (r *ResponseCapture) SetHeaderOnWrite(name, value string) {
+	if r.finalHeader == nil {
+		r.finalHeader = make(http.Header)
+	}
+	r.finalHeader.Set(name, value)
+}
+
+// StatusCode returns the status written by the handler
+func (r *ResponseCapture) StatusCode() int {
+	return r.statusCode
+}
+
+// Body returns the bytes written by the handler without consuming them
+func (r *ResponseCapture) Body() []byte {
+	return r.body.Bytes()
+}
+
 func NewResponseCapture(w http.ResponseWriter) *ResponseCapture {
 	return &ResponseCapture{
 		ResponseWriter: w,
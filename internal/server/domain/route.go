@@ -0,0 +1,38 @@
+package domain
+
+import "context"
+
+type routeMatchKey struct{}
+
+const (
+	// ROUTE_SOURCE_PATTERN is a route matched by --url-patterns
+	ROUTE_SOURCE_PATTERN = "pattern"
+	// ROUTE_SOURCE_OPENAPI is a route matched by the OpenAPI document
+	ROUTE_SOURCE_OPENAPI = "openapi"
+	// ROUTE_SOURCE_ROUTE is a route of the routes section of the config file
+	ROUTE_SOURCE_ROUTE = "route"
+)
+
+// RouteMatch is the route resolved for a request at the start of the middleware chain
+type RouteMatch struct {
+	// Pattern is the matched pattern or path template, e.g. /users/{id:int}
+	Pattern string
+	// Name is the name of configured routes, the operationId of OpenAPI routes
+	// and the pattern otherwise
+	Name string
+	// Params holds the values of the path variables by name
+	Params map[string]string
+	Method string
+	Source string
+}
+
+// WithRouteMatch returns a copy of ctx holding the route match
+func WithRouteMatch(ctx context.Context, match *RouteMatch) context.Context {
+	return context.WithValue(ctx, routeMatchKey{}, match)
+}
+
+// RouteMatchFromContext returns the route match of the request, ok is false if no route matched
+func RouteMatchFromContext(ctx context.Context) (*RouteMatch, bool) {
+	match, ok := ctx.Value(routeMatchKey{}).(*RouteMatch)
+	return match, ok && match != nil
+}
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type upstreamInfoKey struct{}
+
+// UpstreamInfo is filled by the proxy controller while the request is proxied
+type UpstreamInfo struct {
+	// Address is the host of the upstream the request was sent to
+	Address string
+	// Variant is the upstream group of a split route, empty otherwise
+	Variant string
+	// Overridden is set when the client forced the variant, the response is
+	// not observed by the canary analysis
+	Overridden bool
+	Start      time.Time
+	// Latency is the time until the upstream response headers were received
+	Latency time.Duration
+}
+
+// WithUpstreamInfo returns a copy of ctx holding the upstream info to fill
+func WithUpstreamInfo(ctx context.Context, info *UpstreamInfo) context.Context {
+	return context.WithValue(ctx, upstreamInfoKey{}, info)
+}
+
+// UpstreamInfoFromContext returns the upstream info of the request
+func UpstreamInfoFromContext(ctx context.Context) (*UpstreamInfo, bool) {
+	info, ok := ctx.Value(upstreamInfoKey{}).(*UpstreamInfo)
+	return info, ok && info != nil
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/accesslog"
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"go.uber.org/zap/zapcore"
+)
+
+// countingBody counts the bytes of the request body read by the proxy
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// shouldLog applies the access log settings of the route
+func (m *otelMiddleware) shouldLog(r *http.Request, status int) bool {
+	var ratio *float64
+	if match, ok := domain.RouteMatchFromContext(r.Context()); ok {
+		if rt, ok := m.Routes.Get(match.Name); ok {
+			if rt.AccessLog.Disabled {
+				return false
+			}
+			ratio = rt.AccessLog.SampleRatio
+		}
+	}
+	return m.AccessLog.Sample(ratio, status)
+}
+
+func newAccessLogEntry(r *http.Request, rec *domain.ResponseCapture, duration time.Duration, bytesIn int64, upstream *domain.UpstreamInfo) *accesslog.Entry {
+	path := r.URL.Path
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	entry := &accesslog.Entry{
+		Time:            time.Now(),
+		RequestID:       domain.RequestIDFromContext(r.Context()),
+		Trace:           traceFields(r),
+		RemoteAddr:      r.RemoteAddr,
+		Method:          r.Method,
+		Path:            path,
+		Proto:           r.Proto,
+		Host:            r.Host,
+		Status:          rec.StatusCode(),
+		Duration:        duration,
+		BytesIn:         bytesIn,
+		BytesOut:        int64(len(rec.Body())),
+		UserAgent:       r.UserAgent(),
+		Referer:         r.Referer(),
+		Upstream:        upstream.Address,
+		Variant:         upstream.Variant,
+		UpstreamLatency: upstream.Latency,
+	}
+	if r.TLS != nil {
+		entry.TLSVersion = tls.VersionName(r.TLS.Version)
+		entry.TLSCipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+		entry.TLSServerName = r.TLS.ServerName
+	}
+	if match, ok := domain.RouteMatchFromContext(r.Context()); ok {
+		entry.Route = match.Name
+		entry.Params = match.Params
+	}
+	return entry
+}
+
+// traceFields converts the trace fields of the request logger into access log fields
+func traceFields(r *http.Request) []accesslog.Field {
+	var fields []accesslog.Field
+	for _, field := range log.TraceFields(r.Context()) {
+		enc := zapcore.NewMapObjectEncoder()
+		field.AddTo(enc)
+		fields = append(fields, accesslog.Field{Key: field.Key, Value: enc.Fields[field.Key]})
+	}
+	return fields
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tae2089/reverse-proxy/internal/accesslog"
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+)
+
+func TestLoggingMiddlewareRoutes(t *testing.T) {
+	never := 0.0
+	routes, err := route.NewTable([]route.Config{
+		{Name: "health", Pattern: "/health", AccessLog: route.AccessLogConfig{Disabled: true}},
+		{Name: "ping", Pattern: "/ping", AccessLog: route.AccessLogConfig{SampleRatio: &never}},
+		{Name: "users", Pattern: "/users/{id}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := accesslog.New(accesslog.Config{Output: output, SampleRatio: 1}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accessLog.Close()
+	handler := chain(Config{
+		ApplicationName: "app",
+		Collectors:      NewCollectors(prometheus.NewRegistry(), MetricsConfig{}),
+		Routes:          routes,
+		AccessLog:       accessLog,
+		Redactor:        redact.Default(),
+	}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	for _, target := range []string{"/health", "/health?fail=1", "/ping", "/ping?fail=1", "/users/42", "/unrouted"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("access log line %q: %v", line, err)
+		}
+		logged = append(logged, entry.Path)
+	}
+	// a disabled route is never logged, a sampled out route only logs its server errors
+	if expected := []string{"/ping?fail=1", "/users/42", "/unrouted"}; strings.Join(logged, " ") != strings.Join(expected, " ") {
+		t.Errorf("logged %v, want %v", logged, expected)
+	}
+}
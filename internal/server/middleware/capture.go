@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/capture"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+)
+
+// CaptureMiddleware records the request and response bodies of the requests
+// selected by the capture settings to the capture sink
+func (m *otelMiddleware) CaptureMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		forced := m.Capture.Forced(r)
+		match, matched := domain.RouteMatchFromContext(r.Context())
+		routeName := ""
+		if matched {
+			routeName = match.Name
+		}
+		if !forced && !m.Capture.MatchesRoute(routeName) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// the headers of the client, before the upstream headers are applied
+		requestHeader := r.Header.Clone()
+		body := m.Capture.NewBody(r.Body)
+		r.Body = body
+		h.ServeHTTP(w, r)
+		rec := r.Context().Value("rec").(*domain.ResponseCapture)
+		reason, ok := m.Capture.Decide(forced, rec.StatusCode())
+		if !ok {
+			return
+		}
+		url := r.URL.Path
+		if r.URL.RawQuery != "" {
+			url += "?" + r.URL.RawQuery
+		}
+		record := &capture.Record{
+			Time:      time.Now(),
+			Reason:    reason,
+			RequestID: domain.RequestIDFromContext(r.Context()),
+			Route:     routeName,
+			Method:    r.Method,
+			URL:       url,
+			Status:    rec.StatusCode(),
+			// set by the timer middleware further down the chain
+			ResponseTime: r.Context().Value("latency").(time.Duration).Seconds(),
+			Request:      m.Capture.RequestMessage(requestHeader, body),
+			Response:     m.Capture.ResponseMessage(rec.Header(), rec.Body()),
+		}
+		if fields := traceFields(r); len(fields) > 0 {
+			record.Trace = make(map[string]any, len(fields))
+			for _, field := range fields {
+				record.Trace[field.Key] = field.Value
+			}
+		}
+		m.Capture.Record(record)
+	}
+}
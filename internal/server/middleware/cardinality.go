@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+)
+
+// OVERFLOW_LABEL_VALUE replaces label values once a metric reached its cap
+const OVERFLOW_LABEL_VALUE = "__overflow__"
+
+const (
+	COLLAPSE_REASON_PLACEHOLDER = "placeholder"
+	COLLAPSE_REASON_OVERFLOW    = "overflow"
+)
+
+// labelLimiter caps the number of distinct values of a label
+type labelLimiter struct {
+	max    int
+	mu     sync.RWMutex
+	values map[string]struct{}
+}
+
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{
+		max:    max,
+		values: make(map[string]struct{}),
+	}
+}
+
+// limit returns the value itself while the cap is not reached or the value
+// was already seen, and OVERFLOW_LABEL_VALUE otherwise.
+func (l *labelLimiter) limit(value string) (string, bool) {
+	if l.max <= 0 {
+		return value, true
+	}
+	l.mu.RLock()
+	_, seen := l.values[value]
+	size := len(l.values)
+	l.mu.RUnlock()
+	if seen {
+		return value, true
+	}
+	if size >= l.max {
+		return OVERFLOW_LABEL_VALUE, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.values) >= l.max {
+		return OVERFLOW_LABEL_VALUE, false
+	}
+	l.values[value] = struct{}{}
+	return value, true
+}
+
+// pathLabeler turns request paths into bounded path label values
+type pathLabeler struct {
+	collapseSegments bool
+	limiters         map[string]*labelLimiter
+	collapsedCounter *prometheus.CounterVec
+}
+
+func newPathLabeler(registerer prometheus.Registerer, cfg MetricsConfig, metricNames ...string) *pathLabeler {
+	limiters := make(map[string]*labelLimiter, len(metricNames))
+	for _, name := range metricNames {
+		limiters[name] = newLabelLimiter(cfg.MaxPathValues)
+	}
+	return &pathLabeler{
+		collapseSegments: cfg.CollapsePathSegments,
+		limiters:         limiters,
+		collapsedCounter: promauto.With(registerer).NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   cfg.Namespace,
+				Subsystem:   cfg.Subsystem,
+				Name:        "path_label_values_collapsed_total",
+				Help:        "Total count of path label values replaced by a placeholder or the overflow bucket",
+				ConstLabels: cfg.ConstLabels,
+			},
+			[]string{"metric", "reason"},
+		),
+	}
+}
+
+// label returns the bounded path label value of the given metric: high
+// cardinality segments are collapsed and values past the cap overflow.
+func (p *pathLabeler) label(metric, path string) string {
+	if p.collapseSegments {
+		collapsedPath, collapsed := utils.CollapsePath(path)
+		if collapsed > 0 {
+			p.collapsedCounter.WithLabelValues(metric, COLLAPSE_REASON_PLACEHOLDER).Add(float64(collapsed))
+		}
+		path = collapsedPath
+	}
+	limiter, ok := p.limiters[metric]
+	if !ok {
+		return path
+	}
+	value, ok := limiter.limit(path)
+	if !ok {
+		p.collapsedCounter.WithLabelValues(metric, COLLAPSE_REASON_OVERFLOW).Inc()
+	}
+	return value
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/capture"
+	"github.com/tae2089/reverse-proxy/internal/har"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+)
+
+// HARMiddleware records the requests of the selected routes and statuses to
+// HAR files, the upstream timings come from httptrace
+func (m *otelMiddleware) HARMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		routeName := ""
+		if match, ok := domain.RouteMatchFromContext(r.Context()); ok {
+			routeName = match.Name
+		}
+		if !m.HAR.MatchesRoute(routeName) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		timing := &har.Timing{}
+		requestHeader := r.Header.Clone()
+		body := capture.TeeBody(r.Body, m.HAR.TeeSize())
+		r.Body = body
+		*r = *r.WithContext(httptrace.WithClientTrace(r.Context(), timing.ClientTrace()))
+		h.ServeHTTP(w, r)
+		end := time.Now()
+		rec := r.Context().Value("rec").(*domain.ResponseCapture)
+		if !m.HAR.MatchesStatus(rec.StatusCode()) {
+			return
+		}
+		requestBody, requestBodySize := body.Bytes()
+		m.HAR.Record(m.HAR.NewEntry(&har.Exchange{
+			Start:           start,
+			End:             end,
+			Request:         r,
+			RequestHeader:   requestHeader,
+			RequestBody:     requestBody,
+			RequestBodySize: requestBodySize,
+			Status:          rec.StatusCode(),
+			ResponseHeader:  rec.Header(),
+			ResponseBody:    rec.Body(),
+			Timing:          timing,
+			Comment:         domain.RequestIDFromContext(r.Context()),
+		}))
+	}
+}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	METRICS_LABEL_HOST     = "host"
+	METRICS_LABEL_UPSTREAM = "upstream"
+	METRICS_LABEL_ROUTE    = "route"
+	// METRICS_LABEL_VARIANT is always set, empty unless the route splits its requests
+	METRICS_LABEL_VARIANT = "variant"
+)
+
+// MetricsConfig holds the naming, labels and buckets of the request metrics
+type MetricsConfig struct {
+	Namespace   string
+	Subsystem   string
+	ConstLabels map[string]string
+	// Buckets of the latency histogram, prometheus.DefBuckets when empty
+	Buckets []float64
+	// NativeHistogramBucketFactor enables native histograms when greater than 1
+	NativeHistogramBucketFactor float64
+	// ExtraLabels is any of host, upstream and route
+	ExtraLabels []string
+	// MaxPathValues caps the distinct path label values per metric, unlimited when 0
+	MaxPathValues int
+	// CollapsePathSegments replaces numeric, UUID, hex and hash-like segments with placeholders
+	CollapsePathSegments bool
+}
+
+// ValidateExtraLabels checks the names of the optional labels
+func ValidateExtraLabels(labels []string) error {
+	for _, label := range labels {
+		switch label {
+		case METRICS_LABEL_HOST, METRICS_LABEL_UPSTREAM, METRICS_LABEL_ROUTE:
+		default:
+			return fmt.Errorf("unknown metrics label %q, expected one of host, upstream, route", label)
+		}
+	}
+	return nil
+}
+
+// reservedLabels are the variable labels of the metrics sharing the constant
+// labels, a constant label of the same name is rejected by the registry
+var reservedLabels = []string{
+	"application", "path", "method", "status_code", METRICS_LABEL_VARIANT,
+	METRICS_LABEL_HOST, METRICS_LABEL_UPSTREAM, METRICS_LABEL_ROUTE,
+	// path label collapsing, OpenAPI validation, shadow, canary and blue/green metrics
+	"metric", "reason", "operation", "rule", "direction", "mode", "result",
+	"state", "decision", "group",
+	// histogram buckets and summary quantiles
+	"le", "quantile",
+}
+
+// ValidateConstLabels checks the names of the constant labels, application is
+// set from the application name of each virtual host
+func ValidateConstLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid constant label name %q, expected letters, digits and underscores not starting with a digit or __", name)
+		}
+		if slices.Contains(reservedLabels, name) {
+			return fmt.Errorf("%s can not be a constant label, the proxy metrics already have a label of that name", name)
+		}
+	}
+	return nil
+}
+
+// Collectors holds the metrics shared by the middlewares of every virtual host
+type Collectors struct {
+	requests   *requestMetrics
+	violations *prometheus.CounterVec
+}
+
+// NewCollectors registers the proxy metrics on the registerer
+func NewCollectors(registerer prometheus.Registerer, cfg MetricsConfig) *Collectors {
+	return &Collectors{
+		requests:   newRequestMetrics(registerer, cfg),
+		violations: newViolationsCounter(registerer, cfg),
+	}
+}
+
+// requestMetrics holds the collectors updated for every proxied request
+type requestMetrics struct {
+	gauge                *prometheus.GaugeVec
+	httpLatencyHistogram *prometheus.HistogramVec
+	httpRequestsCounter  *prometheus.CounterVec
+	pathLabeler          *pathLabeler
+	extraLabels          []string
+}
+
+// requestLabels holds the label values of a single request
+type requestLabels struct {
+	application string
+	path        string
+	method      string
+	host        string
+	route       string
+	upstream    string
+	variant     string
+}
+
+func newRequestMetrics(registerer prometheus.Registerer, cfg MetricsConfig) *requestMetrics {
+	factory := promauto.With(registerer)
+	var gauge *prometheus.GaugeVec = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   cfg.Namespace,
+		Subsystem:   cfg.Subsystem,
+		Name:        "total_connections",
+		Help:        "Total connections to the service",
+		ConstLabels: cfg.ConstLabels,
+	}, []string{"application"})
+
+	// Define a new Histogram metric
+	var httpLatencyHistogram *prometheus.HistogramVec = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   cfg.Namespace,
+			Subsystem:                   cfg.Subsystem,
+			Name:                        "http_request_latency",
+			Help:                        "Latency of HTTP requests",
+			ConstLabels:                 cfg.ConstLabels,
+			Buckets:                     cfg.Buckets,
+			NativeHistogramBucketFactor: cfg.NativeHistogramBucketFactor,
+		},
+		append([]string{"application", "path", "method", METRICS_LABEL_VARIANT}, cfg.ExtraLabels...),
+	)
+
+	var httpRequestsCounter *prometheus.CounterVec = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "api_requests",
+			Help:        "Total counte of HTTP requests by status code, path and method",
+			ConstLabels: cfg.ConstLabels,
+		},
+		append([]string{"application", "path", "method", "status_code", METRICS_LABEL_VARIANT}, cfg.ExtraLabels...),
+	)
+
+	return &requestMetrics{
+		gauge:                gauge,
+		httpLatencyHistogram: httpLatencyHistogram,
+		httpRequestsCounter:  httpRequestsCounter,
+		pathLabeler:          newPathLabeler(registerer, cfg, "http_request_latency", "api_requests"),
+		extraLabels:          cfg.ExtraLabels,
+	}
+}
+
+// extraLabelValues returns the values of the configured extra labels in order
+func (m *requestMetrics) extraLabelValues(labels requestLabels) []string {
+	values := make([]string, 0, len(m.extraLabels))
+	for _, label := range m.extraLabels {
+		switch label {
+		case METRICS_LABEL_HOST:
+			values = append(values, labels.host)
+		case METRICS_LABEL_UPSTREAM:
+			values = append(values, labels.upstream)
+		case METRICS_LABEL_ROUTE:
+			values = append(values, labels.route)
+		}
+	}
+	return values
+}
+
+func (m *requestMetrics) observeLatency(labels requestLabels, duration time.Duration, exemplar prometheus.Labels) {
+	path := m.pathLabeler.label("http_request_latency", labels.path)
+	values := append([]string{labels.application, path, labels.method, labels.variant}, m.extraLabelValues(labels)...)
+	observer := m.httpLatencyHistogram.WithLabelValues(values...)
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		exemplarObserver.ObserveWithExemplar(duration.Seconds(), exemplar)
+	} else {
+		observer.Observe(duration.Seconds())
+	}
+}
+
+func (m *requestMetrics) countStatusCode(labels requestLabels, statusCode int, exemplar prometheus.Labels) {
+	status := utils.StatusClass(statusCode)
+	path := m.pathLabeler.label("api_requests", labels.path)
+	values := append([]string{labels.application, path, labels.method, status, labels.variant}, m.extraLabelValues(labels)...)
+	counter := m.httpRequestsCounter.WithLabelValues(values...)
+	if exemplarAdder, ok := counter.(prometheus.ExemplarAdder); ok && exemplar != nil {
+		exemplarAdder.AddWithExemplar(1, exemplar)
+	} else {
+		counter.Inc()
+	}
+}
+
+// exemplarFromContext returns the trace ID of a sampled span as exemplar labels,
+// or nil when the request is not sampled.
+func exemplarFromContext(ctx context.Context) prometheus.Labels {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() || !spanCtx.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": spanCtx.TraceID().String()}
+}
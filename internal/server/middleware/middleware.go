@@ -1,6 +1,19 @@
 package middleware
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/tae2089/reverse-proxy/internal/accesslog"
+	"github.com/tae2089/reverse-proxy/internal/capture"
+	"github.com/tae2089/reverse-proxy/internal/har"
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// loggerName is the logger of the middlewares, its level can be changed on /admin/log/level
+const loggerName = "middleware"
 
 type MiddlewareFunc func(http.HandlerFunc) http.HandlerFunc
 
@@ -8,14 +21,42 @@ type Middleware interface {
 	GetMiddlewares() []MiddlewareFunc
 }
 
+// Config holds the settings shared by every middleware implementation
+type Config struct {
+	UrlPatternStr string
+	EnableMetrics bool
+	// IncomingTrace is one of observe.INCOMING_TRACE_ACCEPT, _IGNORE or _STRIP
+	IncomingTrace string
+	RequestID     RequestIDConfig
+	// ApplicationName is the value of the application label
+	ApplicationName string
+	// Collectors are shared by the middlewares of every virtual host
+	Collectors *Collectors
+	// Tracer starts the server spans, it carries the resource of the application
+	Tracer trace.Tracer
+	// Discoverer observes unmatched paths, pattern discovery is disabled when nil
+	Discoverer *utils.Discoverer
+	OpenAPI    OpenAPIConfig
+	// Routes are matched before the url patterns
+	Routes *route.Table
+	// AccessLog is shared by every virtual host, access logs are disabled when nil
+	AccessLog *accesslog.Logger
+	// Redactor masks sensitive values of logs and span attributes
+	Redactor *redact.Redactor
+	// Capture records request and response bodies, it is shared by every virtual host and disabled when nil
+	Capture *capture.Capturer
+	// HAR records traffic to HAR files, it is shared by every virtual host and disabled when nil
+	HAR *har.Recorder
+}
+
 // New creates a new middleware
-func New(mode, UrlPatternStr string, enableMetrics bool) Middleware {
+func New(mode string, cfg Config) Middleware {
 	var m Middleware
 	switch mode {
 	case "otel":
-		m = newOtelMiddleware(enableMetrics, UrlPatternStr)
+		m = newOtelMiddleware(cfg)
 	default:
-		m = newOtelMiddleware(enableMetrics, UrlPatternStr)
+		m = newOtelMiddleware(cfg)
 	}
 	return m
 }
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/openapi"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"go.uber.org/zap"
+)
+
+// OpenAPIConfig holds the OpenAPI document used for route matching
+type OpenAPIConfig struct {
+	Spec *openapi.Spec
+	// Label is openapi.LABEL_TEMPLATE or openapi.LABEL_OPERATION
+	Label string
+	// Undocumented is openapi.UNDOCUMENTED_ALLOW, _LOG or _REJECT
+	Undocumented string
+	Validation   openapi.ValidationConfig
+}
+
+func newViolationsCounter(registerer prometheus.Registerer, cfg MetricsConfig) *prometheus.CounterVec {
+	return promauto.With(registerer).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        "openapi_validation_violations_total",
+			Help:        "Total count of OpenAPI validation violations by operation, rule and direction",
+			ConstLabels: cfg.ConstLabels,
+		},
+		[]string{"operation", "rule", "direction", "mode"},
+	)
+}
+
+// ValidationMiddleware validates documented requests, and a sample of their responses, against the OpenAPI document
+func (m *otelMiddleware) ValidationMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, ok := m.routeOperation(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		mode := m.OpenAPI.Validation.ModeOf(op)
+		if mode == openapi.VALIDATION_OFF {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if violation := m.OpenAPI.Spec.ValidateRequest(r.Context(), r, op, m.OpenAPI.Validation.BodyLimit()); violation != nil {
+			m.reportViolation(r.Context(), op, mode, violation)
+			if mode == openapi.VALIDATION_ENFORCE {
+				domain.WriteError(w, http.StatusBadRequest, domain.ErrorResponse{
+					Error:   "request_validation_failed",
+					Message: violation.Message,
+					Method:  r.Method,
+					Path:    r.URL.Path,
+					Rule:    violation.Rule,
+					Field:   violation.Field,
+				})
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+		// the response is already sent, so response violations are only reported
+		rec, ok := w.(*domain.ResponseCapture)
+		if !ok || rand.Float64() >= m.OpenAPI.Validation.ResponseSampleRatio {
+			return
+		}
+		if violation := m.OpenAPI.Spec.ValidateResponse(r.Context(), r, op, rec.StatusCode(), rec.Header(), rec.Body(), m.OpenAPI.Validation.BodyLimit()); violation != nil {
+			m.reportViolation(r.Context(), op, mode, violation)
+		}
+	}
+}
+
+func (m *otelMiddleware) reportViolation(ctx context.Context, op openapi.Operation, mode string, violation *openapi.Violation) {
+	m.ViolationsCounter.WithLabelValues(op.Name(), violation.Rule, violation.Direction, mode).Inc()
+	log.FromContext(ctx).Named(loggerName).Warn("openapi validation violation",
+		zap.String("operation", op.Name()),
+		zap.String("direction", violation.Direction),
+		zap.String("rule", violation.Rule),
+		zap.String("field", violation.Field),
+		zap.String("mode", mode),
+		zap.String("error", violation.Message),
+	)
+}
+
+// UndocumentedMiddleware logs or rejects requests to paths missing from the OpenAPI document
+func (m *otelMiddleware) UndocumentedMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := m.routeOperation(r); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		switch m.OpenAPI.Undocumented {
+		case openapi.UNDOCUMENTED_REJECT:
+			domain.WriteError(w, http.StatusNotFound, domain.ErrorResponse{
+				Error:   "undocumented_path",
+				Message: "the path and method are not part of the API document",
+				Method:  r.Method,
+				Path:    r.URL.Path,
+			})
+			return
+		case openapi.UNDOCUMENTED_LOG:
+			log.FromContext(r.Context()).Named(loggerName).Warn("undocumented path", zap.String("method", r.Method), zap.String("path", r.URL.Path))
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// routeOperation returns the OpenAPI operation of the route resolved by
+// RouteMiddleware, a configured route is validated against the operation
+// documenting its path
+func (m *otelMiddleware) routeOperation(r *http.Request) (openapi.Operation, bool) {
+	route, ok := domain.RouteMatchFromContext(r.Context())
+	if !ok || m.OpenAPI.Spec == nil {
+		return openapi.Operation{}, false
+	}
+	switch route.Source {
+	case domain.ROUTE_SOURCE_OPENAPI:
+		return m.OpenAPI.Spec.Operation(route.Method, route.Pattern, route.Params)
+	case domain.ROUTE_SOURCE_ROUTE:
+		return m.OpenAPI.Spec.Match(r.Method, r.URL.Path)
+	}
+	return openapi.Operation{}, false
+}
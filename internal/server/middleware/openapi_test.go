@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tae2089/reverse-proxy/internal/openapi"
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+const violationsDocument = `
+openapi: 3.0.3
+info: {title: test, version: "1"}
+paths:
+  /users/{id}:
+    get:
+      operationId: getUser
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+      responses:
+        "200":
+          description: ok
+          content:
+            application/json:
+              schema:
+                type: object
+                required: [name]
+                properties:
+                  name: {type: string}
+`
+
+// chain wraps the handler with every middleware of the config, as the server does
+func chain(cfg Config, h http.HandlerFunc) http.HandlerFunc {
+	middlewares := New("otel", cfg).GetMiddlewares()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+func TestValidationViolations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(path, []byte(violationsDocument), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := openapi.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectors := NewCollectors(prometheus.NewRegistry(), MetricsConfig{})
+	for _, mode := range []string{openapi.VALIDATION_REPORT, openapi.VALIDATION_ENFORCE} {
+		handler := chain(Config{
+			ApplicationName: "app",
+			Collectors:      collectors,
+			Redactor:        redact.Default(),
+			OpenAPI: OpenAPIConfig{
+				Spec:       spec,
+				Validation: openapi.ValidationConfig{Mode: mode, ResponseSampleRatio: 1},
+			},
+		}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name":42}`))
+		})
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/users/abc", nil))
+		if expected := map[string]int{openapi.VALIDATION_REPORT: 200, openapi.VALIDATION_ENFORCE: 400}[mode]; w.Code != expected {
+			t.Errorf("%s: invalid request answered %d, want %d", mode, w.Code, expected)
+		}
+		if count := testutil.ToFloat64(collectors.violations.WithLabelValues("getUser", "path_param", openapi.DIRECTION_REQUEST, mode)); count != 1 {
+			t.Errorf("%s: request violations = %v", mode, count)
+		}
+
+		responses := collectors.violations.WithLabelValues("getUser", "response_body", openapi.DIRECTION_RESPONSE, mode)
+		before := testutil.ToFloat64(responses)
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+		if count := testutil.ToFloat64(responses) - before; count != 1 {
+			t.Errorf("%s: response violations = %v", mode, count)
+		}
+	}
+}
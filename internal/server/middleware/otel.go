@@ -7,9 +7,15 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tae2089/reverse-proxy/internal/accesslog"
+	"github.com/tae2089/reverse-proxy/internal/capture"
+	"github.com/tae2089/reverse-proxy/internal/har"
 	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/observe"
+	"github.com/tae2089/reverse-proxy/internal/openapi"
+	"github.com/tae2089/reverse-proxy/internal/redact"
 	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
 	"github.com/tae2089/reverse-proxy/internal/utils"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -20,23 +26,51 @@ import (
 
 type otelMiddleware struct {
 	IsEnabledMeasureLatency bool
-	Gauge                   prometheus.Gauge
 	Tracer                  trace.Tracer
 	Props                   propagation.TextMapPropagator
-	httpLatencyHistogram    *prometheus.HistogramVec
 	PatternTree             *utils.Tree
-	HttpRequestsCounter     *prometheus.CounterVec
+	// RouteTree holds the configured routes only, they are matched first
+	RouteTree         *utils.Tree
+	Metrics           *requestMetrics
+	ApplicationName   string
+	IncomingTrace     string
+	RequestID         RequestIDConfig
+	Discoverer        *utils.Discoverer
+	Routes            *route.Table
+	AccessLog         *accesslog.Logger
+	Redactor          *redact.Redactor
+	Capture           *capture.Capturer
+	HAR               *har.Recorder
+	OpenAPI           OpenAPIConfig
+	ViolationsCounter *prometheus.CounterVec
 }
 
 // GetMiddlewares implements Middleware.
 func (m *otelMiddleware) GetMiddlewares() []MiddlewareFunc {
-	return []MiddlewareFunc{
+	middlewares := []MiddlewareFunc{
 		m.SetUpMiddleware,
+		m.RequestIDMiddleware,
+		m.RouteMiddleware,
 		m.LoggingMiddleware,
+	}
+	if m.Capture != nil {
+		middlewares = append(middlewares, m.CaptureMiddleware)
+	}
+	if m.HAR != nil {
+		middlewares = append(middlewares, m.HARMiddleware)
+	}
+	middlewares = append(middlewares,
 		m.MetricsMiddleware,
 		m.TraceIDMiddleware,
 		m.TimerMiddleware,
+	)
+	if m.OpenAPI.Spec != nil && m.OpenAPI.Undocumented != openapi.UNDOCUMENTED_ALLOW {
+		middlewares = append(middlewares, m.UndocumentedMiddleware)
 	}
+	if m.OpenAPI.Spec != nil {
+		middlewares = append(middlewares, m.ValidationMiddleware)
+	}
+	return middlewares
 }
 
 func (m *otelMiddleware) SetUpMiddleware(h http.HandlerFunc) http.HandlerFunc {
@@ -48,20 +82,23 @@ func (m *otelMiddleware) SetUpMiddleware(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// LoggingMiddleware logs the start and end of a request
+// LoggingMiddleware writes the access log entry of a request
 func (m *otelMiddleware) LoggingMiddleware(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get ResponseCapture from context
 		rec := r.Context().Value("rec").(*domain.ResponseCapture)
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		upstream := &domain.UpstreamInfo{}
+		r = r.WithContext(domain.WithUpstreamInfo(r.Context(), upstream))
 		// Call the next handler
 		h.ServeHTTP(rec, r)
-		// Capture the response
-		response := rec.ToHttpResponse(r)
+		if m.AccessLog == nil || !m.shouldLog(r, rec.StatusCode()) {
+			return
+		}
 		// Get duration from context
 		duration := r.Context().Value("latency").(time.Duration)
-		//logging
-		loggingFields := log.GetLoggingFieldsByRequest(r, response.StatusCode, duration)
-		log.Info("service call", loggingFields...)
+		m.AccessLog.Log(newAccessLogEntry(r, rec, duration, body.n.Load(), upstream))
 	}
 }
 
@@ -86,31 +123,39 @@ func (m *otelMiddleware) MetricsMiddleware(h http.HandlerFunc) http.HandlerFunc
 		// If the measure latency is disabled, skip the measure
 		if !m.IsEnabledMeasureLatency {
 			h.ServeHTTP(w, r)
+			m.discoverPattern(r)
+			return
 		}
 		// Increment the total connections counter
-		m.Gauge.Inc()
+		gauge := m.Metrics.gauge.WithLabelValues(m.ApplicationName)
+		gauge.Inc()
 		h.ServeHTTP(w, r)
-		m.Gauge.Dec()
+		gauge.Dec()
 		// Get ResponseCapture from context
 		rec := r.Context().Value("rec").(*domain.ResponseCapture)
 		response := rec.ToHttpResponse(r)
 		// Get duration from context
 		duration := r.Context().Value("latency").(time.Duration)
-		m.measureRequest(r.URL.Path, r.Method, duration, response.StatusCode)
+		m.measureRequest(r, duration, response.StatusCode)
+		m.discoverPattern(r)
 	}
 }
 
 // TraceIDMiddleware adds a trace ID to the request context
 func (m *otelMiddleware) TraceIDMiddleware(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		//if the request has a traceparent header, extract the trace ID
+		// if the request carries trace headers from a trusted client, extract the trace ID
 		// if not, create a new trace ID
-		var targetCtx context.Context
-		if r.Header.Get("Traceparent") != "" {
-			log.Info("Get header", zap.Any("Header", r.Header))
-			targetCtx = m.Props.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
-		} else {
-			targetCtx = r.Context()
+		var targetCtx context.Context = r.Context()
+		switch m.IncomingTrace {
+		case observe.INCOMING_TRACE_STRIP:
+			m.stripTraceHeaders(r.Header)
+		case observe.INCOMING_TRACE_IGNORE:
+		default:
+			if m.hasTraceHeaders(r.Header) {
+				log.FromContext(r.Context()).Named(loggerName).Debug("incoming trace context", zap.Any("Header", m.Redactor.Header(r.Header)))
+				targetCtx = m.Props.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			}
 		}
 		// Create a new span with the trace ID
 		// and inject the span context into the request headers
@@ -122,6 +167,7 @@ func (m *otelMiddleware) TraceIDMiddleware(h http.HandlerFunc) http.HandlerFunc
 					Value: attribute.StringValue(r.Method),
 				},
 			),
+			trace.WithAttributes(m.routeAttributes(r)...),
 		)
 		m.Props.Inject(ctx, propagation.HeaderCarrier(r.Header))
 		// Update the request with the new context
@@ -131,74 +177,131 @@ func (m *otelMiddleware) TraceIDMiddleware(h http.HandlerFunc) http.HandlerFunc
 	}
 }
 
-func (m *otelMiddleware) measureRequest(path string, method string, duration time.Duration, statusCode int) {
-	var replacedPath string = m.measureLatency(path, method, duration)
-	m.countStatusCode(replacedPath, method, statusCode)
+// routeAttributes returns the span attributes of the matched route, sensitive params are masked
+func (m *otelMiddleware) routeAttributes(r *http.Request) []attribute.KeyValue {
+	route, ok := domain.RouteMatchFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	attributes := []attribute.KeyValue{
+		attribute.String("http.route", route.Pattern),
+		attribute.String("route.name", route.Name),
+	}
+	for name, value := range m.Redactor.Params(route.Params) {
+		attributes = append(attributes, attribute.String("route.param."+name, value))
+	}
+	return attributes
 }
 
-func (m *otelMiddleware) measureLatency(path string, method string, duration time.Duration) string {
-	var pathPattern string = m.PatternTree.Search(path)
-	var replacedPath string = m.PatternTree.ReplaceWithPattern(path, pathPattern)
-	m.httpLatencyHistogram.WithLabelValues(replacedPath, method).Observe(duration.Seconds())
-	return replacedPath
+// hasTraceHeaders reports whether the header carries any field of the configured propagators
+func (m *otelMiddleware) hasTraceHeaders(header http.Header) bool {
+	for _, field := range m.Props.Fields() {
+		if header.Get(field) != "" {
+			return true
+		}
+	}
+	return false
 }
 
-func (m *otelMiddleware) countStatusCode(path, method string, statusCode int) {
-	var status string
-	switch {
-	case statusCode >= 200 && statusCode < 300:
-		status = "2xx"
-	case statusCode >= 300 && statusCode < 400:
-		status = "3xx"
-	case statusCode >= 400 && statusCode < 500:
-		status = "4xx"
-	case statusCode >= 500 && statusCode < 600:
-		status = "5xx"
-	default:
-		status = "xxx"
-	}
-	m.HttpRequestsCounter.WithLabelValues(path, method, status).Inc()
+// stripTraceHeaders removes the fields of every supported propagator sent by
+// an untrusted client, the next hop may honor a format the proxy does not use
+func (m *otelMiddleware) stripTraceHeaders(header http.Header) {
+	for _, field := range observe.TraceHeaderFields() {
+		header.Del(field)
+	}
 }
 
-func newOtelMiddleware(enableMetrics bool, UrlPatternStr string) Middleware {
-	tracer := otel.GetTracerProvider().Tracer("reverse-proxy")
-	var gauge prometheus.Gauge = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "total_connections",
-		Help: "Total connections to the service",
-	})
-
-	// Define a new Histogram metric
-	var httpLatencyHistogram *prometheus.HistogramVec = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "http_request_latency",
-			Help:    "Latency of HTTP requests",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"path", "method"},
-	)
+func (m *otelMiddleware) measureRequest(r *http.Request, duration time.Duration, statusCode int) {
+	exemplar := exemplarFromContext(r.Context())
+	var replacedPath, route string
+	match, matched := domain.RouteMatchFromContext(r.Context())
+	if matched {
+		replacedPath, route = match.Pattern, match.Name
+		if match.Source == domain.ROUTE_SOURCE_OPENAPI && m.OpenAPI.Label == openapi.LABEL_OPERATION {
+			replacedPath = match.Name
+		}
+	} else {
+		// no route covers the whole path, label it with the longest matching prefix
+		prefix, _ := m.PatternTree.MatchPrefix(r.URL.Path)
+		replacedPath = m.PatternTree.ReplaceWithPattern(r.URL.Path, prefix)
+	}
+	labels := requestLabels{
+		application: m.ApplicationName,
+		path:        replacedPath,
+		method:      r.Method,
+		host:        r.Host,
+		route:       route,
+	}
+	// the upstream is empty for requests answered by the proxy, e.g. rejected by the validation
+	if info, ok := domain.UpstreamInfoFromContext(r.Context()); ok {
+		labels.upstream = info.Address
+		labels.variant = info.Variant
+	}
+	m.Metrics.observeLatency(labels, duration, exemplar)
+	m.Metrics.countStatusCode(labels, statusCode, exemplar)
+}
 
-	var httpRequestsCounter *prometheus.CounterVec = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "api_requests",
-			Help: "Total counte of HTTP requests by status code, path and method",
-		},
-		[]string{"path", "method", "status_code"},
-	)
+// discoverPattern feeds the paths not fully covered by a pattern to the discoverer.
+// Error responses are skipped so that scanners do not pollute the suggestions.
+func (m *otelMiddleware) discoverPattern(r *http.Request) {
+	if m.Discoverer == nil {
+		return
+	}
+	if _, matched := domain.RouteMatchFromContext(r.Context()); matched {
+		return
+	}
+	if rec := r.Context().Value("rec").(*domain.ResponseCapture); rec.StatusCode() >= 400 {
+		return
+	}
+	m.Discoverer.Observe(r.URL.Path)
+}
 
+func newOtelMiddleware(cfg Config) Middleware {
+	tracer := cfg.Tracer
+	if tracer == nil {
+		tracer = otel.GetTracerProvider().Tracer("reverse-proxy")
+	}
 	pattenrTree := utils.NewTree()
-	urlPatterns := strings.Split(UrlPatternStr, ",")
+	urlPatterns := strings.Split(cfg.UrlPatternStr, ",")
 	for _, pattern := range urlPatterns {
-		pattenrTree.Insert(pattern)
+		if pattern == "" {
+			continue
+		}
+		if err := pattenrTree.Insert(pattern); err != nil {
+			log.Named(loggerName).Error("invalid url pattern", zap.String("pattern", pattern), zap.Error(err))
+		}
+	}
+	if cfg.OpenAPI.Spec != nil {
+		if err := cfg.OpenAPI.Spec.FillTree(pattenrTree); err != nil {
+			log.Named(loggerName).Error("invalid openapi pattern", zap.Error(err))
+		}
+	}
+	// routes are inserted last so that their name wins over a plain url pattern
+	if err := cfg.Routes.FillTree(pattenrTree); err != nil {
+		log.Named(loggerName).Error("invalid route pattern", zap.Error(err))
 	}
+	// route patterns are validated with the routes
+	routeTree := utils.NewTree()
+	cfg.Routes.FillTree(routeTree)
 
 	m := &otelMiddleware{
-		Gauge:                   gauge,
 		Tracer:                  tracer,
 		Props:                   otel.GetTextMapPropagator(),
-		httpLatencyHistogram:    httpLatencyHistogram,
 		PatternTree:             pattenrTree,
-		HttpRequestsCounter:     httpRequestsCounter,
-		IsEnabledMeasureLatency: enableMetrics,
+		RouteTree:               routeTree,
+		Metrics:                 cfg.Collectors.requests,
+		ApplicationName:         cfg.ApplicationName,
+		IsEnabledMeasureLatency: cfg.EnableMetrics,
+		IncomingTrace:           cfg.IncomingTrace,
+		RequestID:               cfg.RequestID,
+		Discoverer:              cfg.Discoverer,
+		Routes:                  cfg.Routes,
+		AccessLog:               cfg.AccessLog,
+		Redactor:                cfg.Redactor,
+		Capture:                 cfg.Capture,
+		HAR:                     cfg.HAR,
+		OpenAPI:                 cfg.OpenAPI,
+		ViolationsCounter:       cfg.Collectors.violations,
 	}
 	return m
 }
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tae2089/reverse-proxy/internal/observe"
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestStripTraceHeaders(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	defer otel.SetTextMapPropagator(previous)
+	// only tracecontext is configured, the other formats are stripped too
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	const clientTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var received http.Header
+	handler := chain(Config{
+		ApplicationName: "app",
+		IncomingTrace:   observe.INCOMING_TRACE_STRIP,
+		Collectors:      NewCollectors(prometheus.NewRegistry(), MetricsConfig{}),
+		Redactor:        redact.Default(),
+	}, func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Traceparent", clientTraceparent)
+	r.Header.Set("Baggage", "user=kim")
+	r.Header.Set("Uber-Trace-Id", "4bf92f3577b34da6:00f067aa0ba902b7:0:1")
+	r.Header.Set("B3", "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1")
+	r.Header.Set("X-B3-Traceid", "4bf92f3577b34da6a3ce929d0e0e4736")
+	r.Header.Set("X-B3-Spanid", "00f067aa0ba902b7")
+	r.Header.Set("X-B3-Parentspanid", "00f067aa0ba902b6")
+	r.Header.Set("X-B3-Sampled", "1")
+	handler(httptest.NewRecorder(), r)
+
+	for _, name := range []string{"Baggage", "Uber-Trace-Id", "B3", "X-B3-Traceid", "X-B3-Spanid", "X-B3-Parentspanid", "X-B3-Sampled"} {
+		if value := received.Get(name); value != "" {
+			t.Errorf("%s of the client reached the upstream: %s", name, value)
+		}
+	}
+	if received.Get("Traceparent") == clientTraceparent {
+		t.Error("traceparent of the client reached the upstream")
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"go.uber.org/zap"
+)
+
+// DEFAULT_REQUEST_ID_HEADER carries the request ID when no header is configured
+const DEFAULT_REQUEST_ID_HEADER = "X-Request-ID"
+
+// validRequestID bounds the incoming values so that clients can not inject
+// arbitrary text into logs and upstream headers
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDConfig holds the settings of the request ID
+type RequestIDConfig struct {
+	// Header carries the request ID, DEFAULT_REQUEST_ID_HEADER when empty
+	Header string
+	// AcceptIncoming keeps a valid request ID sent by the client instead of generating one
+	AcceptIncoming bool
+}
+
+func (c RequestIDConfig) header() string {
+	if c.Header == "" {
+		return DEFAULT_REQUEST_ID_HEADER
+	}
+	return http.CanonicalHeaderKey(c.Header)
+}
+
+// RequestIDMiddleware accepts or generates the request ID, forwards it to the
+// upstream, returns it to the client and adds it to every log entry of the request
+func (m *otelMiddleware) RequestIDMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	header := m.RequestID.header()
+	return func(w http.ResponseWriter, r *http.Request) {
+		incoming := r.Header.Get(header)
+		id := incoming
+		if id == "" || !m.RequestID.AcceptIncoming || !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		ctx := log.WithFields(r.Context(), zap.String("request_id", id))
+		ctx = domain.WithRequestID(ctx, id)
+		if m.RequestID.AcceptIncoming && incoming != "" && incoming != id {
+			// the value itself is not logged, it is untrusted
+			log.FromContext(ctx).Named(loggerName).Warn("invalid incoming request id replaced", zap.String("header", header), zap.Int("length", len(incoming)))
+		}
+		r.Header.Set(header, id)
+		// the upstream may echo the header, the final value is set when the status is written
+		if rec, ok := r.Context().Value("rec").(*domain.ResponseCapture); ok {
+			rec.SetHeaderOnWrite(header, id)
+		} else {
+			w.Header().Set(header, id)
+		}
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
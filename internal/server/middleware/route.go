@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+)
+
+// RouteMiddleware resolves the route of the request once and stores it in the request context.
+// Configured routes take precedence over documented OpenAPI operations, which
+// take precedence over url patterns.
+func (m *otelMiddleware) RouteMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		match, ok := m.resolveRoute(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(domain.WithRouteMatch(r.Context(), match)))
+	}
+}
+
+func (m *otelMiddleware) resolveRoute(r *http.Request) (*domain.RouteMatch, bool) {
+	// the rewrite, header rules, split and shadow of a route are looked up by its name
+	if match, ok := m.RouteTree.Match(r.Method, r.URL.Path); ok {
+		return &domain.RouteMatch{
+			Pattern: match.Pattern,
+			Name:    match.Name,
+			Params:  match.Params,
+			Method:  r.Method,
+			Source:  domain.ROUTE_SOURCE_ROUTE,
+		}, true
+	}
+	if m.OpenAPI.Spec != nil {
+		if op, ok := m.OpenAPI.Spec.Match(r.Method, r.URL.Path); ok {
+			return &domain.RouteMatch{
+				Pattern: op.Template,
+				Name:    op.Name(),
+				Params:  op.Params,
+				Method:  r.Method,
+				Source:  domain.ROUTE_SOURCE_OPENAPI,
+			}, true
+		}
+	}
+	match, ok := m.PatternTree.Match(r.Method, r.URL.Path)
+	if !ok {
+		return nil, false
+	}
+	name := match.Name
+	if name == "" {
+		name = match.Pattern
+	}
+	return &domain.RouteMatch{
+		Pattern: match.Pattern,
+		Name:    name,
+		Params:  match.Params,
+		Method:  r.Method,
+		Source:  domain.ROUTE_SOURCE_PATTERN,
+	}, true
+}
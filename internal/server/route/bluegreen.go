@@ -0,0 +1,166 @@
+package route
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tae2089/reverse-proxy/internal/canary"
+)
+
+// SplitState describes a split route on the blue/green admin endpoint
+type SplitState struct {
+	Application string `json:"application"`
+	Route       string `json:"route"`
+	// Active is the group having every weight, empty when several groups have one
+	Active   string           `json:"active"`
+	Weights  map[string]int   `json:"weights"`
+	InFlight map[string]int64 `json:"in_flight"`
+}
+
+// Splits holds the split routes of every virtual host so that their active
+// group can be switched at runtime
+type Splits struct {
+	routes []SplitRoute
+	// analyzers forbid switching a route whose canary analysis is running
+	analyzers *canary.Analyzers
+	// mu serializes the switches
+	mu       sync.Mutex
+	active   *prometheus.GaugeVec
+	switches *prometheus.CounterVec
+}
+
+// SplitRoute is a split route of an application
+type SplitRoute struct {
+	Application string
+	*Route
+}
+
+// NewSplits registers the blue/green metrics, namespace, subsystem and const
+// labels are those of the request metrics. A route is not switched while its
+// analysis in analyzers is running.
+func NewSplits(registerer prometheus.Registerer, namespace, subsystem string, constLabels map[string]string, analyzers *canary.Analyzers) *Splits {
+	factory := promauto.With(registerer)
+	return &Splits{
+		analyzers: analyzers,
+		active: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "upstream_group_active",
+			Help:        "Active upstream group of a split route, 1 for the group having every weight",
+			ConstLabels: constLabels,
+		}, []string{"application", "route", "group"}),
+		switches: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "upstream_group_switches",
+			Help:        "Total count of switches of the active upstream group by the group switched to",
+			ConstLabels: constLabels,
+		}, []string{"application", "route", "group"}),
+	}
+}
+
+// Add registers the split routes of an application
+func (s *Splits) Add(application string, table *Table) {
+	for _, rt := range table.Routes() {
+		if rt.Split == nil {
+			continue
+		}
+		split := SplitRoute{Application: application, Route: rt}
+		s.routes = append(s.routes, split)
+		// the canary analysis changes the weights too
+		rt.Split.OnChange(func() { s.publish(split) })
+		s.publish(split)
+	}
+}
+
+// Len returns the number of split routes
+func (s *Splits) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.routes)
+}
+
+// Get returns a split route, application may be empty when the route name is unique
+func (s *Splits) Get(application, name string) (SplitRoute, error) {
+	var found SplitRoute
+	for _, split := range s.routes {
+		if split.Name != name || (application != "" && split.Application != application) {
+			continue
+		}
+		if found.Route != nil {
+			return found, fmt.Errorf("route %q is split by several applications, give the application", name)
+		}
+		found = split
+	}
+	if found.Route == nil {
+		return found, fmt.Errorf("route %q has no split", name)
+	}
+	return found, nil
+}
+
+// Switch makes group the active group of a route, new requests are sent to
+// it at once. It returns the group that was active, and canary.ErrRunning
+// when the canary analysis of the route is running.
+func (s *Splits) Switch(split SplitRoute, group string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var previous string
+	activate := func() (err error) {
+		previous, err = split.Split.Activate(group)
+		return err
+	}
+	var err error
+	if analyzer, found := s.analyzer(split); found {
+		// the analysis can not be resumed between the check and the switch
+		err = analyzer.UnlessRunning(activate)
+	} else {
+		err = activate()
+	}
+	if err != nil {
+		return "", err
+	}
+	s.switches.WithLabelValues(split.Application, split.Name, group).Inc()
+	return previous, nil
+}
+
+func (s *Splits) analyzer(split SplitRoute) (*canary.Analyzer, bool) {
+	if s.analyzers.Len() == 0 {
+		return nil, false
+	}
+	analyzer, err := s.analyzers.Get(split.Application, split.Name)
+	return analyzer, err == nil
+}
+
+// States returns the state of every split route
+func (s *Splits) States() []SplitState {
+	states := make([]SplitState, 0, len(s.routes))
+	for _, split := range s.routes {
+		states = append(states, split.State())
+	}
+	return states
+}
+
+// State returns the weights and requests in flight of the split route
+func (s SplitRoute) State() SplitState {
+	return SplitState{
+		Application: s.Application,
+		Route:       s.Name,
+		Active:      s.Split.Active(),
+		Weights:     s.Split.Weights(),
+		InFlight:    s.Split.InFlight(),
+	}
+}
+
+func (s *Splits) publish(split SplitRoute) {
+	active := split.Split.Active()
+	for _, group := range split.Split.Groups() {
+		value := 0.0
+		if group.Name == active {
+			value = 1
+		}
+		s.active.WithLabelValues(split.Application, split.Name, group.Name).Set(value)
+	}
+}
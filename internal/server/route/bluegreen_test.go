@@ -0,0 +1,73 @@
+package route
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tae2089/reverse-proxy/internal/canary"
+)
+
+func TestSplitsSwitch(t *testing.T) {
+	table, err := NewTable([]Config{{
+		Name:    "users",
+		Pattern: "/users",
+		Split: SplitConfig{
+			Groups: []GroupConfig{
+				{Name: "blue", TargetHosts: []string{"http://blue"}, Weight: 100},
+				{Name: "green", TargetHosts: []string{"http://green"}},
+			},
+			Analysis: canary.Config{Baseline: "blue", Canary: "green", Steps: []int{50}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := table.Get("users")
+	registry := prometheus.NewRegistry()
+	analyzer := canary.New("app", "users", rt.Split.Analysis(), rt.Split, canary.NewMetrics(registry, "", "", nil))
+	analyzers := &canary.Analyzers{}
+	analyzers.Add(analyzer)
+	splits := NewSplits(registry, "", "", nil, analyzers)
+	splits.Add("app", table)
+	active := func(group string) float64 {
+		return testutil.ToFloat64(splits.active.WithLabelValues("app", "users", group))
+	}
+	if active("blue") != 1 || active("green") != 0 {
+		t.Fatalf("active gauges = %v, %v", active("blue"), active("green"))
+	}
+
+	// a weight change of the analysis is published too
+	if err := rt.Split.SetWeights(map[string]int{"blue": 50, "green": 50}); err != nil {
+		t.Fatal(err)
+	}
+	if active("blue") != 0 || active("green") != 0 {
+		t.Errorf("active gauges with two weighted groups = %v, %v", active("blue"), active("green"))
+	}
+
+	split, err := splits.Get("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := splits.Switch(split, "green"); !errors.Is(err, canary.ErrRunning) {
+		t.Fatalf("switch with a running analysis: %v", err)
+	}
+	if rt.Split.Active() != "" {
+		t.Errorf("switched to %s with a running analysis", rt.Split.Active())
+	}
+
+	if _, err := analyzer.Control(canary.ACTION_PAUSE); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := splits.Switch(split, "green")
+	if err != nil || previous != "" || active("green") != 1 {
+		t.Fatalf("switch of a paused analysis: previous %q, active %v, %v", previous, active("green"), err)
+	}
+	if count := testutil.ToFloat64(splits.switches.WithLabelValues("app", "users", "green")); count != 1 {
+		t.Errorf("switches = %v", count)
+	}
+	if _, err := splits.Switch(split, "purple"); err == nil {
+		t.Error("unknown group was switched to")
+	}
+}
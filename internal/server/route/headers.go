@@ -0,0 +1,223 @@
+package route
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"text/template"
+
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"go.uber.org/zap"
+)
+
+// HeadersConfig holds the header rules of a route
+type HeadersConfig struct {
+	// Request rules run before the request is handed to the reverse proxy
+	Request HeaderRulesConfig `mapstructure:"request"`
+	// Response rules run on the upstream response
+	Response HeaderRulesConfig `mapstructure:"response"`
+}
+
+// HeaderRulesConfig edits headers in order: rename, remove, set and add.
+// Set and add values are go templates, e.g. {{.ClientIP}} or {{env "REGION"}}.
+type HeaderRulesConfig struct {
+	// Set replaces the header, empty rendered values are skipped
+	Set map[string]string `mapstructure:"set"`
+	// Add appends a value to the header, empty rendered values are skipped
+	Add    map[string]string `mapstructure:"add"`
+	Remove []string          `mapstructure:"remove"`
+	// Rename moves the values of a header to another name, e.g. from X-Token
+	// to Authorization, in the configured order so that renames can be chained
+	Rename []RenameConfig `mapstructure:"rename"`
+}
+
+// RenameConfig moves the values of the From header to the To header
+type RenameConfig struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// TemplateData is the data available to header templates
+type TemplateData struct {
+	Route     *domain.RouteMatch
+	Params    map[string]string
+	Method    string
+	Path      string
+	Host      string
+	ClientIP  string
+	RequestID string
+	TLS       TLSIdentity
+	// Status is the upstream status code, only set for response headers
+	Status int
+}
+
+// TLSIdentity describes the TLS connection of the client
+type TLSIdentity struct {
+	ServerName string
+	// CommonName, Subject and DNSNames come from the client certificate
+	CommonName string
+	Subject    string
+	DNSNames   []string
+}
+
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+// ParseTemplate parses a header value written as a go template
+func ParseTemplate(name, value string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(value)
+}
+
+// NewTemplateData returns the template data of the incoming request
+func NewTemplateData(r *http.Request) TemplateData {
+	match, ok := domain.RouteMatchFromContext(r.Context())
+	if !ok {
+		match = &domain.RouteMatch{}
+	}
+	return TemplateData{
+		Route:     match,
+		Params:    match.Params,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Host:      r.Host,
+		ClientIP:  clientIP(r),
+		RequestID: domain.RequestIDFromContext(r.Context()),
+		TLS:       tlsIdentity(r.TLS),
+	}
+}
+
+// clientIP returns the address of the peer, forwarded headers are not trusted
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func tlsIdentity(state *tls.ConnectionState) TLSIdentity {
+	if state == nil {
+		return TLSIdentity{}
+	}
+	identity := TLSIdentity{ServerName: state.ServerName}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		identity.CommonName = cert.Subject.CommonName
+		identity.Subject = cert.Subject.String()
+		identity.DNSNames = cert.DNSNames
+	}
+	return identity
+}
+
+type headerRules struct {
+	set    map[string]*template.Template
+	add    map[string]*template.Template
+	remove []string
+	rename []RenameConfig
+}
+
+func newHeaderRules(cfg HeaderRulesConfig) (*headerRules, error) {
+	rules := &headerRules{}
+	var err error
+	if rules.set, err = parseHeaderValues(cfg.Set); err != nil {
+		return nil, err
+	}
+	if rules.add, err = parseHeaderValues(cfg.Add); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.Remove {
+		rules.remove = append(rules.remove, http.CanonicalHeaderKey(name))
+	}
+	for _, rename := range cfg.Rename {
+		if rename.From == "" || rename.To == "" {
+			return nil, fmt.Errorf("header rename requires from and to, got %q to %q", rename.From, rename.To)
+		}
+		rules.rename = append(rules.rename, RenameConfig{From: http.CanonicalHeaderKey(rename.From), To: http.CanonicalHeaderKey(rename.To)})
+	}
+	return rules, nil
+}
+
+func parseHeaderValues(values map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(values))
+	for name, value := range values {
+		tmpl, err := ParseTemplate(name, value)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid template of header %s", name), err)
+		}
+		templates[http.CanonicalHeaderKey(name)] = tmpl
+	}
+	return templates, nil
+}
+
+func (h *headerRules) isEmpty() bool {
+	return len(h.set) == 0 && len(h.add) == 0 && len(h.remove) == 0 && len(h.rename) == 0
+}
+
+func (h *headerRules) apply(ctx context.Context, header http.Header, data TemplateData) {
+	for _, rename := range h.rename {
+		if values := header.Values(rename.From); len(values) > 0 {
+			header.Del(rename.From)
+			header[rename.To] = append([]string{}, values...)
+		}
+	}
+	for _, name := range h.remove {
+		header.Del(name)
+	}
+	var buf bytes.Buffer
+	for _, name := range sortedNames(h.set) {
+		if value, ok := RenderTemplate(ctx, &buf, h.set[name], data); ok {
+			header.Set(name, value)
+		}
+	}
+	for _, name := range sortedNames(h.add) {
+		if value, ok := RenderTemplate(ctx, &buf, h.add[name], data); ok {
+			header.Add(name, value)
+		}
+	}
+}
+
+// RenderTemplate executes a header template into buf, ok is false for errors and empty values
+func RenderTemplate(ctx context.Context, buf *bytes.Buffer, tmpl *template.Template, data TemplateData) (string, bool) {
+	buf.Reset()
+	if err := tmpl.Execute(buf, data); err != nil {
+		log.FromContext(ctx).Named(loggerName).Error("failed to render header", zap.String("header", tmpl.Name()), zap.Error(err))
+		return "", false
+	}
+	return buf.String(), buf.Len() > 0
+}
+
+func sortedNames(templates map[string]*template.Template) []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyRequestHeaders runs the request header rules of the route
+func (rt *Route) ApplyRequestHeaders(r *http.Request) {
+	if rt.requestHeaders.isEmpty() {
+		return
+	}
+	rt.requestHeaders.apply(r.Context(), r.Header, NewTemplateData(r))
+}
+
+// ApplyResponseHeaders runs the response header rules of the route, the
+// template data comes from the request sent to the upstream
+func (rt *Route) ApplyResponseHeaders(resp *http.Response) {
+	if rt.responseHeaders.isEmpty() {
+		return
+	}
+	data := NewTemplateData(resp.Request)
+	data.Status = resp.StatusCode
+	rt.responseHeaders.apply(resp.Request.Context(), resp.Header, data)
+}
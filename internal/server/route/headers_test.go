@@ -0,0 +1,116 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+)
+
+func TestApplyRequestHeaders(t *testing.T) {
+	t.Setenv("REGION", "eu-west-1")
+	table, err := NewTable([]Config{{
+		Name:    "users",
+		Pattern: "/users/{id}",
+		Headers: HeadersConfig{
+			Request: HeaderRulesConfig{
+				Rename: []RenameConfig{{From: "x-token", To: "Authorization"}},
+				Remove: []string{"Cookie"},
+				Set: map[string]string{
+					"X-User-ID":   "{{.Params.id}}",
+					"X-Client-IP": "{{.ClientIP}}",
+					"X-Region":    `{{env "REGION"}}`,
+					"X-Empty":     "{{.TLS.CommonName}}",
+				},
+				Add: map[string]string{"Via": "reverse-proxy"},
+			},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := table.Get("users")
+	r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Token", "Bearer abc")
+	r.Header.Set("Cookie", "session=abc")
+	r.Header.Set("Via", "1.1 edge")
+	r = r.WithContext(domain.WithRouteMatch(r.Context(), &domain.RouteMatch{Name: "users", Params: map[string]string{"id": "42"}}))
+	rt.ApplyRequestHeaders(r)
+
+	expected := map[string]string{
+		"Authorization": "Bearer abc",
+		"X-Token":       "",
+		"Cookie":        "",
+		"X-User-Id":     "42",
+		"X-Client-Ip":   "10.0.0.1",
+		"X-Region":      "eu-west-1",
+		"X-Empty":       "",
+	}
+	for name, value := range expected {
+		if got := r.Header.Get(name); got != value {
+			t.Errorf("%s = %q; want %q", name, got, value)
+		}
+	}
+	if got := r.Header.Values("Via"); len(got) != 2 {
+		t.Errorf("Via = %v; want 2 values", got)
+	}
+}
+
+func TestApplyResponseHeaders(t *testing.T) {
+	table, err := NewTable([]Config{{
+		Name:    "users",
+		Pattern: "/users/{id}",
+		Headers: HeadersConfig{
+			Response: HeaderRulesConfig{
+				Remove: []string{"X-Internal"},
+				Set:    map[string]string{"X-Upstream-Status": "{{.Status}}"},
+			},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := table.Get("users")
+	resp := &http.Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"X-Internal": {"secret"}},
+		Request:    httptest.NewRequest(http.MethodPost, "/users/42", nil),
+	}
+	rt.ApplyResponseHeaders(resp)
+	if got := resp.Header.Get("X-Internal"); got != "" {
+		t.Errorf("X-Internal = %q; want it removed", got)
+	}
+	if got := resp.Header.Get("X-Upstream-Status"); got != "201" {
+		t.Errorf("X-Upstream-Status = %q; want 201", got)
+	}
+}
+
+func TestApplyChainedRenames(t *testing.T) {
+	table, err := NewTable([]Config{{
+		Name:    "users",
+		Pattern: "/users",
+		Headers: HeadersConfig{
+			Request: HeaderRulesConfig{
+				Rename: []RenameConfig{
+					{From: "X-A", To: "X-B"},
+					{From: "X-B", To: "X-C"},
+				},
+			},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := table.Get("users")
+	// the renames are applied in order every time
+	for i := 0; i < 20; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.Header.Set("X-A", "a")
+		rt.ApplyRequestHeaders(r)
+		if r.Header.Get("X-C") != "a" || r.Header.Get("X-A") != "" || r.Header.Get("X-B") != "" {
+			t.Fatalf("headers = %v, want X-A moved to X-C", r.Header)
+		}
+	}
+}
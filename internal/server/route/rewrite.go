@@ -0,0 +1,214 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RewriteConfig rewrites the path sent to the upstream. The steps run in
+// order: template, strip-prefix, regex and add-prefix.
+type RewriteConfig struct {
+	// StripPrefix removes a prefix, e.g. /svc/orders
+	StripPrefix string `mapstructure:"strip-prefix"`
+	// AddPrefix prepends a prefix, e.g. /api
+	AddPrefix string `mapstructure:"add-prefix"`
+	// Regex and Replacement rewrite the path with capture groups, e.g. ^/v1/(.*)$ and /$1
+	Regex       string `mapstructure:"regex"`
+	Replacement string `mapstructure:"replacement"`
+	// Template builds the path from the route params, e.g. /users/{id}/profile
+	Template string                `mapstructure:"template"`
+	Response ResponseRewriteConfig `mapstructure:"response"`
+}
+
+// ResponseRewriteConfig maps upstream redirects and cookies back to the public path.
+// Only the prefix steps can be reverted, regex and template rewrites are left as is.
+type ResponseRewriteConfig struct {
+	// Location rewrites the host and path of Location headers
+	Location bool `mapstructure:"location"`
+	// CookiePath rewrites the Path attribute of Set-Cookie headers
+	CookiePath bool `mapstructure:"cookie-path"`
+	// CookieDomain replaces the Domain attribute of Set-Cookie headers when set
+	CookieDomain string `mapstructure:"cookie-domain"`
+}
+
+var templateParam = regexp.MustCompile(`\{([^}:.]+)[^}]*\}`)
+
+type rewriter struct {
+	cfg   RewriteConfig
+	regex *regexp.Regexp
+}
+
+func newRewriter(cfg RewriteConfig) (*rewriter, error) {
+	r := &rewriter{cfg: cfg}
+	if cfg.Regex != "" {
+		regex, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, err
+		}
+		r.regex = regex
+	}
+	if cfg.Template != "" && !strings.HasPrefix(cfg.Template, "/") {
+		return nil, errors.New("rewrite template must start with /")
+	}
+	return r, nil
+}
+
+// isEmpty reports whether the request path is left untouched
+func (r *rewriter) isEmpty() bool {
+	return r.cfg.StripPrefix == "" && r.cfg.AddPrefix == "" && r.regex == nil && r.cfg.Template == ""
+}
+
+// rewritePath applies the rewrite steps to the path, a query built by the
+// regex replacement or the template is returned apart
+func (r *rewriter) rewritePath(path string, params map[string]string) (string, string) {
+	if r.cfg.Template != "" {
+		path = templateParam.ReplaceAllStringFunc(r.cfg.Template, func(segment string) string {
+			name := templateParam.FindStringSubmatch(segment)[1]
+			if value, ok := params[name]; ok {
+				return value
+			}
+			return segment
+		})
+	}
+	if r.cfg.StripPrefix != "" {
+		path, _ = stripPrefix(path, r.cfg.StripPrefix)
+	}
+	if r.regex != nil {
+		path = r.regex.ReplaceAllString(path, r.cfg.Replacement)
+	}
+	path, query, _ := strings.Cut(path, "?")
+	if r.cfg.AddPrefix != "" {
+		path = strings.TrimSuffix(r.cfg.AddPrefix, "/") + path
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path, query
+}
+
+// revertPath maps an upstream path back to the public path by reverting the prefix steps
+func (r *rewriter) revertPath(path string) string {
+	if r.cfg.AddPrefix != "" {
+		trimmed, ok := stripPrefix(path, r.cfg.AddPrefix)
+		if !ok {
+			return path
+		}
+		path = trimmed
+	}
+	if r.cfg.StripPrefix != "" {
+		if _, ok := stripPrefix(path, r.cfg.StripPrefix); !ok {
+			path = strings.TrimSuffix(r.cfg.StripPrefix, "/") + path
+		}
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
+
+// stripPrefix removes the prefix from the path on a segment boundary, so that
+// /api strips /api and /api/x but not /apiv2
+func stripPrefix(path, prefix string) (string, bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	trimmed, ok := strings.CutPrefix(path, prefix)
+	if !ok || (trimmed != "" && !strings.HasPrefix(trimmed, "/")) {
+		return path, false
+	}
+	if trimmed == "" {
+		trimmed = "/"
+	}
+	return trimmed, true
+}
+
+// RewriteRequest returns the request sent to the upstream. A rewritten request
+// is a copy having its own URL, so that the middlewares keep the public path.
+func (rt *Route) RewriteRequest(req *http.Request, params map[string]string) *http.Request {
+	if rt.rewriter.isEmpty() {
+		return req
+	}
+	if rt.Rewrite.StripPrefix != "" {
+		if _, ok := stripPrefix(req.URL.Path, rt.Rewrite.StripPrefix); ok {
+			req.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(rt.Rewrite.StripPrefix, "/"))
+		}
+	}
+	out := new(http.Request)
+	*out = *req
+	u := *req.URL
+	out.URL = &u
+	path, query := rt.rewriter.rewritePath(req.URL.Path, params)
+	out.URL.Path, out.URL.RawPath = path, ""
+	if query != "" {
+		if out.URL.RawQuery != "" {
+			query += "&" + out.URL.RawQuery
+		}
+		out.URL.RawQuery = query
+	}
+	return out
+}
+
+// RewriteResponse maps the Location and Set-Cookie headers of the upstream
+// response back to the public host and path of the original request, upstream
+// is the URL of the request sent to the upstream
+func (rt *Route) RewriteResponse(resp *http.Response, publicScheme, publicHost string, upstream *url.URL) {
+	cfg := rt.Rewrite.Response
+	if cfg.Location {
+		if location := resp.Header.Get("Location"); location != "" {
+			resp.Header.Set("Location", rt.rewriteLocation(location, publicScheme, publicHost, upstream))
+		}
+	}
+	if cfg.CookiePath || cfg.CookieDomain != "" {
+		cookies := resp.Header.Values("Set-Cookie")
+		resp.Header.Del("Set-Cookie")
+		for _, cookie := range cookies {
+			resp.Header.Add("Set-Cookie", rt.rewriteCookie(cookie))
+		}
+	}
+}
+
+func (rt *Route) rewriteLocation(location, publicScheme, publicHost string, upstream *url.URL) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	switch {
+	case u.Scheme == "" && u.Host == "":
+		// a relative redirect, e.g. ../login, is relative to the upstream path
+		u = upstream.ResolveReference(u)
+		u.Scheme, u.Host = "", ""
+	case !strings.EqualFold(u.Host, upstream.Host):
+		// redirect to another site
+		return location
+	default:
+		u.Scheme = publicScheme
+		u.Host = publicHost
+	}
+	u.Path = rt.rewriter.revertPath(u.Path)
+	u.RawPath = ""
+	return u.String()
+}
+
+// rewriteCookie edits the attributes as text so that unknown attributes are kept
+func (rt *Route) rewriteCookie(cookie string) string {
+	cfg := rt.Rewrite.Response
+	parts := strings.Split(cookie, ";")
+	for i, part := range parts {
+		if i == 0 {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch strings.ToLower(key) {
+		case "path":
+			if cfg.CookiePath {
+				parts[i] = " Path=" + rt.rewriter.revertPath(value)
+			}
+		case "domain":
+			if cfg.CookieDomain != "" {
+				parts[i] = " Domain=" + cfg.CookieDomain
+			}
+		}
+	}
+	return strings.Join(parts, ";")
+}
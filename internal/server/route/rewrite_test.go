@@ -0,0 +1,97 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      RewriteConfig
+		target   string
+		params   map[string]string
+		expected string
+	}{
+		{"strip prefix", RewriteConfig{StripPrefix: "/svc/orders"}, "/svc/orders/items/1", nil, "/items/1"},
+		{"strip whole path", RewriteConfig{StripPrefix: "/svc/orders"}, "/svc/orders", nil, "/"},
+		{"strip on a segment boundary", RewriteConfig{StripPrefix: "/api"}, "/apiv2/x", nil, "/apiv2/x"},
+		{"strip prefix with a trailing slash", RewriteConfig{StripPrefix: "/api/"}, "/api/x", nil, "/x"},
+		{"add prefix", RewriteConfig{AddPrefix: "/api/"}, "/items", nil, "/api/items"},
+		{"regex", RewriteConfig{Regex: `^/v1/(.*)$`, Replacement: "$1"}, "/v1/users", nil, "/users"},
+		{"regex with a query", RewriteConfig{Regex: `^/v1/users/([0-9]+)$`, Replacement: "/users?id=$1"}, "/v1/users/42?fields=name", nil, "/users?id=42&fields=name"},
+		{"template", RewriteConfig{Template: "/users/{id}/profile"}, "/profiles/42", map[string]string{"id": "42"}, "/users/42/profile"},
+		{"template typed param", RewriteConfig{Template: "/files/{rest...}"}, "/static/a/b", map[string]string{"rest": "a/b"}, "/files/a/b"},
+	}
+	for _, tt := range tests {
+		table, err := NewTable([]Config{{Name: "rewrite", Pattern: "/{rest...}", Rewrite: tt.cfg}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt, _ := table.Get("rewrite")
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		out := rt.RewriteRequest(req, tt.params)
+		if got := out.URL.RequestURI(); got != tt.expected {
+			t.Errorf("%s: RewriteRequest(%s) = %s; want %s", tt.name, tt.target, got, tt.expected)
+		}
+		if req.URL.RequestURI() != tt.target {
+			t.Errorf("%s: the incoming request was rewritten to %s", tt.name, req.URL.RequestURI())
+		}
+	}
+}
+
+func TestRewriteResponse(t *testing.T) {
+	table, err := NewTable([]Config{{
+		Name:    "orders",
+		Pattern: "/svc/orders/{rest...}",
+		Rewrite: RewriteConfig{
+			StripPrefix: "/svc/orders",
+			Response:    ResponseRewriteConfig{Location: true, CookiePath: true, CookieDomain: "example.com"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := table.Get("orders")
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Location", "http://orders.internal:8080/login?next=%2Fcart")
+	resp.Header.Add("Set-Cookie", "session=abc; Path=/; Domain=orders.internal; HttpOnly")
+	upstream, _ := url.Parse("http://orders.internal:8080/items/1")
+	rt.RewriteResponse(resp, "https", "example.com", upstream)
+
+	if got := resp.Header.Get("Location"); got != "https://example.com/svc/orders/login?next=%2Fcart" {
+		t.Errorf("Location = %s", got)
+	}
+	if got := resp.Header.Get("Set-Cookie"); got != "session=abc; Path=/svc/orders/; Domain=example.com; HttpOnly" {
+		t.Errorf("Set-Cookie = %s", got)
+	}
+}
+
+func TestRewriteRelativeLocation(t *testing.T) {
+	table, err := NewTable([]Config{{
+		Name:    "orders",
+		Pattern: "/svc/orders/{rest...}",
+		Rewrite: RewriteConfig{StripPrefix: "/svc/orders", Response: ResponseRewriteConfig{Location: true}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := table.Get("orders")
+	upstream, _ := url.Parse("http://orders.internal:8080/items/1")
+	tests := map[string]string{
+		"../cart":                     "/svc/orders/cart",
+		"2":                           "/svc/orders/items/2",
+		"?page=2":                     "/svc/orders/items/1?page=2",
+		"/login":                      "/svc/orders/login",
+		"https://other.example.com/x": "https://other.example.com/x",
+	}
+	for location, expected := range tests {
+		resp := &http.Response{Header: http.Header{"Location": {location}}}
+		rt.RewriteResponse(resp, "https", "example.com", upstream)
+		if got := resp.Header.Get("Location"); got != expected {
+			t.Errorf("Location %s = %s; want %s", location, got, expected)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tae2089/reverse-proxy/internal/utils"
+)
+
+// loggerName is the logger of the routes, its level can be changed on /admin/log/level
+const loggerName = "route"
+
+// Config is a route declared in the routes section of the config file
+type Config struct {
+	// Name identifies the route in metrics, logs and admin endpoints
+	Name    string `mapstructure:"name"`
+	Pattern string `mapstructure:"pattern"`
+	// Methods restricts the route, every method matches when empty
+	Methods   []string        `mapstructure:"methods"`
+	Rewrite   RewriteConfig   `mapstructure:"rewrite"`
+	Headers   HeadersConfig   `mapstructure:"headers"`
+	AccessLog AccessLogConfig `mapstructure:"access-log"`
+	Shadow    ShadowConfig    `mapstructure:"shadow"`
+	// Split sends the requests to weighted upstream groups instead of the target hosts
+	Split SplitConfig `mapstructure:"split"`
+}
+
+// AccessLogConfig overrides the access log of a route
+type AccessLogConfig struct {
+	Disabled bool `mapstructure:"disabled"`
+	// SampleRatio overrides --access-log-sample-ratio, e.g. 0.01 for a health check
+	SampleRatio *float64 `mapstructure:"sample-ratio"`
+}
+
+// ShadowConfig overrides the mirroring of a route
+type ShadowConfig struct {
+	// Percent overrides the shadow percent of the virtual host, 0 disables mirroring
+	Percent *float64 `mapstructure:"percent"`
+}
+
+// Route is a compiled route
+type Route struct {
+	Config
+	rewriter        *rewriter
+	requestHeaders  *headerRules
+	responseHeaders *headerRules
+	// Split is nil when the route does not split its requests
+	Split *Splitter
+}
+
+// Table holds the compiled routes by name
+type Table struct {
+	routes map[string]*Route
+	order  []string
+}
+
+// NewTable compiles the routes, names must be unique
+func NewTable(configs []Config) (*Table, error) {
+	table := &Table{routes: make(map[string]*Route, len(configs))}
+	for _, cfg := range configs {
+		if cfg.Name == "" || cfg.Pattern == "" {
+			return nil, fmt.Errorf("route %q requires a name and a pattern", cfg.Name)
+		}
+		if _, exists := table.routes[cfg.Name]; exists {
+			return nil, fmt.Errorf("route %q is declared twice", cfg.Name)
+		}
+		if err := utils.ValidatePattern(cfg.Pattern); err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid pattern of route %s", cfg.Name), err)
+		}
+		if ratio := cfg.AccessLog.SampleRatio; ratio != nil && (*ratio < 0 || *ratio > 1) {
+			return nil, fmt.Errorf("access log sample ratio of route %s must be between 0 and 1", cfg.Name)
+		}
+		if percent := cfg.Shadow.Percent; percent != nil && (*percent < 0 || *percent > 100) {
+			return nil, fmt.Errorf("shadow percent of route %s must be between 0 and 100", cfg.Name)
+		}
+		if err := cfg.Split.Validate(); err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid split of route %s", cfg.Name), err)
+		}
+		rewriter, err := newRewriter(cfg.Rewrite)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid rewrite of route %s", cfg.Name), err)
+		}
+		requestHeaders, err := newHeaderRules(cfg.Headers.Request)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid request headers of route %s", cfg.Name), err)
+		}
+		responseHeaders, err := newHeaderRules(cfg.Headers.Response)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid response headers of route %s", cfg.Name), err)
+		}
+		rt := &Route{
+			Config:          cfg,
+			rewriter:        rewriter,
+			requestHeaders:  requestHeaders,
+			responseHeaders: responseHeaders,
+		}
+		if cfg.Split.Enabled() {
+			rt.Split = newSplitter(cfg.Split)
+		}
+		table.routes[cfg.Name] = rt
+		table.order = append(table.order, cfg.Name)
+	}
+	return table, nil
+}
+
+// FillTree inserts the pattern of every route into the tree, named after the route
+func (t *Table) FillTree(tree *utils.Tree) error {
+	if t == nil {
+		return nil
+	}
+	for _, name := range t.order {
+		r := t.routes[name]
+		methods := r.Methods
+		if len(methods) == 0 {
+			methods = []string{utils.ANY_METHOD}
+		}
+		for _, method := range methods {
+			if err := tree.InsertRoute(method, r.Pattern, r.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns the route of the given name
+func (t *Table) Get(name string) (*Route, bool) {
+	if t == nil {
+		return nil, false
+	}
+	r, ok := t.routes[name]
+	return r, ok
+}
+
+// Routes returns the routes in declaration order
+func (t *Table) Routes() []*Route {
+	if t == nil {
+		return nil
+	}
+	routes := make([]*Route, 0, len(t.order))
+	for _, name := range t.order {
+		routes = append(routes, t.routes[name])
+	}
+	return routes
+}
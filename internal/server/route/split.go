@@ -0,0 +1,353 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/canary"
+)
+
+// Reasons a variant was selected
+const (
+	SELECTED_BY_OVERRIDE = "override"
+	SELECTED_BY_STICKY   = "sticky"
+	SELECTED_BY_WEIGHT   = "weight"
+)
+
+// SplitConfig spreads the requests of a route over upstream groups by weight,
+// e.g. 95 to stable and 5 to canary
+type SplitConfig struct {
+	Groups []GroupConfig `mapstructure:"groups"`
+	// Override lets a client, e.g. QA, force a group by naming it
+	Override OverrideConfig `mapstructure:"override"`
+	// Sticky keeps a client on the same group while the weights do not change
+	Sticky StickyConfig `mapstructure:"sticky"`
+	// Analysis steps the weight of a canary group up while it is healthy
+	Analysis canary.Config `mapstructure:"analysis"`
+}
+
+// GroupConfig is an upstream group of a split, its name is the variant label
+// of the metrics and spans
+type GroupConfig struct {
+	Name string `mapstructure:"name"`
+	// TargetHosts are the upstreams of the group, requests are spread round-robin
+	TargetHosts []string `mapstructure:"target-hosts"`
+	// Weight is relative to the weights of the other groups, 0 only takes overrides
+	Weight int `mapstructure:"weight"`
+}
+
+// OverrideConfig names the header, cookie and query param whose value forces
+// a group, they are checked in this order
+type OverrideConfig struct {
+	Header string `mapstructure:"header"`
+	Cookie string `mapstructure:"cookie"`
+	Query  string `mapstructure:"query"`
+}
+
+// StickyConfig names the header or cookie whose hashed value assigns the
+// group, requests without it are assigned at random
+type StickyConfig struct {
+	Header string `mapstructure:"header"`
+	Cookie string `mapstructure:"cookie"`
+}
+
+// Enabled reports whether the route splits its requests
+func (c SplitConfig) Enabled() bool {
+	return len(c.Groups) > 0
+}
+
+// Validate checks the groups and their weights
+func (c SplitConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if len(c.Groups) < 2 {
+		return errors.New("a split requires at least two groups")
+	}
+	names := make(map[string]bool, len(c.Groups))
+	for _, group := range c.Groups {
+		if group.Name == "" || len(group.TargetHosts) == 0 {
+			return fmt.Errorf("group %q requires a name and target-hosts", group.Name)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("group %q is declared twice", group.Name)
+		}
+		names[group.Name] = true
+		if group.Weight < 0 {
+			return fmt.Errorf("weight of group %s must not be negative", group.Name)
+		}
+		for _, target := range group.TargetHosts {
+			if u, err := url.Parse(target); err != nil || u.Host == "" {
+				return fmt.Errorf("invalid target host %q of group %s", target, group.Name)
+			}
+		}
+	}
+	if c.Sticky.Header != "" && c.Sticky.Cookie != "" {
+		return errors.New("sticky takes a header or a cookie, not both")
+	}
+	groups := make([]string, 0, len(c.Groups))
+	for _, group := range c.Groups {
+		groups = append(groups, group.Name)
+	}
+	return c.Analysis.Validate(groups)
+}
+
+// Observer is told the upstream responses of every group, e.g. a canary analysis
+type Observer interface {
+	Observe(variant string, statusCode int, latency time.Duration)
+}
+
+// Splitter selects the upstream group of each request, its weights can be
+// changed at runtime
+type Splitter struct {
+	cfg     SplitConfig
+	weights atomic.Pointer[[]int]
+	// mu serializes the weight changes, Select holds it for reading so that a
+	// request selecting a group is in flight before a weight change returns
+	mu       sync.RWMutex
+	observer Observer
+	// changed is called on every weight change, with mu held
+	changed func()
+	// inFlight counts the requests in flight of every group, in declaration order
+	inFlight []atomic.Int64
+}
+
+func newSplitter(cfg SplitConfig) *Splitter {
+	s := &Splitter{cfg: cfg, inFlight: make([]atomic.Int64, len(cfg.Groups))}
+	weights := make([]int, len(cfg.Groups))
+	for i, group := range cfg.Groups {
+		weights[i] = group.Weight
+	}
+	s.weights.Store(&weights)
+	return s
+}
+
+// SetObserver sets the observer of the responses, it must be set before the
+// requests are served
+func (s *Splitter) SetObserver(observer Observer) {
+	s.observer = observer
+}
+
+// OnChange sets the func called on every weight change, it must be set
+// before the weights are changed
+func (s *Splitter) OnChange(changed func()) {
+	s.changed = changed
+}
+
+// Observe hands an upstream response of the group to the observer, if any
+func (s *Splitter) Observe(variant string, statusCode int, latency time.Duration) {
+	if s.observer != nil {
+		s.observer.Observe(variant, statusCode, latency)
+	}
+}
+
+// Analysis returns the canary analysis of the split
+func (s *Splitter) Analysis() canary.Config {
+	return s.cfg.Analysis
+}
+
+// Groups returns the configured groups in declaration order
+func (s *Splitter) Groups() []GroupConfig {
+	return s.cfg.Groups
+}
+
+// Weights returns the current weight of every group by name
+func (s *Splitter) Weights() map[string]int {
+	weights := *s.weights.Load()
+	byName := make(map[string]int, len(weights))
+	for i, group := range s.cfg.Groups {
+		byName[group.Name] = weights[i]
+	}
+	return byName
+}
+
+// SetWeights replaces the weights of the named groups, the others are kept.
+// New requests see the weights at once.
+func (s *Splitter) SetWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := append([]int(nil), *s.weights.Load()...)
+	for name, weight := range weights {
+		i := s.index(name)
+		if i < 0 {
+			return fmt.Errorf("unknown group %q", name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight of group %s must not be negative", name)
+		}
+		updated[i] = weight
+	}
+	s.store(updated)
+	return nil
+}
+
+// Activate gives every weight to the group, the other groups only take
+// overrides. It returns the group that was active, empty when several groups
+// had a weight.
+func (s *Splitter) Activate(group string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(group)
+	if i < 0 {
+		return "", fmt.Errorf("unknown group %q", group)
+	}
+	previous := s.active(*s.weights.Load())
+	updated := make([]int, len(s.cfg.Groups))
+	updated[i] = 100
+	s.store(updated)
+	return previous, nil
+}
+
+// store must be called with the lock held
+func (s *Splitter) store(weights []int) {
+	s.weights.Store(&weights)
+	if s.changed != nil {
+		s.changed()
+	}
+}
+
+// Active returns the group having every weight, empty when several groups have one
+func (s *Splitter) Active() string {
+	return s.active(*s.weights.Load())
+}
+
+func (s *Splitter) active(weights []int) string {
+	active := ""
+	for i, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		if active != "" {
+			return ""
+		}
+		active = s.cfg.Groups[i].Name
+	}
+	return active
+}
+
+// InFlight returns the requests in flight of every group by name
+func (s *Splitter) InFlight() map[string]int64 {
+	inFlight := make(map[string]int64, len(s.cfg.Groups))
+	for i, group := range s.cfg.Groups {
+		inFlight[group.Name] = s.inFlight[i].Load()
+	}
+	return inFlight
+}
+
+// Drain waits until the group has no request in flight or ctx is done, it
+// returns the requests still in flight
+func (s *Splitter) Drain(ctx context.Context, group string) int64 {
+	i := s.index(group)
+	if i < 0 {
+		return 0
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		remaining := s.inFlight[i].Load()
+		if remaining == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return remaining
+		case <-ticker.C:
+		}
+	}
+}
+
+// Select returns the group of the request and the reason it was selected.
+// The request is in flight to the group until the returned func is called,
+// the blue/green switch drains a group once no request is in flight.
+func (s *Splitter) Select(r *http.Request, routeName string) (string, string, func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, reason := s.selectIndex(r, routeName)
+	s.inFlight[i].Add(1)
+	return s.cfg.Groups[i].Name, reason, func() { s.inFlight[i].Add(-1) }
+}
+
+// selectIndex returns the index of the group of the request, the first group
+// takes the requests when every weight is 0
+func (s *Splitter) selectIndex(r *http.Request, routeName string) (int, string) {
+	if i := s.index(s.override(r)); i >= 0 {
+		return i, SELECTED_BY_OVERRIDE
+	}
+	weights := *s.weights.Load()
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		return 0, SELECTED_BY_WEIGHT
+	}
+	reason := SELECTED_BY_WEIGHT
+	var point int
+	if key := s.stickyKey(r); key != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(routeName))
+		hash.Write([]byte{0})
+		hash.Write([]byte(key))
+		point, reason = int(hash.Sum32()%uint32(total)), SELECTED_BY_STICKY
+	} else {
+		point = rand.IntN(total)
+	}
+	for i, weight := range weights {
+		if point < weight {
+			return i, reason
+		}
+		point -= weight
+	}
+	return len(weights) - 1, reason
+}
+
+// override returns the group named by the client, if it exists
+func (s *Splitter) override(r *http.Request) string {
+	var candidates []string
+	if s.cfg.Override.Header != "" {
+		candidates = append(candidates, r.Header.Get(s.cfg.Override.Header))
+	}
+	if s.cfg.Override.Cookie != "" {
+		if cookie, err := r.Cookie(s.cfg.Override.Cookie); err == nil {
+			candidates = append(candidates, cookie.Value)
+		}
+	}
+	if s.cfg.Override.Query != "" {
+		candidates = append(candidates, r.URL.Query().Get(s.cfg.Override.Query))
+	}
+	for _, name := range candidates {
+		if name != "" && s.index(name) >= 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s *Splitter) stickyKey(r *http.Request) string {
+	if s.cfg.Sticky.Header != "" {
+		return r.Header.Get(s.cfg.Sticky.Header)
+	}
+	if s.cfg.Sticky.Cookie != "" {
+		if cookie, err := r.Cookie(s.cfg.Sticky.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (s *Splitter) index(name string) int {
+	for i, group := range s.cfg.Groups {
+		if group.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,112 @@
+package route
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// selectVariant selects the group of a request that ends at once
+func selectVariant(splitter *Splitter, r *http.Request) (string, string) {
+	variant, reason, done := splitter.Select(r, "users")
+	done()
+	return variant, reason
+}
+
+func TestSplitterSelect(t *testing.T) {
+	splitter := newSplitter(SplitConfig{
+		Groups: []GroupConfig{
+			{Name: "stable", TargetHosts: []string{"http://stable"}, Weight: 95},
+			{Name: "canary", TargetHosts: []string{"http://canary"}, Weight: 5},
+		},
+		Override: OverrideConfig{Header: "X-Canary", Query: "variant"},
+		Sticky:   StickyConfig{Cookie: "session"},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/users?variant=canary", nil)
+	if variant, reason := selectVariant(splitter, r); variant != "canary" || reason != SELECTED_BY_OVERRIDE {
+		t.Errorf("select with override = %s by %s", variant, reason)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("X-Canary", "unknown")
+	if _, reason := selectVariant(splitter, r); reason != SELECTED_BY_WEIGHT {
+		t.Errorf("an unknown group is not an override, selected by %s", reason)
+	}
+
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.AddCookie(&http.Cookie{Name: "session", Value: strconv.Itoa(i)})
+		first, reason := selectVariant(splitter, r)
+		if second, _ := selectVariant(splitter, r); first != second || reason != SELECTED_BY_STICKY {
+			t.Fatalf("session %d moved from %s to %s", i, first, second)
+		}
+		if first == "canary" {
+			canary++
+		}
+	}
+	if canary < 20 || canary > 80 {
+		t.Errorf("%d of 1000 sessions on canary, want about 50", canary)
+	}
+
+	if err := splitter.SetWeights(map[string]int{"canary": 0}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if variant, _ := selectVariant(splitter, httptest.NewRequest(http.MethodGet, "/users", nil)); variant != "stable" {
+			t.Fatalf("selected %s with a canary weight of 0", variant)
+		}
+	}
+	if err := splitter.SetWeights(map[string]int{"green": 1}); err == nil {
+		t.Error("unknown group was accepted")
+	}
+}
+
+func TestSplitValidate(t *testing.T) {
+	group := GroupConfig{Name: "stable", TargetHosts: []string{"http://stable"}, Weight: 1}
+	tests := []struct {
+		name string
+		cfg  SplitConfig
+	}{
+		{"single group", SplitConfig{Groups: []GroupConfig{group}}},
+		{"duplicate group", SplitConfig{Groups: []GroupConfig{group, group}}},
+		{"missing targets", SplitConfig{Groups: []GroupConfig{group, {Name: "canary"}}}},
+		{"negative weight", SplitConfig{Groups: []GroupConfig{group, {Name: "canary", TargetHosts: []string{"http://canary"}, Weight: -1}}}},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestSplitterActivate(t *testing.T) {
+	splitter := newSplitter(SplitConfig{Groups: []GroupConfig{
+		{Name: "blue", TargetHosts: []string{"http://blue"}, Weight: 1},
+		{Name: "green", TargetHosts: []string{"http://green"}},
+	}})
+	variant, _, end := splitter.Select(httptest.NewRequest(http.MethodGet, "/", nil), "users")
+	if variant != "blue" || splitter.InFlight()["blue"] != 1 {
+		t.Fatalf("selected %s, in flight %v", variant, splitter.InFlight())
+	}
+	previous, err := splitter.Activate("green")
+	if err != nil || previous != "blue" || splitter.Active() != "green" {
+		t.Fatalf("activate green: previous %q, active %q, %v", previous, splitter.Active(), err)
+	}
+	if variant, _ := selectVariant(splitter, httptest.NewRequest(http.MethodGet, "/", nil)); variant != "green" {
+		t.Errorf("selected %s after the switch", variant)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if remaining := splitter.Drain(ctx, "blue"); remaining != 1 {
+		t.Errorf("drain with a request in flight = %d, want 1", remaining)
+	}
+	end()
+	if remaining := splitter.Drain(context.Background(), "blue"); remaining != 0 {
+		t.Errorf("drain = %d, want 0", remaining)
+	}
+}
@@ -7,14 +7,21 @@ import (
 	"github.com/tae2089/reverse-proxy/internal/server/middleware"
 )
 
-func newProxyRouter(router *http.ServeMux, proxyController controller.ProxyController, mode, UrlPatternStr string, enableMetrics bool) error {
-	m := middleware.New(mode, UrlPatternStr, enableMetrics)
+// newProxyHandler wraps the proxy of a virtual host with its middlewares
+func newProxyHandler(proxyController controller.ProxyController, mode string, middlewareConfig middleware.Config) (http.Handler, error) {
+	router := http.NewServeMux()
+	m := middleware.New(mode, middlewareConfig)
 	router.Handle("/", MultipleMiddleware(proxyController.ProxyRequestHandler(), m.GetMiddlewares()...))
-	return nil
+	return router, nil
 }
 
-func newMetricRouter(router *http.ServeMux, proxyController controller.ProxyController) error {
-	router.HandleFunc("/metrics", proxyController.Metrics())
+func newMetricRouter(router *http.ServeMux, adminController controller.AdminController) error {
+	router.HandleFunc("/metrics", adminController.Metrics())
+	router.HandleFunc("/admin/patterns", adminController.Patterns())
+	router.HandleFunc("/admin/log/level", adminController.LogLevel())
+	router.HandleFunc("/admin/shadow/diffs", adminController.ShadowDiffs())
+	router.HandleFunc("/admin/canary", adminController.Canary())
+	router.HandleFunc("/admin/bluegreen", adminController.BlueGreen())
 	return nil
 }
 
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tae2089/reverse-proxy/internal/accesslog"
+	"github.com/tae2089/reverse-proxy/internal/openapi"
+	"github.com/tae2089/reverse-proxy/internal/redact"
+	"github.com/tae2089/reverse-proxy/internal/server/controller"
+	"github.com/tae2089/reverse-proxy/internal/server/middleware"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRewrittenRouteAccessLog(t *testing.T) {
+	var upstreamPath string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.RequestURI()
+	}))
+	defer backend.Close()
+
+	routes, err := route.NewTable([]route.Config{{
+		Name:    "orders",
+		Pattern: "/svc/orders/{rest...}",
+		Rewrite: route.RewriteConfig{StripPrefix: "/svc/orders"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := accesslog.New(accesslog.Config{Output: output, SampleRatio: 1}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accessLog.Close()
+	handler, err := newProxyHandler(
+		controller.New([]string{backend.URL}, nil, controller.HeaderScrubbing{}, routes, nil),
+		"otel",
+		middleware.Config{
+			ApplicationName: "app",
+			Collectors:      middleware.NewCollectors(prometheus.NewRegistry(), middleware.MetricsConfig{}),
+			Routes:          routes,
+			AccessLog:       accessLog,
+			Redactor:        redact.Default(),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/svc/orders/items/1?page=2", nil))
+	if w.Code != http.StatusOK || upstreamPath != "/items/1?page=2" {
+		t.Fatalf("upstream got %s, status %d", upstreamPath, w.Code)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("access log %q: %v", data, err)
+	}
+	if entry.Path != "/svc/orders/items/1?page=2" {
+		t.Errorf("access log path = %s, want the public path", entry.Path)
+	}
+}
+
+const documentedRoute = `
+openapi: 3.0.3
+info: {title: test, version: "1"}
+paths:
+  /api/users/{id}:
+    get:
+      operationId: getUser
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+      responses:
+        "200": {description: ok}
+`
+
+func TestDocumentedRoute(t *testing.T) {
+	var upstreamPath string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+	}))
+	defer backend.Close()
+
+	document := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(document, []byte(documentedRoute), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := openapi.Load(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := route.NewTable([]route.Config{{
+		Name:    "users",
+		Pattern: "/api/users/{id}",
+		Rewrite: route.RewriteConfig{StripPrefix: "/api"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := newProxyHandler(
+		controller.New([]string{backend.URL}, nil, controller.HeaderScrubbing{}, routes, nil),
+		"otel",
+		middleware.Config{
+			ApplicationName: "app",
+			Collectors:      middleware.NewCollectors(prometheus.NewRegistry(), middleware.MetricsConfig{}),
+			Routes:          routes,
+			Redactor:        redact.Default(),
+			OpenAPI: middleware.OpenAPIConfig{
+				Spec:       spec,
+				Validation: openapi.ValidationConfig{Mode: openapi.VALIDATION_ENFORCE},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the configured route of a documented path keeps its rewrite
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users/42", nil))
+	if w.Code != http.StatusOK || upstreamPath != "/users/42" {
+		t.Errorf("upstream got %s, status %d", upstreamPath, w.Code)
+	}
+	// and is still validated against the document
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users/abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid request of a documented route answered %d", w.Code)
+	}
+}
+
+func TestMetricsExemplars(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer backend.Close()
+
+	previous := otel.GetTextMapPropagator()
+	defer otel.SetTextMapPropagator(previous)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	defer provider.Shutdown(context.Background())
+	registry := prometheus.NewRegistry()
+	handler, err := newProxyHandler(
+		controller.New([]string{backend.URL}, nil, controller.HeaderScrubbing{}, nil, nil),
+		"otel",
+		middleware.Config{
+			ApplicationName: "app",
+			EnableMetrics:   true,
+			Collectors:      middleware.NewCollectors(registry, middleware.MetricsConfig{}),
+			Tracer:          provider.Tracer("test"),
+			Redactor:        redact.Default(),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	spanCtx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier{"Traceparent": {traceparent}})
+	traceID := trace.SpanContextFromContext(spanCtx).TraceID()
+	if !traceID.IsValid() {
+		t.Fatalf("upstream got traceparent %q", traceparent)
+	}
+
+	router := http.NewServeMux()
+	if err := newMetricRouter(router, controller.NewAdmin(registry, nil, nil, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	scrape := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	scrape.Header.Set("Accept", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, scrape)
+	exemplar := regexp.MustCompile(`(?m)^http_request_latency_bucket\{.*\} 1 # \{trace_id="` + traceID.String() + `"\}`)
+	if !exemplar.MatchString(w.Body.String()) {
+		t.Errorf("no trace_id exemplar of %s on the latency histogram:\n%s", traceID, w.Body.String())
+	}
+}
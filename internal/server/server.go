@@ -2,23 +2,42 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/tae2089/reverse-proxy/internal/accesslog"
+	"github.com/tae2089/reverse-proxy/internal/canary"
+	"github.com/tae2089/reverse-proxy/internal/capture"
+	"github.com/tae2089/reverse-proxy/internal/har"
 	"github.com/tae2089/reverse-proxy/internal/log"
 	"github.com/tae2089/reverse-proxy/internal/observe"
+	"github.com/tae2089/reverse-proxy/internal/openapi"
+	"github.com/tae2089/reverse-proxy/internal/redact"
 	"github.com/tae2089/reverse-proxy/internal/server/controller"
+	"github.com/tae2089/reverse-proxy/internal/server/middleware"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"github.com/tae2089/reverse-proxy/internal/server/vhost"
+	"github.com/tae2089/reverse-proxy/internal/shadow"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
 
 const version = "dev"
 const serviceName = "reverse-proxy"
 
+// loggerName is the logger of the server lifecycle
+const loggerName = "server"
+
 type Config struct {
 	EnableMetrics   bool
 	Port            int
@@ -27,6 +46,39 @@ type Config struct {
 	UrlPatternStr   string
 	ApplicationName string
 	ShutdownTimeOut time.Duration
+	Propagation     observe.PropagationConfig
+	RequestID       middleware.RequestIDConfig
+	Log             log.Config
+	// LogTraceFields is log.TRACE_FIELDS_OTEL or log.TRACE_FIELDS_DATADOG
+	LogTraceFields string
+	AccessLog      accesslog.Config
+	Capture        capture.Config
+	// Shadow mirrors requests of the default virtual host, its limits are the
+	// defaults of the other virtual hosts
+	Shadow shadow.Config
+	// ShadowMismatches is the number of mismatches kept for /admin/shadow/diffs
+	ShadowMismatches int
+	// HAR records traffic to HAR files when its directory is set
+	HAR har.Config
+	// Redaction masks credentials and personal data in logs and span attributes
+	Redaction redact.Config
+	Metrics   middleware.MetricsConfig
+	// PatternDiscoveryThreshold enables pattern discovery when greater than 0
+	PatternDiscoveryThreshold int
+	OpenAPIFile               string
+	OpenAPILabel              string
+	OpenAPIUndocumented       string
+	OpenAPIValidation         openapi.ValidationConfig
+	// UpstreamHeaders are go templates of headers sent to the upstream, e.g. X-Route={{.Route.Name}}
+	UpstreamHeaders map[string]string
+	// HeaderScrubbing removes extra hop-by-hop headers and the Server header
+	HeaderScrubbing controller.HeaderScrubbing
+	Routes          []route.Config
+	// VirtualHosts front several applications by Host header and SNI,
+	// TargetHost becomes the default virtual host when set
+	VirtualHosts []vhost.Config
+	TLSCertFile  string
+	TLSKeyFile   string
 }
 
 type Server struct {
@@ -34,17 +86,162 @@ type Server struct {
 	ProxyServer     *http.Server
 	MetricsServer   *http.Server
 	ShutdownTimeOut time.Duration
+	Propagation     observe.PropagationConfig
+	// EnableTLS serves the proxy with the certificates of the virtual hosts
+	EnableTLS bool
+	AccessLog *accesslog.Logger
+	Capture   *capture.Capturer
+	HAR       *har.Recorder
+	// Canary runs the canary analyses of the split routes
+	Canary *canary.Analyzers
 }
 
 func (c *Config) Complete() (*Server, error) {
-	proxyRouter := http.NewServeMux()
+	if err := log.Configure(c.Log); err != nil {
+		return nil, err
+	}
+	log.SetTraceFields(c.LogTraceFields)
+	redactor, err := redact.New(c.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	log.SetRedactor(redactor)
+	hostRouter := vhost.NewRouter()
 	metricsRouter := http.NewServeMux()
-	// Create proxy,metrics handler
-	proxyController := controller.New(c.TargetHost)
-	if err := newProxyRouter(proxyRouter, proxyController, observe.OBSERVCE_MODE_OTEL, c.UrlPatternStr, c.EnableMetrics); err != nil {
+	// Create a dedicated registry so our metrics never collide with other exporters
+	registry := newMetricsRegistry()
+	// The collectors are shared, virtual hosts are told apart by the application label
+	collectors := middleware.NewCollectors(registry, c.Metrics)
+	upstreamHeaders, err := controller.ParseHeaderTemplates(c.UpstreamHeaders)
+	if err != nil {
 		return nil, err
 	}
-	if err := newMetricRouter(metricsRouter, proxyController); err != nil {
+	accessLog, err := accesslog.New(c.AccessLog, redactor)
+	if err != nil {
+		return nil, err
+	}
+	var capturer *capture.Capturer
+	if c.Capture.Enabled() {
+		if capturer, err = capture.New(c.Capture, redactor); err != nil {
+			return nil, err
+		}
+	}
+	var recorder *har.Recorder
+	if c.HAR.Dir != "" {
+		c.HAR.Creator = har.Creator{Name: serviceName, Version: version}
+		if recorder, err = har.New(c.HAR, redactor); err != nil {
+			return nil, err
+		}
+	}
+	var shadowMetrics *shadow.Metrics
+	if c.Shadow.Target != "" || c.hasVirtualHostShadow() {
+		shadowMetrics = shadow.NewMetrics(registry, c.Metrics.Namespace, c.Metrics.Subsystem, c.Metrics.ConstLabels, c.Metrics.Buckets)
+	}
+	var diffs *shadow.DiffLog
+	if c.Shadow.Compare.Enabled || c.hasVirtualHostComparison() {
+		diffs = shadow.NewDiffLog(c.ShadowMismatches, redactor)
+	}
+	analyzers := &canary.Analyzers{}
+	splits := route.NewSplits(registry, c.Metrics.Namespace, c.Metrics.Subsystem, c.Metrics.ConstLabels, analyzers)
+	var canaryMetrics *canary.Metrics
+	var discovery map[string]controller.PatternDiscovery
+	if c.PatternDiscoveryThreshold > 0 {
+		discovery = make(map[string]controller.PatternDiscovery)
+	}
+
+	// The global flags make up the default virtual host
+	if c.TargetHost != "" {
+		openAPIConfig, err := c.openAPIConfig(c.OpenAPIFile)
+		if err != nil {
+			return nil, err
+		}
+		routes, err := route.NewTable(c.Routes)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := loadCertificate(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		canaryMetrics = analyze(analyzers, canaryMetrics, registry, c.Metrics, c.ApplicationName, routes)
+		splits.Add(c.ApplicationName, routes)
+		mirror, err := shadow.New(c.Shadow, shadowMetrics, diffs, c.ApplicationName)
+		if err != nil {
+			return nil, err
+		}
+		handler, err := newProxyHandler(
+			controller.New([]string{c.TargetHost}, upstreamHeaders, c.HeaderScrubbing, routes, mirror),
+			observe.OBSERVCE_MODE_OTEL,
+			middleware.Config{
+				UrlPatternStr:   c.UrlPatternStr,
+				EnableMetrics:   c.EnableMetrics,
+				IncomingTrace:   c.Propagation.IncomingTrace,
+				RequestID:       c.RequestID,
+				ApplicationName: c.ApplicationName,
+				Collectors:      collectors,
+				Tracer:          observe.Tracer(c.ApplicationName),
+				Discoverer:      c.discoverer(discovery, c.ApplicationName, c.urlPatterns()),
+				OpenAPI:         openAPIConfig,
+				Routes:          routes,
+				AccessLog:       accessLog,
+				Redactor:        redactor,
+				Capture:         capturer,
+				HAR:             recorder,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		hostRouter.Handle(nil, true, handler, certificate)
+	}
+	for _, vhostConfig := range c.VirtualHosts {
+		openAPIConfig, err := c.openAPIConfig(vhostConfig.OpenAPIFile)
+		if err != nil {
+			return nil, err
+		}
+		routes, err := route.NewTable(vhostConfig.Routes)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := loadCertificate(vhostConfig.TLSCertFile, vhostConfig.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		canaryMetrics = analyze(analyzers, canaryMetrics, registry, c.Metrics, vhostConfig.ApplicationName, routes)
+		splits.Add(vhostConfig.ApplicationName, routes)
+		mirror, err := shadow.New(vhostConfig.Shadow.Inherit(c.Shadow), shadowMetrics, diffs, vhostConfig.ApplicationName)
+		if err != nil {
+			return nil, err
+		}
+		handler, err := newProxyHandler(
+			controller.New(vhostConfig.TargetHosts, upstreamHeaders, c.HeaderScrubbing, routes, mirror),
+			observe.OBSERVCE_MODE_OTEL,
+			middleware.Config{
+				UrlPatternStr:   strings.Join(vhostConfig.UrlPatterns, ","),
+				EnableMetrics:   c.EnableMetrics,
+				IncomingTrace:   c.Propagation.IncomingTrace,
+				RequestID:       c.RequestID,
+				ApplicationName: vhostConfig.ApplicationName,
+				Collectors:      collectors,
+				Tracer:          observe.Tracer(vhostConfig.ApplicationName),
+				Discoverer:      c.discoverer(discovery, vhostConfig.ApplicationName, vhostConfig.UrlPatterns),
+				OpenAPI:         openAPIConfig,
+				Routes:          routes,
+				AccessLog:       accessLog,
+				Redactor:        redactor,
+				Capture:         capturer,
+				HAR:             recorder,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		hostRouter.Handle(vhostConfig.Hosts, vhostConfig.Default, handler, certificate)
+		log.Named(loggerName).Info("virtual host configured", zap.String("name", vhostConfig.DisplayName()), zap.Strings("hosts", vhostConfig.Hosts), zap.Strings("targets", vhostConfig.TargetHosts))
+	}
+
+	adminController := controller.NewAdmin(registry, discovery, diffs, analyzers, splits)
+	if err := newMetricRouter(metricsRouter, adminController); err != nil {
 		return nil, err
 	}
 
@@ -52,10 +249,20 @@ func (c *Config) Complete() (*Server, error) {
 	svr := &Server{
 		ProxyServer: &http.Server{
 			Addr:    fmt.Sprintf(":%d", c.Port),
-			Handler: proxyRouter,
+			Handler: hostRouter,
 		},
+		ApplicationName: c.ApplicationName,
 		MetricsServer:   nil,
 		ShutdownTimeOut: c.ShutdownTimeOut,
+		Propagation:     c.Propagation,
+		AccessLog:       accessLog,
+		Capture:         capturer,
+		HAR:             recorder,
+		Canary:          analyzers,
+	}
+	if hostRouter.HasCertificates() {
+		svr.EnableTLS = true
+		svr.ProxyServer.TLSConfig = &tls.Config{GetCertificate: hostRouter.GetCertificate}
 	}
 
 	// Enable metrics server
@@ -68,10 +275,117 @@ func (c *Config) Complete() (*Server, error) {
 	return svr, nil
 }
 
+// analyze creates the canary analyses of the split routes of an application,
+// the metrics are registered with the first analysis and returned
+func analyze(analyzers *canary.Analyzers, metrics *canary.Metrics, registry *prometheus.Registry, cfg middleware.MetricsConfig, application string, routes *route.Table) *canary.Metrics {
+	for _, rt := range routes.Routes() {
+		if rt.Split == nil || !rt.Split.Analysis().Enabled() {
+			continue
+		}
+		if metrics == nil {
+			metrics = canary.NewMetrics(registry, cfg.Namespace, cfg.Subsystem, cfg.ConstLabels)
+		}
+		analyzer := canary.New(application, rt.Name, rt.Split.Analysis(), rt.Split, metrics)
+		rt.Split.SetObserver(analyzer)
+		analyzers.Add(analyzer)
+		log.Named(loggerName).Info("canary analysis configured", zap.String("application", application), zap.String("route", rt.Name))
+	}
+	return metrics
+}
+
+// hasVirtualHostShadow reports whether a virtual host mirrors its requests
+func (c *Config) hasVirtualHostShadow() bool {
+	for _, vhostConfig := range c.VirtualHosts {
+		if vhostConfig.Shadow.Target != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVirtualHostComparison reports whether a virtual host compares its shadow responses
+func (c *Config) hasVirtualHostComparison() bool {
+	for _, vhostConfig := range c.VirtualHosts {
+		if vhostConfig.Shadow.Target != "" && vhostConfig.Shadow.Compare.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCertificate loads a key pair, nil is returned when no file is given
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to load certificate %s", certFile), err)
+	}
+	return &certificate, nil
+}
+
+// openAPIConfig loads the OpenAPI document of a virtual host if one is configured,
+// the label, undocumented and validation settings are shared by every virtual host
+func (c *Config) openAPIConfig(file string) (middleware.OpenAPIConfig, error) {
+	openAPIConfig := middleware.OpenAPIConfig{
+		Label:        c.OpenAPILabel,
+		Undocumented: c.OpenAPIUndocumented,
+		Validation:   c.OpenAPIValidation,
+	}
+	if file == "" {
+		return openAPIConfig, nil
+	}
+	spec, err := openapi.Load(file)
+	if err != nil {
+		return openAPIConfig, err
+	}
+	log.Named(loggerName).Info("openapi document loaded", zap.String("file", file), zap.Int("operations", len(spec.Operations)))
+	openAPIConfig.Spec = spec
+	return openAPIConfig, nil
+}
+
+// discoverer returns the discoverer of an application, virtual hosts of the
+// same application share it. It returns nil when pattern discovery is disabled.
+func (c *Config) discoverer(discovery map[string]controller.PatternDiscovery, application string, configured []string) *utils.Discoverer {
+	if discovery == nil {
+		return nil
+	}
+	existing, ok := discovery[application]
+	if !ok {
+		existing.Discoverer = utils.NewDiscoverer(c.PatternDiscoveryThreshold)
+	}
+	existing.Configured = append(existing.Configured, configured...)
+	discovery[application] = existing
+	return existing.Discoverer
+}
+
+// urlPatterns returns the configured url patterns
+func (c *Config) urlPatterns() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(c.UrlPatternStr, ",") {
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
 func (s *Server) Run() error {
 
 	// Register observability
-	observe.Register(s.ApplicationName, serviceName, version, observe.OBSERVCE_MODE_OTEL)
+	if err := observe.Register(s.ApplicationName, serviceName, version, observe.OBSERVCE_MODE_OTEL, s.Propagation); err != nil {
+		return err
+	}
 
 	// setup signal notify for graceful shutdown
 	mainCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
@@ -82,7 +396,13 @@ func (s *Server) Run() error {
 
 	// Run servers
 	s.runServers(g)
-	log.Info("server started")
+	if s.Canary.Len() > 0 {
+		g.Go(func() error {
+			s.Canary.Run(gCtx)
+			return nil
+		})
+	}
+	log.Named(loggerName).Info("server started")
 
 	// Graceful shutdown
 	g.Go(func() error {
@@ -94,14 +414,21 @@ func (s *Server) Run() error {
 	if err := g.Wait(); err != nil {
 		return err
 	}
-	log.Info("shutting down")
+	log.Named(loggerName).Info("shutting down")
 	return nil
 }
 
 func (s *Server) runServers(g *errgroup.Group) error {
 	// Run proxy server
 	g.Go(func() error {
-		if err := s.ProxyServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if s.EnableTLS {
+			// the certificates are selected by SNI through the TLS config
+			err = s.ProxyServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.ProxyServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			return err
 		}
 		return nil
@@ -134,5 +461,22 @@ func (s *Server) Stop() error {
 			errWrap = errors.Join(errWrap, err)
 		}
 	}
+	// Flush the access log once no request is in flight
+	if s.AccessLog != nil {
+		if err := s.AccessLog.Close(); err != nil {
+			errWrap = errors.Join(errWrap, err)
+		}
+	}
+	if s.Capture != nil {
+		if err := s.Capture.Close(); err != nil {
+			errWrap = errors.Join(errWrap, err)
+		}
+	}
+	// Complete the HAR file being written
+	if s.HAR != nil {
+		if err := s.HAR.Close(); err != nil {
+			errWrap = errors.Join(errWrap, err)
+		}
+	}
 	return errWrap
 }
@@ -0,0 +1,208 @@
+package vhost
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"github.com/tae2089/reverse-proxy/internal/server/route"
+	"github.com/tae2089/reverse-proxy/internal/shadow"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+)
+
+// Config is a virtual host declared in the virtual-hosts section of the config file
+type Config struct {
+	// Name identifies the virtual host in logs, the application name is used when empty
+	Name string `mapstructure:"name"`
+	// Hosts are exact names, e.g. api.example.com, or wildcard subdomains, e.g. *.example.com
+	Hosts []string `mapstructure:"hosts"`
+	// Default serves the requests whose host matches no virtual host
+	Default bool `mapstructure:"default"`
+	// ApplicationName is the application label of the metrics and the OTel resource
+	ApplicationName string `mapstructure:"application-name"`
+	// TargetHosts are the upstreams, requests are spread round-robin
+	TargetHosts []string       `mapstructure:"target-hosts"`
+	UrlPatterns []string       `mapstructure:"url-patterns"`
+	Routes      []route.Config `mapstructure:"routes"`
+	// OpenAPIFile is the OpenAPI document of the virtual host, the openapi flags apply to it
+	OpenAPIFile string `mapstructure:"openapi-file"`
+	// TLSCertFile and TLSKeyFile are served to clients sending one of the hosts as SNI
+	TLSCertFile string `mapstructure:"tls-cert-file"`
+	TLSKeyFile  string `mapstructure:"tls-key-file"`
+	// Shadow mirrors requests to a shadow upstream, unset limits are those of the shadow flags
+	Shadow shadow.Config `mapstructure:"shadow"`
+}
+
+// Validate checks the hosts, upstreams, patterns and routes of the virtual hosts
+func Validate(configs []Config) error {
+	hosts := make(map[string]string)
+	defaults := 0
+	for _, cfg := range configs {
+		name := cfg.DisplayName()
+		if cfg.ApplicationName == "" {
+			return fmt.Errorf("virtual host %q requires an application-name", cfg.Name)
+		}
+		if len(cfg.Hosts) == 0 && !cfg.Default {
+			return fmt.Errorf("virtual host %q requires hosts or default", name)
+		}
+		if cfg.Default {
+			defaults++
+		}
+		for _, host := range cfg.Hosts {
+			host = normalizeHost(host)
+			if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+				return fmt.Errorf("invalid host %q of virtual host %s, wildcards are only allowed as first label, e.g. *.example.com", host, name)
+			}
+			if other, exists := hosts[host]; exists {
+				return fmt.Errorf("host %q is declared by virtual hosts %s and %s", host, other, name)
+			}
+			hosts[host] = name
+		}
+		if len(cfg.TargetHosts) == 0 {
+			return fmt.Errorf("virtual host %q requires target-hosts", name)
+		}
+		for _, target := range cfg.TargetHosts {
+			if _, err := url.Parse(target); err != nil {
+				return errors.Join(fmt.Errorf("invalid target host of virtual host %s", name), err)
+			}
+		}
+		for _, pattern := range cfg.UrlPatterns {
+			if err := utils.ValidatePattern(pattern); err != nil {
+				return errors.Join(fmt.Errorf("invalid url pattern of virtual host %s", name), err)
+			}
+		}
+		if _, err := route.NewTable(cfg.Routes); err != nil {
+			return errors.Join(fmt.Errorf("invalid routes of virtual host %s", name), err)
+		}
+		if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+			return fmt.Errorf("virtual host %q requires both tls-cert-file and tls-key-file", name)
+		}
+	}
+	if defaults > 1 {
+		return errors.New("only one virtual host can be the default")
+	}
+	return nil
+}
+
+// DisplayName returns the name of the virtual host, or its application name
+func (c Config) DisplayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.ApplicationName
+}
+
+// Router dispatches requests to the handler of the virtual host matching the
+// Host header, or the SNI of the TLS connection when the header is missing.
+// Exact hosts win over wildcards, the longest wildcard wins over shorter ones.
+type Router struct {
+	exact    map[string]*entry
+	wildcard []*entry
+	fallback *entry
+}
+
+type entry struct {
+	// suffix is the wildcard without the star, e.g. .example.com
+	suffix      string
+	handler     http.Handler
+	certificate *tls.Certificate
+}
+
+func NewRouter() *Router {
+	return &Router{exact: make(map[string]*entry)}
+}
+
+// Handle registers the handler of the hosts, certificate may be nil
+func (rt *Router) Handle(hosts []string, isDefault bool, handler http.Handler, certificate *tls.Certificate) {
+	e := &entry{handler: handler, certificate: certificate}
+	for _, host := range hosts {
+		host = normalizeHost(host)
+		if suffix, ok := strings.CutPrefix(host, "*"); ok {
+			rt.wildcard = append(rt.wildcard, &entry{suffix: suffix, handler: handler, certificate: certificate})
+			continue
+		}
+		rt.exact[host] = e
+	}
+	sort.SliceStable(rt.wildcard, func(i, j int) bool {
+		return len(rt.wildcard[i].suffix) > len(rt.wildcard[j].suffix)
+	})
+	if isDefault {
+		rt.fallback = e
+	}
+}
+
+func (rt *Router) match(host string) *entry {
+	host = normalizeHost(host)
+	if e, ok := rt.exact[host]; ok {
+		return e
+	}
+	for _, e := range rt.wildcard {
+		// the wildcard covers one or more labels, not the bare domain
+		if strings.HasSuffix(host, e.suffix) && len(host) > len(e.suffix) {
+			return e
+		}
+	}
+	return rt.fallback
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if host == "" && r.TLS != nil {
+		host = r.TLS.ServerName
+	}
+	e := rt.match(host)
+	if e == nil {
+		domain.WriteError(w, http.StatusNotFound, domain.ErrorResponse{
+			Error:   "unknown_host",
+			Message: fmt.Sprintf("no virtual host serves %q", host),
+			Method:  r.Method,
+			Path:    r.URL.Path,
+		})
+		return
+	}
+	e.handler.ServeHTTP(w, r)
+}
+
+// HasCertificates reports whether a virtual host serves TLS
+func (rt *Router) HasCertificates() bool {
+	if rt.fallback != nil && rt.fallback.certificate != nil {
+		return true
+	}
+	for _, e := range rt.exact {
+		if e.certificate != nil {
+			return true
+		}
+	}
+	for _, e := range rt.wildcard {
+		if e.certificate != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCertificate selects the certificate of the virtual host named by the SNI,
+// falling back to the certificate of the default virtual host
+func (rt *Router) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if e := rt.match(hello.ServerName); e != nil && e.certificate != nil {
+		return e.certificate, nil
+	}
+	if rt.fallback != nil && rt.fallback.certificate != nil {
+		return rt.fallback.certificate, nil
+	}
+	return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+}
+
+// normalizeHost lowercases the host and removes the port
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,71 @@
+package vhost
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	})
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Handle([]string{"api.example.com"}, false, named("api"), nil)
+	router.Handle([]string{"*.example.com"}, false, named("wildcard"), nil)
+	router.Handle([]string{"*.eu.example.com"}, false, named("eu"), nil)
+	router.Handle(nil, true, named("default"), nil)
+
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"api.example.com", "api"},
+		{"API.example.com:8080", "api"},
+		{"shop.example.com", "wildcard"},
+		{"shop.eu.example.com", "eu"},
+		{"example.com", "default"},
+		{"other.org", "default"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if got := w.Body.String(); got != tt.expected {
+			t.Errorf("host %s served by %s; want %s", tt.host, got, tt.expected)
+		}
+	}
+}
+
+func TestRouterUnknownHost(t *testing.T) {
+	router := NewRouter()
+	router.Handle([]string{"api.example.com"}, false, named("api"), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = "other.org"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d; want 404", w.Code)
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	api, fallback := &tls.Certificate{}, &tls.Certificate{}
+	router := NewRouter()
+	router.Handle([]string{"api.example.com"}, false, named("api"), api)
+	router.Handle([]string{"*.example.com"}, false, named("wildcard"), nil)
+	router.Handle(nil, true, named("default"), fallback)
+
+	if got, _ := router.GetCertificate(&tls.ClientHelloInfo{ServerName: "api.example.com"}); got != api {
+		t.Error("api.example.com did not get its certificate")
+	}
+	if got, _ := router.GetCertificate(&tls.ClientHelloInfo{ServerName: "shop.example.com"}); got != fallback {
+		t.Error("shop.example.com did not get the default certificate")
+	}
+}
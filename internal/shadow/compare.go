@@ -0,0 +1,321 @@
+package shadow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MAX_DIFFERENCES is the number of differences kept per mismatch
+const MAX_DIFFERENCES = 20
+
+// Results of a comparison
+const (
+	RESULT_MATCH    = "match"
+	RESULT_MISMATCH = "mismatch"
+	// RESULT_SKIPPED is counted when one of the responses could not be read whole
+	RESULT_SKIPPED = "skipped"
+)
+
+// CompareConfig compares the responses of the primary and shadow upstreams
+// instead of only measuring the shadow upstream
+type CompareConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Headers are the response headers compared, the status and body always are
+	Headers []string `mapstructure:"headers"`
+	// IgnoreFields are JSON fields left out of the comparison, a name such as
+	// timestamp matches the field at any depth while a dotted path such as
+	// data.*.id is anchored at the root of the body, * matching any key or index
+	IgnoreFields []string `mapstructure:"ignore-fields"`
+}
+
+// Difference is a field whose value differs between the two responses, a
+// field found in only one of them is reported by Missing
+type Difference struct {
+	Path    string `json:"path"`
+	Primary any    `json:"primary"`
+	Shadow  any    `json:"shadow"`
+	// Missing is primary or shadow when the field is absent from that response
+	Missing string `json:"missing,omitempty"`
+}
+
+// response is the part of an upstream response that is compared
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+	// truncated is set when the body is larger than the max body size
+	truncated bool
+}
+
+type comparisonKey struct{}
+
+// Comparison pairs a mirrored request with the response of the primary upstream
+type Comparison struct {
+	primary chan *response
+	once    sync.Once
+	max     int64
+}
+
+func newComparison(max int64) *Comparison {
+	return &Comparison{primary: make(chan *response, 1), max: max}
+}
+
+// WithComparison returns a copy of ctx holding the comparison
+func WithComparison(ctx context.Context, c *Comparison) context.Context {
+	return context.WithValue(ctx, comparisonKey{}, c)
+}
+
+// ComparisonFromContext returns the comparison of the request, if any
+func ComparisonFromContext(ctx context.Context) (*Comparison, bool) {
+	c, ok := ctx.Value(comparisonKey{}).(*Comparison)
+	return c, ok
+}
+
+// Primary reads the response of the primary upstream as the client receives
+// it, the response is compared once its body is closed
+func (c *Comparison) Primary(resp *http.Response) {
+	body := &primaryBody{ReadCloser: resp.Body, comparison: c}
+	body.response = &response{status: resp.StatusCode, header: resp.Header.Clone()}
+	resp.Body = body
+}
+
+// Abort gives up on the comparison, e.g. when the primary upstream failed
+func (c *Comparison) Abort() {
+	c.deliver(nil)
+}
+
+func (c *Comparison) deliver(resp *response) {
+	c.once.Do(func() { c.primary <- resp })
+}
+
+// primaryBody keeps the first bytes of the body of the primary upstream
+type primaryBody struct {
+	io.ReadCloser
+	comparison *Comparison
+	response   *response
+	buf        bytes.Buffer
+	eof        bool
+}
+
+func (b *primaryBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.comparison.max + 1 - int64(b.buf.Len()); room > 0 {
+		b.buf.Write(p[:min(int64(n), room)])
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Close hands the response over, a body the client stopped reading is not compared
+func (b *primaryBody) Close() error {
+	if b.eof || int64(b.buf.Len()) > b.comparison.max {
+		b.response.body = b.buf.Bytes()
+		b.response.truncated = int64(len(b.response.body)) > b.comparison.max
+		b.comparison.deliver(b.response)
+	} else {
+		b.comparison.deliver(nil)
+	}
+	return b.ReadCloser.Close()
+}
+
+// compare returns the differences of the two responses, more is set when
+// there were more than MAX_DIFFERENCES
+func (cfg CompareConfig) compare(primary, shadow *response) (differences []Difference, more bool) {
+	d := &differ{ignore: cfg.IgnoreFields}
+	if primary.status != shadow.status {
+		d.add(Difference{Path: "status", Primary: primary.status, Shadow: shadow.status})
+	}
+	for _, name := range cfg.Headers {
+		p, s := primary.header.Values(name), shadow.header.Values(name)
+		if !reflect.DeepEqual(p, s) {
+			d.add(Difference{Path: "header." + http.CanonicalHeaderKey(name), Primary: strings.Join(p, ", "), Shadow: strings.Join(s, ", ")})
+		}
+	}
+	// bodies cut by the max body size can not be told apart
+	if !primary.truncated && !shadow.truncated {
+		d.body(primary, shadow)
+	}
+	return d.differences, d.more
+}
+
+type differ struct {
+	ignore      []string
+	differences []Difference
+	more        bool
+}
+
+func (d *differ) add(difference Difference) {
+	if len(d.differences) == MAX_DIFFERENCES {
+		d.more = true
+		return
+	}
+	d.differences = append(d.differences, difference)
+}
+
+// body compares JSON bodies field by field and other bodies byte for byte
+func (d *differ) body(primary, shadow *response) {
+	p, s := decodedBody(primary), decodedBody(shadow)
+	if isJSON(primary.header) && isJSON(shadow.header) {
+		pv, perr := decodeJSON(p)
+		sv, serr := decodeJSON(s)
+		if perr == nil && serr == nil {
+			d.json(nil, pv, sv)
+			return
+		}
+	}
+	if !bytes.Equal(p, s) {
+		d.add(Difference{Path: "body", Primary: fmt.Sprintf("%d bytes", len(p)), Shadow: fmt.Sprintf("%d bytes", len(s))})
+	}
+}
+
+func (d *differ) json(path []string, primary, shadow any) {
+	if d.ignored(path) {
+		return
+	}
+	switch p := primary.(type) {
+	case map[string]any:
+		if s, ok := shadow.(map[string]any); ok {
+			keys := make([]string, 0, len(p)+len(s))
+			for key := range p {
+				keys = append(keys, key)
+			}
+			for key := range s {
+				if _, ok := p[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				d.field(child(path, key), p, s, key)
+			}
+			return
+		}
+	case []any:
+		if s, ok := shadow.([]any); ok {
+			for i := 0; i < max(len(p), len(s)); i++ {
+				fieldPath := child(path, strconv.Itoa(i))
+				switch {
+				case i >= len(s):
+					d.missing(fieldPath, p[i], "shadow")
+				case i >= len(p):
+					d.missing(fieldPath, s[i], "primary")
+				default:
+					d.json(fieldPath, p[i], s[i])
+				}
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(primary, shadow) {
+		d.add(Difference{Path: jsonPath(path), Primary: primary, Shadow: shadow})
+	}
+}
+
+func (d *differ) field(path []string, primary, shadow map[string]any, key string) {
+	p, inPrimary := primary[key]
+	s, inShadow := shadow[key]
+	switch {
+	case !inShadow:
+		d.missing(path, p, "shadow")
+	case !inPrimary:
+		d.missing(path, s, "primary")
+	default:
+		d.json(path, p, s)
+	}
+}
+
+func (d *differ) missing(path []string, value any, missing string) {
+	if d.ignored(path) {
+		return
+	}
+	difference := Difference{Path: jsonPath(path), Missing: missing}
+	if missing == "shadow" {
+		difference.Primary = value
+	} else {
+		difference.Shadow = value
+	}
+	d.add(difference)
+}
+
+// ignored reports whether the path matches one of the ignored fields
+func (d *differ) ignored(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	for _, field := range d.ignore {
+		if !strings.Contains(field, ".") {
+			if field == path[len(path)-1] {
+				return true
+			}
+			continue
+		}
+		segments := strings.Split(field, ".")
+		if len(segments) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range segments {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// child returns the path of a field, the parent path is left untouched
+func child(path []string, segment string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), segment)
+}
+
+func jsonPath(path []string) string {
+	return strings.Join(append([]string{"body"}, path...), ".")
+}
+
+func isJSON(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func decodeJSON(body []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// numbers are compared as written, 1.0 and 1 differ
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+// decodedBody returns the body without its gzip content encoding, upstreams
+// may compress differently the same content
+func decodedBody(resp *response) []byte {
+	if !strings.EqualFold(resp.header.Get("Content-Encoding"), "gzip") {
+		return resp.body
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(resp.body))
+	if err != nil {
+		return resp.body
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return resp.body
+	}
+	return body
+}
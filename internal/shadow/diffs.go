@@ -0,0 +1,99 @@
+package shadow
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+// Mismatch is a request whose primary and shadow responses differ
+type Mismatch struct {
+	Time        time.Time    `json:"time"`
+	Application string       `json:"application"`
+	Route       string       `json:"route"`
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	RequestID   string       `json:"request_id,omitempty"`
+	Differences []Difference `json:"differences"`
+	// More is set when the responses had more than MAX_DIFFERENCES differences
+	More bool `json:"more,omitempty"`
+}
+
+// DiffLog keeps the latest mismatches of every mirror for the admin endpoint
+type DiffLog struct {
+	mu         sync.Mutex
+	mismatches []Mismatch
+	next       int
+	full       bool
+	redactor   *redact.Redactor
+}
+
+// NewDiffLog creates a log keeping the size latest mismatches, their values
+// are redacted as they are added
+func NewDiffLog(size int, redactor *redact.Redactor) *DiffLog {
+	return &DiffLog{mismatches: make([]Mismatch, size), redactor: redactor}
+}
+
+// Add redacts and keeps the mismatch, the redacted mismatch is returned
+func (l *DiffLog) Add(mismatch Mismatch) Mismatch {
+	mismatch.URL = l.redactor.URL(l.redactor.String(mismatch.URL))
+	for i, difference := range mismatch.Differences {
+		mismatch.Differences[i].Primary = l.redactValue(difference.Path, difference.Primary)
+		mismatch.Differences[i].Shadow = l.redactValue(difference.Path, difference.Shadow)
+	}
+	if len(l.mismatches) == 0 {
+		return mismatch
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mismatches[l.next] = mismatch
+	l.next = (l.next + 1) % len(l.mismatches)
+	l.full = l.full || l.next == 0
+	return mismatch
+}
+
+// List returns the latest mismatches first, application and route filter
+// them when not empty and limit caps their number when positive
+func (l *DiffLog) List(application, route string, limit int) []Mismatch {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.mismatches)
+	}
+	mismatches := []Mismatch{}
+	for i := 1; i <= count; i++ {
+		mismatch := l.mismatches[(l.next-i+len(l.mismatches))%len(l.mismatches)]
+		if (application != "" && mismatch.Application != application) || (route != "" && mismatch.Route != route) {
+			continue
+		}
+		mismatches = append(mismatches, mismatch)
+		if len(mismatches) == limit {
+			break
+		}
+	}
+	return mismatches
+}
+
+// Clear removes every mismatch
+func (l *DiffLog) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.mismatches)
+	l.next, l.full = 0, false
+}
+
+// redactValue masks the values of sensitive fields and headers and scrubs
+// the patterns from the other strings
+func (l *DiffLog) redactValue(path string, value any) any {
+	field := path[strings.LastIndex(path, ".")+1:]
+	if strings.HasPrefix(path, "header.") && l.redactor.IsSensitiveHeader(field) {
+		return redact.REDACTED
+	}
+	if strings.HasPrefix(path, "body.") && l.redactor.IsSensitiveParam(field) {
+		return redact.REDACTED
+	}
+	return l.redactor.Value(value)
+}
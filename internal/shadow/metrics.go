@@ -0,0 +1,67 @@
+package shadow
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the shadow upstreams, kept apart from the request metrics so
+// that the error rates of the primary and shadow upstreams can be compared
+type Metrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	dropped  *prometheus.CounterVec
+	results  *prometheus.CounterVec
+}
+
+// NewMetrics registers the shadow metrics, namespace, subsystem and const
+// labels are those of the request metrics
+func NewMetrics(registerer prometheus.Registerer, namespace, subsystem string, constLabels map[string]string, buckets []float64) *Metrics {
+	factory := promauto.With(registerer)
+	return &Metrics{
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "shadow_api_requests",
+			Help:        "Total count of requests mirrored to the shadow upstream by status code, route and method",
+			ConstLabels: constLabels,
+		}, []string{"application", "route", "method", "status_code"}),
+		latency: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "shadow_http_request_latency",
+			Help:        "Latency of requests mirrored to the shadow upstream",
+			ConstLabels: constLabels,
+			Buckets:     buckets,
+		}, []string{"application", "route", "method"}),
+		dropped: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "shadow_dropped_requests",
+			Help:        "Total count of requests not mirrored by reason: concurrency or body_size",
+			ConstLabels: constLabels,
+		}, []string{"application", "reason"}),
+		results: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "shadow_compared_requests",
+			Help:        "Total count of requests whose primary and shadow responses were compared by route and result: match, mismatch or skipped",
+			ConstLabels: constLabels,
+		}, []string{"application", "route", "result"}),
+	}
+}
+
+func (m *Metrics) observe(application, route, method, status string, latency time.Duration) {
+	m.requests.WithLabelValues(application, route, method, status).Inc()
+	m.latency.WithLabelValues(application, route, method).Observe(latency.Seconds())
+}
+
+func (m *Metrics) drop(application, reason string) {
+	m.dropped.WithLabelValues(application, reason).Inc()
+}
+
+func (m *Metrics) compared(application, route, result string) {
+	m.results.WithLabelValues(application, route, result).Inc()
+}
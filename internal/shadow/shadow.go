@@ -0,0 +1,307 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tae2089/reverse-proxy/internal/log"
+	"github.com/tae2089/reverse-proxy/internal/server/domain"
+	"github.com/tae2089/reverse-proxy/internal/utils"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// loggerName is the logger of the mirror, its level can be changed on /admin/log/level
+const loggerName = "shadow"
+
+// SHADOW_HEADER marks mirrored requests so that the shadow upstream can tell them apart
+const SHADOW_HEADER = "X-Shadow-Request"
+
+// Reasons a request was not mirrored
+const (
+	DROP_CONCURRENCY = "concurrency"
+	DROP_BODY_SIZE   = "body_size"
+)
+
+// Config is the shadow upstream of a virtual host, mirroring is disabled
+// when Target is empty
+type Config struct {
+	Target string `mapstructure:"target"`
+	// Percent of the requests mirrored, routes can override it
+	Percent        float64       `mapstructure:"percent"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+	MaxConcurrency int           `mapstructure:"max-concurrency"`
+	// MaxBodyBytes is the largest request body buffered for the mirror,
+	// requests with a larger body are not mirrored, and the largest response
+	// body compared
+	MaxBodyBytes int64         `mapstructure:"max-body-size"`
+	Compare      CompareConfig `mapstructure:"compare"`
+}
+
+// Validate checks the target and the limits
+func (c Config) Validate() error {
+	if c.Target == "" {
+		return nil
+	}
+	u, err := url.Parse(c.Target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid shadow target %q, expected an url such as http://localhost:8081", c.Target)
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("shadow percent must be between 0 and 100, got %v", c.Percent)
+	}
+	if c.Timeout <= 0 || c.MaxConcurrency <= 0 {
+		return errors.New("shadow timeout and max concurrency must be greater than 0")
+	}
+	if c.MaxBodyBytes < 0 {
+		return errors.New("shadow max body size must not be negative")
+	}
+	return nil
+}
+
+// Inherit fills the unset percent and limits with those of defaults, it lets
+// virtual hosts only declare their shadow target
+func (c Config) Inherit(defaults Config) Config {
+	if c.Percent == 0 {
+		c.Percent = defaults.Percent
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.MaxConcurrency == 0 {
+		c.MaxConcurrency = defaults.MaxConcurrency
+	}
+	if c.MaxBodyBytes == 0 {
+		c.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+	c.Compare.Enabled = c.Compare.Enabled || defaults.Compare.Enabled
+	if c.Compare.Headers == nil {
+		c.Compare.Headers = defaults.Compare.Headers
+	}
+	if c.Compare.IgnoreFields == nil {
+		c.Compare.IgnoreFields = defaults.Compare.IgnoreFields
+	}
+	return c
+}
+
+// Mirror sends copies of requests to the shadow upstream, the responses are
+// only measured and never reach the client
+type Mirror struct {
+	cfg         Config
+	target      *url.URL
+	client      *http.Client
+	slots       chan struct{}
+	metrics     *Metrics
+	diffs       *DiffLog
+	application string
+}
+
+// New creates the mirror of an application, it returns nil when the target is
+// empty. diffs keeps the mismatches when the responses are compared.
+func New(cfg Config, metrics *Metrics, diffs *DiffLog, application string) (*Mirror, error) {
+	if cfg.Target == "" {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	target, _ := url.Parse(cfg.Target)
+	return &Mirror{
+		cfg:    cfg,
+		target: target,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// redirects are measured as answered by the shadow upstream
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Transport: &http.Transport{
+				Proxy:               nil,
+				MaxIdleConnsPerHost: cfg.MaxConcurrency,
+			},
+		},
+		slots:       make(chan struct{}, cfg.MaxConcurrency),
+		metrics:     metrics,
+		diffs:       diffs,
+		application: application,
+	}, nil
+}
+
+// Sample reports whether a request is mirrored, percent overrides the
+// percent of the mirror when not nil
+func (m *Mirror) Sample(percent *float64) bool {
+	p := m.cfg.Percent
+	if percent != nil {
+		p = *percent
+	}
+	return p >= 100 || rand.Float64()*100 < p
+}
+
+// Send mirrors the request as it is about to be sent to the primary upstream,
+// the body of r is buffered and replaced so that the primary still reads it
+// whole. It returns at once, the mirror runs on its own goroutine. When the
+// responses are compared the returned comparison must be given the response
+// of the primary upstream, it is nil otherwise.
+func (m *Mirror) Send(r *http.Request, route string) *Comparison {
+	// the body is only buffered once a slot is taken
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.metrics.drop(m.application, DROP_CONCURRENCY)
+		return nil
+	}
+	body, ok := m.bufferBody(r)
+	if !ok {
+		<-m.slots
+		m.metrics.drop(m.application, DROP_BODY_SIZE)
+		return nil
+	}
+	var comparison *Comparison
+	if m.cfg.Compare.Enabled {
+		comparison = newComparison(m.cfg.MaxBodyBytes)
+	}
+	// the mirror outlives the client request and only keeps the values it logs
+	// with, other values such as the client trace of the primary request must
+	// not observe the shadow request
+	ctx := log.WithLoggerOf(context.Background(), r.Context())
+	ctx = domain.WithRequestID(ctx, domain.RequestIDFromContext(r.Context()))
+	ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(r.Context()))
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	req := r.Clone(ctx)
+	req.RequestURI = ""
+	req.URL.Scheme = m.target.Scheme
+	req.URL.Host = m.target.Host
+	req.URL.Path = singleJoiningSlash(m.target.Path, r.URL.Path)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	// the shadow upstream sees the client as the primary upstream does
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := req.Header["X-Forwarded-For"]; len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		req.Header.Set("X-Forwarded-For", clientIP)
+	}
+	req.Header.Set(SHADOW_HEADER, "true")
+	uri := r.URL.RequestURI()
+	go func() {
+		defer func() { <-m.slots }()
+		defer cancel()
+		shadow := m.send(req, route, comparison != nil)
+		if comparison != nil {
+			m.compare(req, uri, route, comparison, shadow)
+		}
+	}()
+	return comparison
+}
+
+// send measures the response of the shadow upstream, it is returned when
+// keep is set and the response was read whole
+func (m *Mirror) send(req *http.Request, route string, keep bool) *response {
+	start := time.Now()
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.metrics.observe(m.application, route, req.Method, "error", time.Since(start))
+		log.FromContext(req.Context()).Named(loggerName).Debug("shadow request failed", zap.String("target", m.target.Host), zap.Error(err))
+		return nil
+	}
+	defer resp.Body.Close()
+	var body []byte
+	if keep {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, m.cfg.MaxBodyBytes+1))
+	}
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	status := utils.StatusClass(resp.StatusCode)
+	if err != nil {
+		status = "error"
+	}
+	m.metrics.observe(m.application, route, req.Method, status, time.Since(start))
+	if !keep || err != nil {
+		return nil
+	}
+	return &response{
+		status:    resp.StatusCode,
+		header:    resp.Header,
+		body:      body,
+		truncated: int64(len(body)) > m.cfg.MaxBodyBytes,
+	}
+}
+
+// compare waits for the response of the primary upstream, responses slower
+// than the shadow timeout are not compared
+func (m *Mirror) compare(req *http.Request, uri, route string, comparison *Comparison, shadow *response) {
+	var primary *response
+	select {
+	case primary = <-comparison.primary:
+	case <-time.After(m.cfg.Timeout):
+	}
+	if primary == nil || shadow == nil {
+		m.metrics.compared(m.application, route, RESULT_SKIPPED)
+		return
+	}
+	differences, more := m.cfg.Compare.compare(primary, shadow)
+	if len(differences) == 0 {
+		m.metrics.compared(m.application, route, RESULT_MATCH)
+		return
+	}
+	mismatch := m.diffs.Add(Mismatch{
+		Time:        time.Now(),
+		Application: m.application,
+		Route:       route,
+		Method:      req.Method,
+		URL:         uri,
+		RequestID:   domain.RequestIDFromContext(req.Context()),
+		Differences: differences,
+		More:        more,
+	})
+	m.metrics.compared(m.application, route, RESULT_MISMATCH)
+	log.FromContext(req.Context()).Named(loggerName).Warn("shadow response differs",
+		zap.String("route", route),
+		zap.String("method", mismatch.Method),
+		zap.String("url", mismatch.URL),
+		zap.Any("differences", mismatch.Differences),
+		zap.Bool("more", more),
+	)
+}
+
+// bufferBody reads the body up to the max body size and puts back a body
+// serving the read bytes then the rest, ok is false when the body is larger
+func (m *Mirror) bufferBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBodyBytes+1))
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+	if err != nil || int64(len(body)) > m.cfg.MaxBodyBytes {
+		return nil, false
+	}
+	return body, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// singleJoiningSlash joins the path of the target and of the request as httputil does
+func singleJoiningSlash(a, b string) string {
+	aslash := len(a) > 0 && a[len(a)-1] == '/'
+	bslash := len(b) > 0 && b[0] == '/'
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash && a != "":
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,162 @@
+package shadow
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tae2089/reverse-proxy/internal/redact"
+)
+
+func TestSend(t *testing.T) {
+	type mirrored struct {
+		path, body, header string
+	}
+	received := make(chan mirrored, 1)
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirrored{path: r.URL.Path, body: string(body), header: r.Header.Get(SHADOW_HEADER)}
+		<-release
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer target.Close()
+
+	metrics := NewMetrics(prometheus.NewRegistry(), "", "", nil, prometheus.DefBuckets)
+	mirror, err := New(Config{Target: target.URL + "/shadow", Percent: 100, Timeout: time.Second, MaxConcurrency: 1, MaxBodyBytes: 16}, metrics, nil, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client trace of the primary request must not observe the shadow request
+	var traced atomic.Bool
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"kim"}`))
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), &httptrace.ClientTrace{
+		GetConn: func(string) { traced.Store(true) },
+	}))
+	mirror.Send(r, "users")
+	if body, _ := io.ReadAll(r.Body); string(body) != `{"name":"kim"}` {
+		t.Errorf("primary body = %q", body)
+	}
+	got := <-received
+	if got.path != "/shadow/users" || got.body != `{"name":"kim"}` || got.header != "true" {
+		t.Errorf("mirrored = %+v", got)
+	}
+	if traced.Load() {
+		t.Error("client trace of the primary request observed the shadow request")
+	}
+
+	// the only slot is taken until the shadow upstream answers, the body of a
+	// dropped request is not buffered
+	dropped := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"lee"}`))
+	body := dropped.Body
+	mirror.Send(dropped, "users")
+	if n := testutil.ToFloat64(metrics.dropped.WithLabelValues("app", DROP_CONCURRENCY)); n != 1 {
+		t.Errorf("dropped on concurrency = %v, want 1", n)
+	}
+	if dropped.Body != body {
+		t.Error("body of a request dropped on concurrency was buffered")
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(metrics.requests.WithLabelValues("app", "users", http.MethodPost, "5xx")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("shadow response was not measured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the slot is released after the response is measured
+	for len(mirror.slots) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("slot was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	large := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(strings.Repeat("a", 32)))
+	mirror.Send(large, "users")
+	if body, _ := io.ReadAll(large.Body); len(body) != 32 {
+		t.Errorf("primary body of a large request = %d bytes, want 32", len(body))
+	}
+	if n := testutil.ToFloat64(metrics.dropped.WithLabelValues("app", DROP_BODY_SIZE)); n != 1 {
+		t.Errorf("dropped on body size = %v, want 1", n)
+	}
+	if len(mirror.slots) != 0 {
+		t.Error("slot of a request dropped on body size was kept")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cfg := CompareConfig{Headers: []string{"Content-Type"}, IgnoreFields: []string{"timestamp", "items.*.id"}}
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	primary := &response{status: 200, header: jsonHeader, body: []byte(`{"timestamp":1,"name":"kim","items":[{"id":1,"qty":2}],"meta":{"v":1}}`)}
+	shadow := &response{status: 200, header: jsonHeader, body: []byte(`{"timestamp":2,"name":"lee","items":[{"id":9,"qty":2},{"id":3}],"meta":{}}`)}
+
+	differences, more := cfg.compare(primary, shadow)
+	expected := []Difference{
+		{Path: "body.items.1", Shadow: map[string]any{"id": json.Number("3")}, Missing: "primary"},
+		{Path: "body.meta.v", Primary: json.Number("1"), Missing: "shadow"},
+		{Path: "body.name", Primary: "kim", Shadow: "lee"},
+	}
+	if !reflect.DeepEqual(differences, expected) || more {
+		t.Errorf("differences = %+v, want %+v", differences, expected)
+	}
+
+	text := &response{status: 500, header: http.Header{"Content-Type": {"text/plain"}}, body: []byte("oops")}
+	differences, _ = cfg.compare(primary, text)
+	if len(differences) != 3 || differences[0].Path != "status" || differences[1].Path != "header.Content-Type" || differences[2].Path != "body" {
+		t.Errorf("differences with a text response = %+v", differences)
+	}
+}
+
+func TestCompareWithPrimary(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"user":"kim@example.com","password":"candidate"}`))
+	}))
+	defer target.Close()
+
+	metrics := NewMetrics(prometheus.NewRegistry(), "", "", nil, prometheus.DefBuckets)
+	diffs := NewDiffLog(2, redact.Default())
+	mirror, err := New(Config{Target: target.URL, Percent: 100, Timeout: time.Second, MaxConcurrency: 1, MaxBodyBytes: 1024, Compare: CompareConfig{Enabled: true}}, metrics, diffs, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparison := mirror.Send(httptest.NewRequest(http.MethodGet, "/users/1", nil), "users")
+	if comparison == nil {
+		t.Fatal("comparison is nil")
+	}
+	primary := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"user":"kim@example.com","password":"primary"}`)),
+	}
+	comparison.Primary(primary)
+	io.ReadAll(primary.Body)
+	primary.Body.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(metrics.results.WithLabelValues("app", "users", RESULT_MISMATCH)) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("mismatch was not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mismatches := diffs.List("", "users", 0)
+	if len(mismatches) != 1 || len(mismatches[0].Differences) != 1 {
+		t.Fatalf("mismatches = %+v", mismatches)
+	}
+	if difference := mismatches[0].Differences[0]; difference.Path != "body.password" || difference.Primary != redact.REDACTED || difference.Shadow != redact.REDACTED {
+		t.Errorf("difference = %+v, want redacted passwords", difference)
+	}
+}
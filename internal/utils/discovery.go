@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DEFAULT_VARIABLE_SEGMENT names a path variable whose values are not all of the same kind
+const DEFAULT_VARIABLE_SEGMENT = "{id}"
+
+// PatternSuggestion is a URL pattern inferred from live traffic
+type PatternSuggestion struct {
+	Pattern string `json:"pattern"`
+	Hits    int    `json:"hits"`
+}
+
+type discoveryNode struct {
+	segment       string
+	hits          int
+	children      map[string]*discoveryNode
+	variableChild *discoveryNode
+	// collapsed is set once the static children were merged into the variable child
+	collapsed bool
+}
+
+func newDiscoveryNode(segment string) *discoveryNode {
+	return &discoveryNode{
+		segment:  segment,
+		children: make(map[string]*discoveryNode),
+	}
+}
+
+// Discoverer infers URL patterns from observed paths. Segments that look
+// like identifiers are merged into a path variable right away, and a node
+// whose static children exceed the threshold is collapsed into a variable,
+// which keeps the memory bounded under random traffic.
+type Discoverer struct {
+	mu        sync.Mutex
+	root      *discoveryNode
+	threshold int
+}
+
+// NewDiscoverer creates a discoverer collapsing nodes with more than threshold distinct children
+func NewDiscoverer(threshold int) *Discoverer {
+	return &Discoverer{
+		root:      newDiscoveryNode(""),
+		threshold: threshold,
+	}
+}
+
+// Observe records a path that did not match any configured pattern
+func (d *Discoverer) Observe(path string) {
+	segments := strings.Split(path, "/")[1:]
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current := d.root
+	for _, segment := range segments {
+		current = d.child(current, segment)
+	}
+	current.hits++
+}
+
+// child returns the node of the segment below current, creating or collapsing nodes as needed
+func (d *Discoverer) child(current *discoveryNode, segment string) *discoveryNode {
+	if child, exists := current.children[segment]; exists {
+		return child
+	}
+	if placeholder, ok := NormalizeSegment(segment); ok {
+		return variableChild(current, placeholder)
+	}
+	if current.collapsed {
+		return current.variableChild
+	}
+	if d.threshold > 0 && len(current.children) >= d.threshold {
+		for key, child := range current.children {
+			mergeDiscoveryNodes(variableChild(current, DEFAULT_VARIABLE_SEGMENT), child)
+			delete(current.children, key)
+		}
+		current.collapsed = true
+		return current.variableChild
+	}
+	child := newDiscoveryNode(segment)
+	current.children[segment] = child
+	return child
+}
+
+// variableChild returns the variable child of the node, renaming it when different kinds of values meet
+func variableChild(current *discoveryNode, placeholder string) *discoveryNode {
+	if current.variableChild == nil {
+		current.variableChild = newDiscoveryNode(placeholder)
+	} else if current.variableChild.segment != placeholder {
+		current.variableChild.segment = DEFAULT_VARIABLE_SEGMENT
+	}
+	return current.variableChild
+}
+
+func mergeDiscoveryNodes(dst, src *discoveryNode) {
+	dst.hits += src.hits
+	for segment, child := range src.children {
+		if existing, ok := dst.children[segment]; ok {
+			mergeDiscoveryNodes(existing, child)
+		} else {
+			dst.children[segment] = child
+		}
+	}
+	if src.variableChild != nil {
+		if dst.variableChild == nil {
+			dst.variableChild = src.variableChild
+		} else {
+			mergeDiscoveryNodes(dst.variableChild, src.variableChild)
+		}
+	}
+}
+
+// Suggest returns the inferred patterns ordered by hits
+func (d *Discoverer) Suggest() []PatternSuggestion {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var suggestions []PatternSuggestion
+	var walk func(n *discoveryNode, prefix string)
+	walk = func(n *discoveryNode, prefix string) {
+		if n.hits > 0 && n != d.root {
+			suggestions = append(suggestions, PatternSuggestion{Pattern: prefix, Hits: n.hits})
+		}
+		for _, child := range n.children {
+			walk(child, prefix+"/"+child.segment)
+		}
+		if n.variableChild != nil {
+			walk(n.variableChild, prefix+"/"+n.variableChild.segment)
+		}
+	}
+	walk(d.root, "")
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Hits != suggestions[j].Hits {
+			return suggestions[i].Hits > suggestions[j].Hits
+		}
+		return suggestions[i].Pattern < suggestions[j].Pattern
+	})
+	return suggestions
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiscovererSuggest(t *testing.T) {
+	d := NewDiscoverer(3)
+	for i := 0; i < 5; i++ {
+		d.Observe(fmt.Sprintf("/api/users/%d", i))
+		d.Observe(fmt.Sprintf("/api/users/%d/orders", i))
+	}
+	d.Observe("/api/users/me")
+	for _, name := range []string{"alpha", "beta", "gamma", "delta"} {
+		d.Observe("/blog/" + name)
+	}
+
+	expected := []PatternSuggestion{
+		{Pattern: "/api/users/{number}", Hits: 5},
+		{Pattern: "/api/users/{number}/orders", Hits: 5},
+		{Pattern: "/blog/{id}", Hits: 4},
+		{Pattern: "/api/users/me", Hits: 1},
+	}
+	got := d.Suggest()
+	if len(got) != len(expected) {
+		t.Fatalf("Suggest() = %v; want %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Suggest()[%d] = %v; want %v", i, got[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	PLACEHOLDER_NUMBER = "{number}"
+	PLACEHOLDER_UUID   = "{uuid}"
+	PLACEHOLDER_HEX    = "{hex}"
+	PLACEHOLDER_HASH   = "{hash}"
+)
+
+var (
+	numberSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegment    = regexp.MustCompile(`^(0x)?[0-9a-fA-F]{8,}$`)
+	// base64, base64url or other token-like strings
+	hashSegment = regexp.MustCompile(`^[A-Za-z0-9_\-+=.~]{20,}$`)
+	digit       = regexp.MustCompile(`[0-9]`)
+)
+
+// NormalizeSegment returns the placeholder for a high cardinality segment
+// such as a number, a UUID, a hex string or a hash, and false otherwise.
+func NormalizeSegment(segment string) (string, bool) {
+	switch {
+	case segment == "":
+		return segment, false
+	case numberSegment.MatchString(segment):
+		return PLACEHOLDER_NUMBER, true
+	case uuidSegment.MatchString(segment):
+		return PLACEHOLDER_UUID, true
+	case hexSegment.MatchString(segment) && digit.MatchString(segment):
+		return PLACEHOLDER_HEX, true
+	case hashSegment.MatchString(segment) && digit.MatchString(segment):
+		return PLACEHOLDER_HASH, true
+	}
+	return segment, false
+}
+
+// CollapsePath replaces every high cardinality segment of the path with its
+// placeholder and returns the number of collapsed segments.
+func CollapsePath(path string) (string, int) {
+	segments := strings.Split(path, "/")
+	collapsed := 0
+	for i, segment := range segments {
+		if placeholder, ok := NormalizeSegment(segment); ok {
+			segments[i] = placeholder
+			collapsed++
+		}
+	}
+	return strings.Join(segments, "/"), collapsed
+}
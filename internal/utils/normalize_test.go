@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+func TestCollapsePath(t *testing.T) {
+	tests := []struct {
+		path      string
+		expected  string
+		collapsed int
+	}{
+		{"/api/users", "/api/users", 0},
+		{"/api/users/42", "/api/users/{number}", 1},
+		{"/api/orders/3f2504e0-4f89-11d3-9a0c-0305e82c3301/items/7", "/api/orders/{uuid}/items/{number}", 2},
+		{"/commits/9fceb02d0ae598e95dc970b74767f19372d61af8", "/commits/{hex}", 1},
+		{"/files/deadbeef", "/files/deadbeef", 0},
+		{"/tokens/eyJhbGciOiJIUzI1NiJ9abc123XYZ", "/tokens/{hash}", 1},
+		{"/api/{id}/v2", "/api/{id}/v2", 0},
+	}
+	for _, tt := range tests {
+		got, collapsed := CollapsePath(tt.path)
+		if got != tt.expected || collapsed != tt.collapsed {
+			t.Errorf("CollapsePath(%q) = %q, %d; want %q, %d", tt.path, got, collapsed, tt.expected, tt.collapsed)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusFilter matches status codes such as 502 and classes such as 5xx
+type StatusFilter []statusMatcher
+
+type statusMatcher struct {
+	code  int
+	class bool
+}
+
+// ParseStatusFilter parses status codes and classes
+func ParseStatusFilter(statuses []string) (StatusFilter, error) {
+	filter := make(StatusFilter, 0, len(statuses))
+	for _, status := range statuses {
+		text := strings.ToLower(strings.TrimSpace(status))
+		class := len(text) == 3 && strings.HasSuffix(text, "xx")
+		if class {
+			text = text[:1]
+		}
+		code, err := strconv.Atoi(text)
+		if err != nil || (class && (code < 1 || code > 5)) || (!class && (code < 100 || code > 599)) {
+			return nil, fmt.Errorf("invalid status %q, expected a code such as 502 or a class such as 5xx", status)
+		}
+		filter = append(filter, statusMatcher{code: code, class: class})
+	}
+	return filter, nil
+}
+
+// Matches reports whether the status matches any code or class of the filter
+func (f StatusFilter) Matches(status int) bool {
+	for _, matcher := range f {
+		if matcher.class && status/100 == matcher.code || !matcher.class && status == matcher.code {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusClass returns the class of a status code used as metric label, e.g. 5xx
+func StatusClass(statusCode int) string {
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return "2xx"
+	case statusCode >= 300 && statusCode < 400:
+		return "3xx"
+	case statusCode >= 400 && statusCode < 500:
+		return "4xx"
+	case statusCode >= 500 && statusCode < 600:
+		return "5xx"
+	default:
+		return "xxx"
+	}
+}
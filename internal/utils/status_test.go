@@ -0,0 +1,20 @@
+package utils
+
+import "testing"
+
+func TestStatusFilter(t *testing.T) {
+	filter, err := ParseStatusFilter([]string{"5xx", "429"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for status, expected := range map[int]bool{500: true, 503: true, 429: true, 404: false, 200: false} {
+		if filter.Matches(status) != expected {
+			t.Errorf("Matches(%d) = %v", status, !expected)
+		}
+	}
+	for _, invalid := range []string{"6xx", "abc", "99"} {
+		if _, err := ParseStatusFilter([]string{invalid}); err == nil {
+			t.Errorf("%s accepted", invalid)
+		}
+	}
+}
@@ -1,12 +1,54 @@
 package utils
 
-import "strings"
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ANY_METHOD registers a pattern for every method
+const ANY_METHOD = "*"
+
+// typedParams are the constraints usable by name, e.g. {id:int}
+var typedParams = map[string]string{
+	"int":   `[0-9]+`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	"hex":   `[0-9a-fA-F]+`,
+	"alpha": `[A-Za-z]+`,
+}
+
+// param describes a path variable segment: {name}, {name:type}, {name:regex} or {name...}
+type param struct {
+	name       string
+	constraint *regexp.Regexp
+	catchAll   bool
+}
+
+func (p *param) accepts(segment string) bool {
+	return p.constraint == nil || p.constraint.MatchString(segment)
+}
 
 type node struct {
-	path           string
-	isPathVariable bool
-	children       map[string]*node
-	variableChild  *node
+	path string
+	// param is set on path variable nodes
+	param    *param
+	children map[string]*node
+	// paramChildren are ordered by priority, constrained params first
+	paramChildren []*node
+	catchAllChild *node
+	// isEnd is set on the last node of an inserted pattern
+	isEnd bool
+	// routes maps a method, or ANY_METHOD, to the route name of the pattern
+	routes map[string]string
+}
+
+func newNode(path string, p *param) *node {
+	return &node{
+		path:     path,
+		param:    p,
+		children: make(map[string]*node),
+	}
 }
 
 // Tree struct represents the entire tree with a root node
@@ -14,77 +56,272 @@ type Tree struct {
 	root *node
 }
 
+// Match is the result of a lookup in the tree
+type Match struct {
+	// Pattern is the matched pattern as inserted, e.g. /users/{id:int}
+	Pattern string
+	// Name is the route name given to InsertRoute
+	Name string
+	// Params holds the values of the path variables by name
+	Params map[string]string
+	// Segments is the number of path segments covered by the pattern
+	Segments int
+	// Partial is set when the pattern only covers a prefix of the path
+	Partial bool
+}
+
 // NewTree creates a new tree and initializes the root node
 func NewTree() *Tree {
 	return &Tree{
-		root: &node{
-			path:     "/",
-			children: make(map[string]*node),
-		},
+		root: newNode("/", nil),
+	}
+}
+
+// parseSegment returns the path variable of a segment, or nil for a static segment
+func parseSegment(segment string) (*param, error) {
+	if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+		return nil, nil
+	}
+	inner := segment[1 : len(segment)-1]
+	if name, ok := strings.CutSuffix(inner, "..."); ok {
+		return &param{name: name, catchAll: true}, nil
+	}
+	name, constraint, ok := strings.Cut(inner, ":")
+	if !ok {
+		return &param{name: name}, nil
+	}
+	if expr, ok := typedParams[constraint]; ok {
+		constraint = expr
+	}
+	re, err := regexp.Compile("^(?:" + constraint + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint of path variable %s: %w", segment, err)
+	}
+	return &param{name: name, constraint: re}, nil
+}
+
+// ValidatePattern checks the path variables of a pattern
+func ValidatePattern(pattern string) error {
+	segments := strings.Split(pattern, "/")[1:]
+	for i, segment := range segments {
+		p, err := parseSegment(segment)
+		if err != nil {
+			return err
+		}
+		if p != nil && p.catchAll && i != len(segments)-1 {
+			return fmt.Errorf("catch-all %s must be the last segment of %s", segment, pattern)
+		}
 	}
+	return nil
 }
 
 // Insert function inserts a path into the tree
-func (t *Tree) Insert(path string) {
+func (t *Tree) Insert(path string) error {
+	return t.InsertRoute(ANY_METHOD, path, "")
+}
+
+// InsertRoute inserts a path restricted to a method, name identifies the route
+// such as an OpenAPI operationId
+func (t *Tree) InsertRoute(method, path, name string) error {
+	if err := ValidatePattern(path); err != nil {
+		return err
+	}
 	segments := strings.Split(path, "/")[1:]
 	current := t.root
 
 	for _, segment := range segments {
-		isPathVariable := strings.HasPrefix(segment, "{")
-		var next *node
-		if isPathVariable {
-			if current.variableChild == nil {
-				current.variableChild = &node{
-					path:           segment,
-					isPathVariable: true,
-					children:       make(map[string]*node),
-				}
-			}
-			next = current.variableChild
-		} else {
+		// errors are checked by ValidatePattern
+		p, _ := parseSegment(segment)
+		switch {
+		case p == nil:
 			if _, exists := current.children[segment]; !exists {
-				current.children[segment] = &node{
-					path:           segment,
-					isPathVariable: false,
-					children:       make(map[string]*node),
-				}
+				current.children[segment] = newNode(segment, nil)
+			}
+			current = current.children[segment]
+		case p.catchAll:
+			if current.catchAllChild == nil {
+				current.catchAllChild = newNode(segment, p)
 			}
-			next = current.children[segment]
+			current = current.catchAllChild
+		default:
+			current = current.paramChild(segment, p)
 		}
-		current = next
 	}
+	current.isEnd = true
+	if current.routes == nil {
+		current.routes = make(map[string]string)
+	}
+	current.routes[strings.ToUpper(method)] = name
+	return nil
 }
 
-// Search function searches for a pattern and returns the pattern path
-func (t *Tree) Search(pattern string) string {
-	segments := strings.Split(pattern, "/")[1:]
-	current := t.root
-	var result []string
+// paramChild returns the child of the variable segment, keeping constrained params first
+func (n *node) paramChild(segment string, p *param) *node {
+	for _, child := range n.paramChildren {
+		if child.path == segment {
+			return child
+		}
+	}
+	child := newNode(segment, p)
+	n.paramChildren = append(n.paramChildren, child)
+	sort.SliceStable(n.paramChildren, func(i, j int) bool {
+		return n.paramChildren[i].param.constraint != nil && n.paramChildren[j].param.constraint == nil
+	})
+	return child
+}
 
-	for _, segment := range segments {
-		if child, exists := current.children[segment]; exists {
-			result = append(result, child.path)
-			current = child
-		} else if current.variableChild != nil {
-			result = append(result, current.variableChild.path)
-			current = current.variableChild
-		} else {
+// Match returns the most specific route covering every segment of the path for
+// the method. Static segments win over constrained variables, which win over
+// plain variables and finally catch-alls; a failing branch is backtracked.
+func (t *Tree) Match(method, path string) (*Match, bool) {
+	segments := strings.Split(path, "/")[1:]
+	var matched []*node
+	var end *node
+	var walk func(n *node, i int) bool
+	walk = func(n *node, i int) bool {
+		if i == len(segments) {
+			if n.hasRoute(method) {
+				end = n
+				return true
+			}
+			return false
+		}
+		segment := segments[i]
+		if child, exists := n.children[segment]; exists {
+			matched = append(matched, child)
+			if walk(child, i+1) {
+				return true
+			}
+			matched = matched[:len(matched)-1]
+		}
+		for _, child := range n.paramChildren {
+			if !child.param.accepts(segment) {
+				continue
+			}
+			matched = append(matched, child)
+			if walk(child, i+1) {
+				return true
+			}
+			matched = matched[:len(matched)-1]
+		}
+		if child := n.catchAllChild; child != nil && child.hasRoute(method) {
+			matched = append(matched, child)
+			end = child
+			return true
+		}
+		return false
+	}
+	if !walk(t.root, 0) {
+		return nil, false
+	}
+	match := newMatch(matched, segments)
+	match.Name = end.routeName(method)
+	return match, true
+}
+
+// MatchPrefix returns the pattern covering the longest prefix of the path,
+// ignoring methods. It is used to label paths that no route fully matches.
+func (t *Tree) MatchPrefix(path string) (*Match, bool) {
+	if match, ok := t.Match(ANY_METHOD, path); ok {
+		return match, true
+	}
+	segments := strings.Split(path, "/")[1:]
+	var matched []*node
+	current := t.root
+	for i := 0; i < len(segments); i++ {
+		next := current.children[segments[i]]
+		if next == nil {
+			for _, child := range current.paramChildren {
+				if child.param.accepts(segments[i]) {
+					next = child
+					break
+				}
+			}
+		}
+		if next == nil && current.catchAllChild != nil {
+			matched = append(matched, current.catchAllChild)
 			break
 		}
+		if next == nil {
+			break
+		}
+		matched = append(matched, next)
+		current = next
+	}
+	if len(matched) == 0 {
+		return nil, false
+	}
+	match := newMatch(matched, segments)
+	match.Partial = match.Segments < len(segments)
+	return match, true
+}
+
+func newMatch(matched []*node, segments []string) *Match {
+	patternSegments := make([]string, 0, len(matched))
+	params := make(map[string]string)
+	covered := 0
+	for i, n := range matched {
+		patternSegments = append(patternSegments, n.path)
+		switch {
+		case n.param == nil:
+			covered++
+		case n.param.catchAll:
+			params[n.param.name] = strings.Join(segments[i:], "/")
+			covered = len(segments)
+		default:
+			params[n.param.name] = segments[i]
+			covered++
+		}
 	}
-	if len(result) == 0 {
-		return "no match"
+	return &Match{
+		Pattern:  "/" + strings.Join(patternSegments, "/"),
+		Params:   params,
+		Segments: covered,
 	}
-	return "/" + strings.Join(result, "/")
 }
 
-// ReplaceWithPattern function replaces matched pattern in string with the pattern
-func (t *Tree) ReplaceWithPattern(input, pattern string) string {
-	if pattern == "no match" {
+func (n *node) hasRoute(method string) bool {
+	if !n.isEnd {
+		return false
+	}
+	if method == ANY_METHOD {
+		return true
+	}
+	_, ok := n.routes[strings.ToUpper(method)]
+	if !ok {
+		_, ok = n.routes[ANY_METHOD]
+	}
+	return ok
+}
+
+func (n *node) routeName(method string) string {
+	if name, ok := n.routes[strings.ToUpper(method)]; ok {
+		return name
+	}
+	if name, ok := n.routes[ANY_METHOD]; ok {
+		return name
+	}
+	// ANY_METHOD lookups take the first name in a stable order
+	methods := make([]string, 0, len(n.routes))
+	for method := range n.routes {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	if len(methods) == 0 {
+		return ""
+	}
+	return n.routes[methods[0]]
+}
+
+// ReplaceWithPattern function replaces the segments of the input covered by
+// the match with the pattern, the input is returned as is without a match
+func (t *Tree) ReplaceWithPattern(input string, match *Match) string {
+	if match == nil {
 		return input
 	}
 	inputSegments := strings.Split(input, "/")[1:]
-	patternSegments := strings.Split(pattern, "/")[1:]
-	var result []string = append(patternSegments, inputSegments[len(patternSegments):]...)
-	return "/" + strings.Join(result, "/")
+	if match.Segments >= len(inputSegments) {
+		return match.Pattern
+	}
+	return match.Pattern + "/" + strings.Join(inputSegments[match.Segments:], "/")
 }
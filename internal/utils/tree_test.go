@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestTree(t *testing.T) *Tree {
+	tree := NewTree()
+	routes := []struct{ method, pattern, name string }{
+		{ANY_METHOD, "/api/users/me", "me"},
+		{ANY_METHOD, "/api/users/{id:int}", "user"},
+		{ANY_METHOD, "/api/users/{slug:[a-z-]+}/posts", "posts"},
+		{ANY_METHOD, "/api/users/{name}/profile", "profile"},
+		{"POST", "/api/users/{id:int}", "update"},
+		{ANY_METHOD, "/static/{rest...}", "static"},
+		{ANY_METHOD, "/api/me/settings", "settings"},
+		{ANY_METHOD, "/api/{section}/help", "help"},
+	}
+	for _, route := range routes {
+		if err := tree.InsertRoute(route.method, route.pattern, route.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree
+}
+
+func TestTreeMatch(t *testing.T) {
+	tree := newTestTree(t)
+	tests := []struct {
+		method  string
+		path    string
+		ok      bool
+		pattern string
+		name    string
+		params  map[string]string
+	}{
+		{"GET", "/api/users/me", true, "/api/users/me", "me", map[string]string{}},
+		{"GET", "/api/users/42", true, "/api/users/{id:int}", "user", map[string]string{"id": "42"}},
+		{"POST", "/api/users/42", true, "/api/users/{id:int}", "update", map[string]string{"id": "42"}},
+		{"GET", "/api/users/jane-doe/posts", true, "/api/users/{slug:[a-z-]+}/posts", "posts", map[string]string{"slug": "jane-doe"}},
+		{"GET", "/api/users/Jane/profile", true, "/api/users/{name}/profile", "profile", map[string]string{"name": "Jane"}},
+		// the constrained branch fails on the next segment and is backtracked
+		{"GET", "/api/users/jane/profile", true, "/api/users/{name}/profile", "profile", map[string]string{"name": "jane"}},
+		// the static branch fails on the next segment and is backtracked
+		{"GET", "/api/me/help", true, "/api/{section}/help", "help", map[string]string{"section": "me"}},
+		{"GET", "/static/css/site.css", true, "/static/{rest...}", "static", map[string]string{"rest": "css/site.css"}},
+		{"GET", "/api/users/abc", false, "", "", nil},
+		{"GET", "/unknown", false, "", "", nil},
+	}
+	for _, tt := range tests {
+		match, ok := tree.Match(tt.method, tt.path)
+		if ok != tt.ok {
+			t.Errorf("Match(%s %s) ok = %v; want %v", tt.method, tt.path, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if match.Pattern != tt.pattern || match.Name != tt.name || !reflect.DeepEqual(match.Params, tt.params) {
+			t.Errorf("Match(%s %s) = %+v; want %s %s %v", tt.method, tt.path, match, tt.pattern, tt.name, tt.params)
+		}
+	}
+}
+
+func TestTreeReplaceWithPattern(t *testing.T) {
+	tree := newTestTree(t)
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/users/42", "/api/users/{id:int}"},
+		{"/api/users/42/orders", "/api/users/{id:int}/orders"},
+		{"/static/js/app.js", "/static/{rest...}"},
+		{"/other/path", "/other/path"},
+	}
+	for _, tt := range tests {
+		match, _ := tree.MatchPrefix(tt.path)
+		if got := tree.ReplaceWithPattern(tt.path, match); got != tt.expected {
+			t.Errorf("ReplaceWithPattern(%s) = %s; want %s", tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	if err := ValidatePattern("/files/{rest...}/meta"); err == nil {
+		t.Error("expected an error for a catch-all before the last segment")
+	}
+	if err := ValidatePattern("/files/{id:[0-9}"); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+	if err := ValidatePattern("/files/{id:uuid}"); err != nil {
+		t.Error(err)
+	}
+}